@@ -1,18 +1,26 @@
 // +build ignore
 
 // pack.go — creates the plugin .tar.gz with correct Unix permissions.
-// Run: go run pack.go
+// Run: go run pack.go [-reproducible]
 // Works on Windows, Linux, macOS.
+//
+// -reproducible writes every entry with the same modification time and with
+// uid/gid 0 and no user or group names, so two builds of the same files produce
+// a byte-identical archive. The time is SOURCE_DATE_EPOCH when set, otherwise
+// the Unix epoch.
 package main
 
 import (
 	"archive/tar"
 	"compress/gzip"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -21,6 +29,18 @@ const (
 )
 
 func main() {
+	reproducible := flag.Bool("reproducible", false, "normalize timestamps and owners for a byte-identical archive")
+	flag.Parse()
+
+	var modTime time.Time
+	if *reproducible {
+		var err error
+		if modTime, err = sourceDateEpoch(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	srcDir := filepath.Join("dist", pluginID)
 	outFile := filepath.Join("dist", pluginID+"-"+pluginVersion+".tar.gz")
 
@@ -34,13 +54,9 @@ func main() {
 		fmt.Fprintf(os.Stderr, "ERROR: create %s: %v\n", outFile, err)
 		os.Exit(1)
 	}
-	defer f.Close()
 
 	gw := gzip.NewWriter(f)
-	defer gw.Close()
-
 	tw := tar.NewWriter(gw)
-	defer tw.Close()
 
 	count := 0
 	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
@@ -52,6 +68,20 @@ func main() {
 		relPath, _ := filepath.Rel(filepath.Dir(srcDir), path)
 		relPath = filepath.ToSlash(relPath) // Windows backslash → forward slash
 
+		var file *os.File
+		if !info.IsDir() {
+			file, err = os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			// Re-stat the open file, so the header's size describes what is copied
+			// below rather than what the walk saw earlier.
+			if info, err = file.Stat(); err != nil {
+				return err
+			}
+		}
+
 		header, err := tar.FileInfoHeader(info, "")
 		if err != nil {
 			return err
@@ -66,18 +96,16 @@ func main() {
 		} else {
 			header.Mode = 0644
 		}
+		if *reproducible {
+			normalizeHeader(header, modTime)
+		}
 
 		if err := tw.WriteHeader(header); err != nil {
 			return err
 		}
 
-		if !info.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			if _, err := io.Copy(tw, file); err != nil {
+		if file != nil {
+			if err := copyExact(tw, file, header.Size, relPath); err != nil {
 				return err
 			}
 			count++
@@ -85,7 +113,18 @@ func main() {
 		return nil
 	})
 
+	// The archive is only complete once every writer has flushed.
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gw.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		os.Remove(outFile)
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 		os.Exit(1)
 	}
@@ -93,6 +132,49 @@ func main() {
 	fmt.Printf("✅  %s (%d files)\n", outFile, count)
 }
 
+// copyExact copies exactly size bytes, the size in the file's tar header, into
+// the archive. A file that shrank or grew since it was stat-ed, e.g. one a
+// parallel build is still writing, fails instead of leaving a corrupt archive.
+func copyExact(w io.Writer, r io.Reader, size int64, name string) error {
+	n, err := io.CopyN(w, r, size)
+	if err == io.EOF {
+		return fmt.Errorf("%s shrank while packing: read %d of %d bytes", name, n, size)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if extra, _ := r.Read(make([]byte, 1)); extra > 0 {
+		return fmt.Errorf("%s grew while packing: more than the %d bytes in its header", name, size)
+	}
+	return nil
+}
+
+// sourceDateEpoch returns the time in SOURCE_DATE_EPOCH, the convention build
+// systems use to pin timestamps, or the Unix epoch when it isn't set.
+func sourceDateEpoch() (time.Time, error) {
+	v := strings.TrimSpace(os.Getenv("SOURCE_DATE_EPOCH"))
+	if v == "" {
+		return time.Unix(0, 0), nil
+	}
+	secs, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || secs < 0 {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q", v)
+	}
+	return time.Unix(secs, 0), nil
+}
+
+// normalizeHeader drops everything FileInfoHeader copies from the build machine
+// rather than from the file's contents: times, owner ids and names.
+func normalizeHeader(header *tar.Header, modTime time.Time) {
+	header.ModTime = modTime
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	header.PAXRecords = nil
+	header.Format = tar.FormatUnknown
+}
+
 // isExecutable returns true for server binaries (no extension or .exe).
 func isExecutable(archivePath string) bool {
 	// server/dist/plugin-linux-amd64, plugin-darwin-arm64, etc.
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// pluginUploaderID is the CreatorId Mattermost assigns to files uploaded via API.UploadFile.
+	pluginUploaderID = "nouser"
+
+	// orphanMinAge keeps uploads that are still waiting for CreatePost out of the report.
+	orphanMinAge = time.Hour
+
+	// kvOrphanPrefix marks a voice upload whose CreatePost failed, so the orphan
+	// report lists it right away instead of after orphanMinAge.
+	kvOrphanPrefix         = "vm_orphan_"
+	orphanMarkerTTLSeconds = 30 * 24 * 60 * 60
+
+	orphanScanPageSize = 200
+	orphanScanMaxPages = 50
+)
+
+// orphanedFile describes a voice upload that never got attached to a post.
+type orphanedFile struct {
+	FileID   string `json:"file_id"`
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	CreateAt int64  `json:"create_at"`
+}
+
+// handleAdmin routes /api/v1/admin/* endpoints. All of them require a system admin.
+func (p *Plugin) handleAdmin(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !p.isSystemAdmin(userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/api/v1/admin") {
+	case "/orphans":
+		p.handleOrphanReport(w, r)
+	case "/export":
+		p.handleExport(w, r)
+	case "/transcribe/last-error":
+		p.handleLastTranscriptionError(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleOrphanReport serves GET /api/v1/admin/orphans, a report of voice uploads
+// left without a post (e.g. when CreatePost failed after UploadFile succeeded).
+// The plugin API has no call to delete or detach files, so nothing is changed;
+// the admin removes the listed files from the file store.
+func (p *Plugin) handleOrphanReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	orphans, err := p.findOrphanedVoiceFiles(time.Now().Add(-orphanMinAge))
+	if err != nil {
+		p.API.LogError("Orphan scan failed", "err", err.Error())
+		http.Error(w, "Orphan scan failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"orphans": orphans,
+		"count":   len(orphans),
+	})
+}
+
+// markOrphanedUpload records a voice upload whose post couldn't be created, so
+// the orphan report lists it without waiting for orphanMinAge.
+func (p *Plugin) markOrphanedUpload(fileID string) {
+	if fileID == "" {
+		return
+	}
+	if appErr := p.API.KVSetWithExpiry(kvOrphanPrefix+fileID, []byte{1}, orphanMarkerTTLSeconds); appErr != nil {
+		p.API.LogWarn("Failed to record orphaned upload", "file_id", fileID, "err", appErr.Error())
+	}
+}
+
+// findOrphanedVoiceFiles pages through plugin-uploaded files and returns voice
+// uploads that aren't attached to any post and were created before the cutoff or
+// marked by markOrphanedUpload.
+func (p *Plugin) findOrphanedVoiceFiles(before time.Time) ([]orphanedFile, error) {
+	cutoff := model.GetMillisForTime(before)
+	opts := &model.GetFileInfosOptions{
+		UserIds: []string{pluginUploaderID},
+		SortBy:  model.FileinfoSortByCreated,
+	}
+
+	orphans := []orphanedFile{}
+	for page := 0; page < orphanScanMaxPages; page++ {
+		infos, appErr := p.API.GetFileInfos(page, orphanScanPageSize, opts)
+		if appErr != nil {
+			return nil, appErr
+		}
+		for _, fi := range infos {
+			if fi.PostId != "" || fi.DeleteAt != 0 || !strings.HasPrefix(fi.Name, "voice_") {
+				continue
+			}
+			if fi.CreateAt >= cutoff {
+				if marker, appErr := p.API.KVGet(kvOrphanPrefix + fi.Id); appErr != nil || len(marker) == 0 {
+					continue
+				}
+			}
+			orphans = append(orphans, orphanedFile{
+				FileID:   fi.Id,
+				Name:     fi.Name,
+				Size:     fi.Size,
+				CreateAt: fi.CreateAt,
+			})
+		}
+		if len(infos) < orphanScanPageSize {
+			break
+		}
+	}
+	return orphans, nil
+}
+
+// effectiveConfig returns the resolved values the plugin actually uses, after the
+// getXxx accessors apply defaults and limits. Secrets are reported only as set/unset.
+func (p *Plugin) effectiveConfig(cfg *Configuration) map[string]any {
+	extraPostProps, extraPropsErr := cfg.getExtraPostProps()
+	preferredMimes, _ := cfg.getPreferredRecordingMimes()
+	fallbackAccept, _ := cfg.getFallbackAccept()
+	mention, _ := cfg.getVoiceMention()
+	replacementRules, invalidReplacements := cfg.getTranscriptReplacements()
+	roleDurations, invalidRoleDurations := cfg.getRoleMaxDurations()
+	providerCreds, invalidProviderCreds := cfg.getProviderCredentials()
+	credentialProviders := make([]string, 0, len(providerCreds))
+	for provider := range providerCreds {
+		credentialProviders = append(credentialProviders, provider)
+	}
+	sort.Strings(credentialProviders)
+	transcriptionTimeout := "auto"
+	if intFromCfg(cfg.TranscriptionTimeoutSeconds, 0) > 0 {
+		transcriptionTimeout = cfg.getTranscriptionTimeout(0).String()
+	}
+	return map[string]any{
+		"maxDurationSeconds":              cfg.getMaxDurationSeconds(),
+		"roleMaxDurationSeconds":          roleDurations,
+		"roleMaxDurationSecondsInvalid":   invalidRoleDurations,
+		"maxFileSizeBytes":                cfg.getMaxFileSizeBytes(),
+		"recordingSizeWarningBytes":       cfg.getRecordingSizeWarningBytes(),
+		"maxConcurrentUploads":            cfg.getMaxConcurrentUploads(),
+		"channelVoiceMessagesPerHour":     cfg.getChannelVoiceMessagesPerHour(),
+		"mobileTokenTTLSeconds":           cfg.getMobileTokenTTLSeconds(),
+		"mobileTokenCleanupMinutes":       int(cfg.getMobileTokenCleanupInterval().Minutes()),
+		"successMessageTemplate":          strings.TrimSpace(cfg.SuccessMessageTemplate),
+		"successMessageSeconds":           int(cfg.getSuccessMessageLifetime().Seconds()),
+		"mobileUploadRetries":             cfg.getMobileUploadRetries(),
+		"retryFailedFileUpload":           cfg.RetryFailedFileUpload,
+		"requireSessionForMobileUpload":   cfg.RequireSessionForMobileUpload,
+		"trustProxyHeaders":               cfg.TrustProxyHeaders,
+		"requirePlaybackBeforeSend":       cfg.getPlaybackRequirement(),
+		"preferredRecordingMimes":         preferredMimes,
+		"fallbackAcceptTypes":             fallbackAccept,
+		"recordingPageNoticeSet":          strings.TrimSpace(cfg.RecordingPageNotice) != "",
+		"hideChannelNameOnRecordPage":     cfg.HideChannelNameOnRecordPage,
+		"sentScreenMessage":               strings.TrimSpace(cfg.SentScreenMessage),
+		"recordingBeep":                   cfg.RecordingBeep,
+		"prewarmMic":                      cfg.PrewarmMic,
+		"disableRecorderFallback":         cfg.DisableRecorderFallback,
+		"startCountdownSeconds":           cfg.getStartCountdownSeconds(),
+		"silenceAutoStopSeconds":          cfg.getSilenceAutoStopSeconds(),
+		"silenceThreshold":                cfg.getSilenceThreshold(),
+		"recordingGain":                   cfg.getRecordingGain(),
+		"normalizeLoudness":               cfg.NormalizeLoudness,
+		"allowedRoles":                    cfg.AllowedRoles,
+		"authorizationWebhookSet":         strings.TrimSpace(cfg.AuthorizationWebhookURL) != "",
+		"authorizationWebhookFailOpen":    cfg.AuthorizationWebhookFailOpen,
+		"mentionOnVoiceMessage":           mention,
+		"silentChannels":                  strings.TrimSpace(cfg.SilentChannels),
+		"archiveChannelID":                strings.TrimSpace(cfg.ArchiveChannelID),
+		"convertToMP3ForArchive":          cfg.ConvertToMP3ForArchive,
+		"voiceDigestChannelID":            strings.TrimSpace(cfg.VoiceDigestChannelID),
+		"voiceDigestChannels":             cfg.getVoiceDigestChannels(),
+		"voiceDigestTime":                 strings.TrimSpace(cfg.VoiceDigestTime),
+		"voiceDigestTimeValid":            strings.TrimSpace(cfg.VoiceDigestTime) == "" || validDigestTime(cfg.VoiceDigestTime),
+		"groupConsecutiveVoice":           cfg.GroupConsecutiveVoice,
+		"extraPostProps":                  extraPostProps,
+		"extraPostPropsValid":             extraPropsErr == nil,
+		"enableTranscription":             cfg.EnableTranscription,
+		"transcriptionPermission":         strings.TrimSpace(cfg.TranscriptionPermission),
+		"autoTranscribe":                  cfg.AutoTranscribe,
+		"dictationAttachAudio":            cfg.DictationAttachAudio,
+		"autoTranscribeConcurrency":       cap(p.transcribeSem),
+		"autoTranscribePutInMessage":      cfg.getTranscriptInMessage(),
+		"maxTranscriptChars":              cfg.getMaxTranscriptChars(),
+		"transcriptWordTimestamps":        cfg.TranscriptWordTimestamps,
+		"autoTranslateToEnglish":          cfg.AutoTranslateToEnglish,
+		"transcriptReplacementRules":      len(replacementRules),
+		"transcriptReplacementsInvalid":   invalidReplacements,
+		"maxStoredSegments":               cfg.getMaxStoredSegments(),
+		"maxTranscriptionsPerChannel":     cfg.getMaxTranscriptionsPerChannel(),
+		"autoTranscribeQuietHours":        strings.TrimSpace(cfg.AutoTranscribeQuietHours),
+		"autoTranscribeQuietHoursValid":   cfg.getQuietHours() != nil,
+		"autoTranscribeSkippedMessageSet": strings.TrimSpace(cfg.AutoTranscribeSkippedMessage) != "",
+		"transcriptionProvider":           cfg.TranscriptionProvider,
+		"transcriptionURL":                cfg.getTranscriptionURL(),
+		"transcriptionModel":              cfg.getTranscriptionModel(),
+		"transcriptionModelWarning":       cfg.transcriptionModelWarning(),
+		"transcriptionLanguage":           strings.TrimSpace(cfg.TranscriptionLanguage),
+		"allowedTranscriptionLanguages":   cfg.getAllowedTranscriptionLanguages(),
+		"suppressDisallowedLanguages":     cfg.SuppressDisallowedLanguages,
+		"transcriptionMaxDurationSeconds": cfg.getTranscriptionMaxDur(),
+		"transcriptionCostPerMinute":      cfg.getTranscriptionCostPerMinute(),
+		"disableTranscriptCache":          cfg.DisableTranscriptCache,
+		"deleteAudioAfterTranscription":   cfg.DeleteAudioAfterTranscription,
+		"formatTranscript":                cfg.FormatTranscript,
+		"transcribeViaURL":                cfg.TranscribeViaURL,
+		"transcribeViaURLSupported":       urlAudioProviders[cfg.TranscriptionProvider] && p.getSiteURL() != "",
+		"searchIndexWebhookSet":           strings.TrimSpace(cfg.SearchIndexWebhookURL) != "",
+		"transcriptionTimeout":            transcriptionTimeout,
+		"transcriptionMaxRetries":         cfg.getTranscriptionMaxRetries(),
+		"transcriptionBackoffBase":        cfg.transcriptionBackoff(1).String(),
+		"chunkedTranscription":            cfg.EnableChunkedTranscription,
+		"transcriptionChunkSeconds":       cfg.getTranscriptionChunkSeconds(),
+		"transcriptionAPIKeySet":          len(cfg.getTranscriptionAPIKeys()) > 0,
+		"transcriptionAPIKeyCount":        len(cfg.getTranscriptionAPIKeys()),
+		"providerCredentials":             credentialProviders,
+		"providerCredentialsInvalid":      invalidProviderCreds,
+	}
+}
+
+// handleEffectiveConfig serves GET /api/v1/config/effective (system admins only).
+func (p *Plugin) handleEffectiveConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !p.isSystemAdmin(userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(p.effectiveConfig(p.getConfig()))
+}
+
+// executeConfigCommand handles "/voice config": the effective configuration as an
+// ephemeral table, for system admins only.
+func (p *Plugin) executeConfigCommand(args *model.CommandArgs) *model.CommandResponse {
+	if !p.isSystemAdmin(args.UserId) {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "⛔ Only system admins can view the plugin configuration.",
+			ChannelId:    args.ChannelId,
+		}
+	}
+
+	values := p.effectiveConfig(p.getConfig())
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString("#### Voice Message — effective configuration\n\n| Setting | Value |\n|---|---|\n")
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "| %s | `%v` |\n", k, values[k])
+	}
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         sb.String(),
+		ChannelId:    args.ChannelId,
+	}
+}
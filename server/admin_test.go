@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrphanReport(t *testing.T) {
+	old := model.GetMillisForTime(time.Now().Add(-2 * orphanMinAge))
+	young := model.GetMillis()
+	api := newFakeKVAPI()
+	api.On("GetFileInfos", 0, orphanScanPageSize, mock.Anything).Return([]*model.FileInfo{
+		{Id: "old", Name: "voice_1.webm", CreateAt: old},
+		{Id: "attached", Name: "voice_2.webm", CreateAt: old, PostId: "post1"},
+		{Id: "pending", Name: "voice_3.webm", CreateAt: young},
+		{Id: "failed", Name: "voice_4.webm", CreateAt: young},
+		{Id: "other", Name: "report.pdf", CreateAt: old},
+	}, nil)
+	api.On("KVSetWithExpiry", kvOrphanPrefix+"failed", []byte{1}, int64(orphanMarkerTTLSeconds)).Return(func(key string, value []byte, _ int64) *model.AppError {
+		api.kv[key] = value
+		return nil
+	})
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	// An upload whose CreatePost failed is reported before orphanMinAge.
+	p.markOrphanedUpload("failed")
+
+	w := httptest.NewRecorder()
+	p.handleOrphanReport(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/orphans", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Orphans []orphanedFile `json:"orphans"`
+		Count   int            `json:"count"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	var ids []string
+	for _, o := range resp.Orphans {
+		ids = append(ids, o.FileID)
+	}
+	assert.Equal(t, []string{"old", "failed"}, ids)
+	assert.Equal(t, 2, resp.Count)
+
+	w = httptest.NewRecorder()
+	p.handleOrphanReport(w, httptest.NewRequest(http.MethodPost, "/api/v1/admin/orphans", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code, "the report never changes anything")
+}
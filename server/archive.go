@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	botUsername    = "voice-message"
+	botDisplayName = "Voice Message"
+
+	// kvArchivePostPrefix maps an original voice message to its archive copy.
+	kvArchivePostPrefix = "vm_archive_"
+)
+
+// ensureBot creates or updates the plugin's bot account, which posts archive copies
+// and digests.
+func (p *Plugin) ensureBot() {
+	botID, err := p.API.EnsureBotUser(&model.Bot{
+		Username:    botUsername,
+		DisplayName: botDisplayName,
+		Description: "Posts archive copies and daily digests of voice messages.",
+	})
+	if err != nil {
+		p.API.LogWarn("Failed to ensure bot user; voice message archiving is unavailable", "err", err.Error())
+		return
+	}
+	p.botID = botID
+}
+
+// archiveVoiceMessage copies a freshly posted voice message or dictation into
+// ArchiveChannelID with a permalink back to the original; with
+// ConvertToMP3ForArchive the copy is MP3. Failures are logged and never affect
+// the user's upload. Runs in its own goroutine.
+func (p *Plugin) archiveVoiceMessage(cfg *Configuration, original *model.Post, data []byte, filename string) {
+	archiveID := strings.TrimSpace(cfg.ArchiveChannelID)
+	if archiveID == "" || archiveID == original.ChannelId {
+		return
+	}
+	if p.botID == "" {
+		p.API.LogWarn("Voice message not archived: no bot user", "post_id", original.Id)
+		return
+	}
+
+	archive, appErr := p.API.GetChannel(archiveID)
+	if appErr != nil || archive.DeleteAt != 0 {
+		p.API.LogWarn("Voice message not archived: archive channel missing or deleted", "post_id", original.Id, "archive_channel_id", archiveID)
+		return
+	}
+	if err := p.ensureBotInChannel(archiveID); err != nil {
+		p.API.LogWarn("Voice message not archived: bot can't join archive channel", "post_id", original.Id, "archive_channel_id", archiveID, "err", err.Error())
+		return
+	}
+
+	data, filename = p.exportAudio(context.Background(), cfg, data, filename)
+	fileInfo, appErr := p.uploadVoiceFile(cfg, data, archiveID, filename)
+	if appErr != nil {
+		p.API.LogError("Archive upload failed", "post_id", original.Id, "class", classifyUploadError(appErr).String(), "err", appErr.Error())
+		return
+	}
+
+	post := &model.Post{
+		UserId:    p.botID,
+		ChannelId: archiveID,
+		Message:   p.archiveMessage(original),
+		FileIds:   []string{fileInfo.Id},
+		Type:      "custom_voice_message",
+		Props:     model.StringInterface{"voice_archived_from": original.Id},
+	}
+	for _, key := range []string{"voice_duration", "voice_mime_type", "voice_tags"} {
+		if v := original.GetProp(key); v != nil {
+			post.AddProp(key, v)
+		}
+	}
+	if strings.HasSuffix(filename, ".mp3") {
+		post.AddProp("voice_mime_type", "audio/mpeg")
+	}
+	// A dictation's transcript is the original's message.
+	if dictated, _ := original.GetProp("voice_dictated").(bool); dictated {
+		post.AddProp("voice_dictated", true)
+		post.AddProp("voice_transcript", original.Message)
+	}
+
+	created, appErr := p.API.CreatePost(post)
+	if appErr != nil {
+		p.API.LogError("Archive CreatePost failed", "post_id", original.Id, "err", appErr.Error(), "orphaned_file_id", fileInfo.Id)
+		p.markOrphanedUpload(fileInfo.Id)
+		return
+	}
+	if appErr := p.API.KVSet(kvArchivePostPrefix+original.Id, []byte(created.Id)); appErr != nil {
+		p.API.LogWarn("Failed to record archive post; transcript won't be copied", "post_id", original.Id, "err", appErr.Error())
+	}
+}
+
+// ensureBotInChannel adds the bot to a channel it isn't a member of yet.
+func (p *Plugin) ensureBotInChannel(channelID string) error {
+	if _, appErr := p.API.GetChannelMember(channelID, p.botID); appErr == nil {
+		return nil
+	}
+	if _, appErr := p.API.AddChannelMember(channelID, p.botID); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// archiveMessage describes where an archived voice message came from.
+func (p *Plugin) archiveMessage(original *model.Post) string {
+	author := original.UserId
+	if u, appErr := p.API.GetUser(original.UserId); appErr == nil {
+		author = "@" + u.Username
+	}
+	where := original.ChannelId
+	if ch, appErr := p.API.GetChannel(original.ChannelId); appErr == nil {
+		where = ch.DisplayName
+		if ch.Type == model.ChannelTypeOpen || ch.Type == model.ChannelTypePrivate {
+			where = "~" + ch.Name
+		}
+	}
+	kind := "Voice message"
+	if dictated, _ := original.GetProp("voice_dictated").(bool); dictated {
+		kind = "Dictation"
+	}
+	return fmt.Sprintf("🗄️ %s from %s in %s\n%s", kind, author, where, p.buildPostPermalink(original.Id))
+}
+
+// archiveTranscript copies a voice message's transcript onto its archive copy, if any.
+func (p *Plugin) archiveTranscript(original *model.Post) {
+	b, appErr := p.API.KVGet(kvArchivePostPrefix + original.Id)
+	if appErr != nil || len(b) == 0 {
+		return
+	}
+	archived, appErr := p.API.GetPost(string(b))
+	if appErr != nil {
+		return
+	}
+	for _, key := range []string{"voice_transcript", "voice_transcript_formatted", "voice_transcript_confidence", "voice_transcript_segments", "voice_transcript_en", "voice_transcript_lang"} {
+		if v := original.GetProp(key); v != nil {
+			archived.AddProp(key, v)
+		}
+	}
+	if _, appErr := p.API.UpdatePost(archived); appErr != nil {
+		p.API.LogWarn("Failed to copy transcript to archive post", "post_id", original.Id, "err", appErr.Error())
+	}
+}
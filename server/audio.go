@@ -0,0 +1,488 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	wavFormatPCM       = 1
+	wavFormatIEEEFloat = 3
+
+	// normalizeTargetPeak is the peak level (≈ -1 dBFS) quiet recordings are raised to.
+	normalizeTargetPeak = 0.89
+	// normalizeMaxGain caps amplification so near-silent clips don't turn into loud noise.
+	normalizeMaxGain = 20.0
+	// normalizeMinGain skips recordings that are already close to the target level.
+	normalizeMinGain = 1.05
+
+	// durationToleranceSeconds and durationToleranceRatio bound how far a
+	// client-reported duration may drift from the probed one; the larger applies.
+	durationToleranceSeconds = 2.0
+	durationToleranceRatio   = 0.1
+
+	// minPlausibleAudioSeconds, minUnknownAudioBytes and oggHeaderPages bound what
+	// audioLooksEmpty accepts as a real recording. Opus and Vorbis streams spend
+	// their first pages on headers.
+	minPlausibleAudioSeconds = 0.1
+	minUnknownAudioBytes     = 1024
+	oggHeaderPages           = 2
+)
+
+// wavInfo describes the PCM layout of a RIFF/WAVE file.
+type wavInfo struct {
+	format        uint16
+	channels      int
+	sampleRate    int
+	bitsPerSample int
+	dataOffset    int
+	dataLen       int
+}
+
+// durationSeconds returns the playback length implied by the data chunk size.
+func (w *wavInfo) durationSeconds() float64 {
+	bytesPerSec := w.sampleRate * w.channels * w.bitsPerSample / 8
+	if bytesPerSec <= 0 {
+		return 0
+	}
+	return float64(w.dataLen) / float64(bytesPerSec)
+}
+
+// parseWAV walks the RIFF chunks and returns the fmt/data layout.
+// The data length is clamped to the bytes actually present, since streaming
+// encoders often leave a placeholder size in the header.
+func parseWAV(data []byte) (*wavInfo, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a RIFF/WAVE file")
+	}
+
+	info := &wavInfo{}
+	haveFmt := false
+	pos := 12
+	for pos+8 <= len(data) {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := pos + 8
+
+		switch id {
+		case "fmt ":
+			if size < 16 || body+16 > len(data) {
+				return nil, fmt.Errorf("truncated fmt chunk")
+			}
+			info.format = binary.LittleEndian.Uint16(data[body:])
+			info.channels = int(binary.LittleEndian.Uint16(data[body+2:]))
+			info.sampleRate = int(binary.LittleEndian.Uint32(data[body+4:]))
+			info.bitsPerSample = int(binary.LittleEndian.Uint16(data[body+14:]))
+			haveFmt = true
+		case "data":
+			if !haveFmt {
+				return nil, fmt.Errorf("data chunk before fmt chunk")
+			}
+			if size < 0 || body+size > len(data) {
+				size = len(data) - body
+			}
+			info.dataOffset = body
+			info.dataLen = size
+			return info, nil
+		}
+
+		if size < 0 || body+size > len(data) {
+			break
+		}
+		// Chunks are word-aligned.
+		pos = body + size + size%2
+	}
+	return nil, fmt.Errorf("no data chunk")
+}
+
+// probeAudioDuration reads the playback length from the audio container. It only
+// understands formats whose length can be read without decoding; ok is false otherwise.
+func probeAudioDuration(data []byte) (seconds float64, ok bool) {
+	if info, err := parseWAV(data); err == nil {
+		if d := info.durationSeconds(); d > 0 {
+			return d, true
+		}
+	}
+	if l, err := parseWebM(data); err == nil {
+		if d := l.durationSeconds(); d > 0 {
+			return d, true
+		}
+	}
+	if d, ok := oggDurationSeconds(data); ok {
+		return d, true
+	}
+	if d, ok := mp4DurationSeconds(data); ok {
+		return d, true
+	}
+	return 0, false
+}
+
+// durationsDiverge reports whether a client-reported duration is further from the
+// probed one than the tolerance allows.
+func durationsDiverge(client, probed float64) bool {
+	return math.Abs(client-probed) > math.Max(durationToleranceSeconds, probed*durationToleranceRatio)
+}
+
+// audioLooksEmpty reports whether data is too short to hold any audio: a container
+// with headers but no samples, as some iOS MediaRecorder builds produce, or a
+// few bytes of something unrecognized. The reason is for logs.
+func audioLooksEmpty(data []byte) (string, bool) {
+	switch {
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		info, err := parseWAV(data)
+		if err != nil {
+			return "wav: " + err.Error(), true
+		}
+		if info.durationSeconds() < minPlausibleAudioSeconds {
+			return "wav: no samples", true
+		}
+	case len(data) >= 4 && binary.BigEndian.Uint32(data) == ebmlIDHeader:
+		l, err := parseWebM(data)
+		if err != nil {
+			return "webm: " + err.Error(), true
+		}
+		if !l.haveBlocks {
+			return "webm: no cluster with audio blocks", true
+		}
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		if !mp4HasMediaData(data) {
+			return "mp4: no media data", true
+		}
+	case len(data) >= 4 && string(data[0:4]) == "OggS":
+		if oggPageCount(data) <= oggHeaderPages {
+			return "ogg: header pages only", true
+		}
+	default:
+		if len(data) < minUnknownAudioBytes {
+			return fmt.Sprintf("unrecognized: %d bytes", len(data)), true
+		}
+	}
+	return "", false
+}
+
+// mp4HasMediaData walks the top-level boxes looking for a non-empty mdat.
+func mp4HasMediaData(data []byte) bool {
+	pos := 0
+	for pos+8 <= len(data) {
+		size := int64(binary.BigEndian.Uint32(data[pos:]))
+		header := int64(8)
+		switch size {
+		case 0: // box extends to the end of the file
+			size = int64(len(data) - pos)
+		case 1: // 64-bit size follows the type
+			if pos+16 > len(data) {
+				return false
+			}
+			size = int64(binary.BigEndian.Uint64(data[pos+8:]))
+			header = 16
+		}
+		if size < header {
+			return false
+		}
+		if string(data[pos+4:pos+8]) == "mdat" {
+			return int64(len(data)-pos) > header && size > header
+		}
+		if size > int64(len(data)-pos) {
+			return false
+		}
+		pos += int(size)
+	}
+	return false
+}
+
+// oggPageCount counts the complete pages at the start of an Ogg stream.
+func oggPageCount(data []byte) int {
+	return walkOggPages(data, nil)
+}
+
+// walkOggPages calls fn, if set, with the header and body of each complete page at
+// the start of an Ogg stream and returns how many there were.
+func walkOggPages(data []byte, fn func(header, body []byte)) int {
+	pages, pos := 0, 0
+	for pos+27 <= len(data) && string(data[pos:pos+4]) == "OggS" {
+		segments := int(data[pos+26])
+		if pos+27+segments > len(data) {
+			break
+		}
+		size := 27 + segments
+		for _, lacing := range data[pos+27 : pos+27+segments] {
+			size += int(lacing)
+		}
+		if pos+size > len(data) {
+			break
+		}
+		if fn != nil {
+			fn(data[pos:pos+27], data[pos+27+segments:pos+size])
+		}
+		pages++
+		pos += size
+	}
+	return pages
+}
+
+// oggDurationSeconds reads the playback length of an Ogg Opus or Vorbis stream from
+// the granule position of the last complete page of its first logical stream.
+func oggDurationSeconds(data []byte) (float64, bool) {
+	var serial uint32
+	var rate float64
+	var preSkip, last int64
+	first := true
+	walkOggPages(data, func(header, body []byte) {
+		pageSerial := binary.LittleEndian.Uint32(header[14:])
+		granule := int64(binary.LittleEndian.Uint64(header[6:]))
+		if first {
+			first = false
+			serial = pageSerial
+			switch {
+			case len(body) >= 12 && string(body[:8]) == "OpusHead":
+				// Opus granule positions always count 48 kHz samples.
+				rate = 48000
+				preSkip = int64(binary.LittleEndian.Uint16(body[10:]))
+			case len(body) >= 16 && string(body[:7]) == "\x01vorbis":
+				rate = float64(binary.LittleEndian.Uint32(body[12:]))
+			}
+			return
+		}
+		// -1 marks a page on which no packet ends.
+		if pageSerial == serial && granule >= 0 {
+			last = granule
+		}
+	})
+	if rate <= 0 || last <= preSkip {
+		return 0, false
+	}
+	return float64(last-preSkip) / rate, true
+}
+
+// mp4DurationSeconds reads the playback length from the movie header (moov/mvhd).
+// Fragmented files, as some recorders write, leave it at zero and are not probed.
+func mp4DurationSeconds(data []byte) (float64, bool) {
+	mvhd := findMP4Box(findMP4Box(data, "moov"), "mvhd")
+	if len(mvhd) < 4 {
+		return 0, false
+	}
+	var timescale uint32
+	var duration uint64
+	switch mvhd[0] {
+	case 0:
+		if len(mvhd) < 20 {
+			return 0, false
+		}
+		timescale = binary.BigEndian.Uint32(mvhd[12:])
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:]))
+	case 1:
+		if len(mvhd) < 32 {
+			return 0, false
+		}
+		timescale = binary.BigEndian.Uint32(mvhd[20:])
+		duration = binary.BigEndian.Uint64(mvhd[24:])
+	default:
+		return 0, false
+	}
+	if timescale == 0 || duration == 0 || duration == math.MaxUint32 || duration == math.MaxUint64 {
+		return 0, false
+	}
+	return float64(duration) / float64(timescale), true
+}
+
+// findMP4Box returns the body of the first complete box of the given type among
+// the boxes in data, or nil.
+func findMP4Box(data []byte, boxType string) []byte {
+	pos := 0
+	for pos+8 <= len(data) {
+		size := uint64(binary.BigEndian.Uint32(data[pos:]))
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data) - pos)
+		case 1:
+			if pos+16 > len(data) {
+				return nil
+			}
+			size = binary.BigEndian.Uint64(data[pos+8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)-pos) {
+			return nil
+		}
+		if string(data[pos+4:pos+8]) == boxType {
+			return data[pos+int(header) : pos+int(size)]
+		}
+		pos += int(size)
+	}
+	return nil
+}
+
+// audioContainer names the container format of data, for logs.
+func audioContainer(data []byte) string {
+	switch {
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return "wav"
+	case len(data) >= 4 && binary.BigEndian.Uint32(data) == ebmlIDHeader:
+		return "webm"
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		return "mp4"
+	case len(data) >= 4 && string(data[0:4]) == "OggS":
+		return "ogg"
+	default:
+		return "unknown"
+	}
+}
+
+// normalizeWAVPeak applies peak normalization to 16-bit PCM WAV data.
+// It returns a new buffer and true when gain was applied; any other input
+// (compressed containers, other bit depths, already-loud audio) is returned
+// unchanged with false.
+func normalizeWAVPeak(data []byte) ([]byte, bool) {
+	info, err := parseWAV(data)
+	if err != nil || info.format != wavFormatPCM || info.bitsPerSample != 16 {
+		return data, false
+	}
+
+	samples := data[info.dataOffset : info.dataOffset+info.dataLen-info.dataLen%2]
+	peak := 0
+	for i := 0; i+1 < len(samples); i += 2 {
+		v := int(int16(binary.LittleEndian.Uint16(samples[i:])))
+		if v < 0 {
+			v = -v
+		}
+		if v > peak {
+			peak = v
+		}
+	}
+	if peak == 0 {
+		return data, false
+	}
+
+	gain := normalizeTargetPeak * math.MaxInt16 / float64(peak)
+	if gain > normalizeMaxGain {
+		gain = normalizeMaxGain
+	}
+	if gain < normalizeMinGain {
+		return data, false
+	}
+
+	out := make([]byte, len(data))
+	copy(out, data)
+	dst := out[info.dataOffset : info.dataOffset+len(samples)]
+	for i := 0; i+1 < len(dst); i += 2 {
+		v := float64(int16(binary.LittleEndian.Uint16(dst[i:]))) * gain
+		if v > math.MaxInt16 {
+			v = math.MaxInt16
+		} else if v < math.MinInt16 {
+			v = math.MinInt16
+		}
+		binary.LittleEndian.PutUint16(dst[i:], uint16(int16(math.Round(v))))
+	}
+	return out, true
+}
+
+// splitWAV cuts PCM or float WAV data into consecutive chunks of at most
+// chunkSeconds, each a standalone file with a canonical 44-byte header. Cuts land
+// on frame boundaries. It returns nil when the input can't be split this way.
+func splitWAV(data []byte, chunkSeconds int) [][]byte {
+	info, err := parseWAV(data)
+	if err != nil || chunkSeconds <= 0 {
+		return nil
+	}
+	if info.format != wavFormatPCM && info.format != wavFormatIEEEFloat {
+		return nil
+	}
+	blockAlign := info.channels * info.bitsPerSample / 8
+	if blockAlign <= 0 || info.sampleRate <= 0 {
+		return nil
+	}
+
+	chunkBytes := chunkSeconds * info.sampleRate * blockAlign
+	samples := data[info.dataOffset : info.dataOffset+info.dataLen-info.dataLen%blockAlign]
+	var chunks [][]byte
+	for start := 0; start < len(samples); start += chunkBytes {
+		end := start + chunkBytes
+		if end > len(samples) {
+			end = len(samples)
+		}
+		chunks = append(chunks, buildWAV(info, samples[start:end]))
+	}
+	return chunks
+}
+
+// buildWAV wraps raw sample data in a minimal RIFF/WAVE header using info's layout.
+func buildWAV(info *wavInfo, samples []byte) []byte {
+	blockAlign := info.channels * info.bitsPerSample / 8
+	out := make([]byte, 44+len(samples))
+	copy(out[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(36+len(samples)))
+	copy(out[8:12], "WAVE")
+	copy(out[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(out[16:], 16)
+	binary.LittleEndian.PutUint16(out[20:], info.format)
+	binary.LittleEndian.PutUint16(out[22:], uint16(info.channels))
+	binary.LittleEndian.PutUint32(out[24:], uint32(info.sampleRate))
+	binary.LittleEndian.PutUint32(out[28:], uint32(info.sampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(out[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(out[34:], uint16(info.bitsPerSample))
+	copy(out[36:40], "data")
+	binary.LittleEndian.PutUint32(out[40:], uint32(len(samples)))
+	copy(out[44:], samples)
+	return out
+}
+
+// wavPeaks splits the samples of a PCM or float WAV file into buckets and returns
+// each bucket's peak amplitude (across channels), scaled so the loudest bucket is 1.
+func wavPeaks(data []byte, buckets int) ([]float64, error) {
+	info, err := parseWAV(data)
+	if err != nil {
+		return nil, err
+	}
+	bytesPerSample := info.bitsPerSample / 8
+	frameSize := bytesPerSample * info.channels
+	if frameSize <= 0 || buckets <= 0 {
+		return nil, fmt.Errorf("invalid sample layout")
+	}
+
+	var sample func(b []byte) float64
+	switch {
+	case info.format == wavFormatPCM && info.bitsPerSample == 8:
+		sample = func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }
+	case info.format == wavFormatPCM && info.bitsPerSample == 16:
+		sample = func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / 32768 }
+	case info.format == wavFormatPCM && info.bitsPerSample == 24:
+		sample = func(b []byte) float64 {
+			v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+			return float64(v) / (1 << 23)
+		}
+	case info.format == wavFormatPCM && info.bitsPerSample == 32:
+		sample = func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case info.format == wavFormatIEEEFloat && info.bitsPerSample == 32:
+		sample = func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	default:
+		return nil, fmt.Errorf("unsupported WAV encoding (format %d, %d-bit)", info.format, info.bitsPerSample)
+	}
+
+	frames := info.dataLen / frameSize
+	if frames == 0 {
+		return nil, fmt.Errorf("no samples")
+	}
+	peaks := make([]float64, buckets)
+	var loudest float64
+	for f := 0; f < frames; f++ {
+		bucket := f * buckets / frames
+		base := info.dataOffset + f*frameSize
+		for c := 0; c < info.channels; c++ {
+			v := math.Abs(sample(data[base+c*bytesPerSample:]))
+			if v > peaks[bucket] {
+				peaks[bucket] = v
+			}
+		}
+		if peaks[bucket] > loudest {
+			loudest = peaks[bucket]
+		}
+	}
+	for i := range peaks {
+		if loudest > 0 {
+			peaks[i] = math.Round(peaks[i]/loudest*1000) / 1000
+		}
+	}
+	return peaks, nil
+}
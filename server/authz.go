@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// authorizationWebhookTimeout bounds how long a recording waits for the policy engine.
+	authorizationWebhookTimeout = 3 * time.Second
+
+	authzActionCommand = "command"
+	authzActionUpload  = "upload"
+)
+
+type authorizationRequest struct {
+	UserID    string `json:"user_id"`
+	ChannelID string `json:"channel_id"`
+	Action    string `json:"action"`
+}
+
+type authorizationResponse struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// authorizeRecording asks AuthorizationWebhookURL whether the user may record in
+// the channel. It runs in addition to AllowedRoles and allows everything when no
+// webhook is configured. When the webhook can't be reached or answers with
+// anything but a 200 JSON decision, AuthorizationWebhookFailOpen decides. The
+// returned reason is shown to the user on denial.
+func (p *Plugin) authorizeRecording(cfg *Configuration, userID, channelID, action string) (bool, string) {
+	webhookURL := strings.TrimSpace(cfg.AuthorizationWebhookURL)
+	if webhookURL == "" {
+		return true, ""
+	}
+
+	decision, err := callAuthorizationWebhook(webhookURL, authorizationRequest{UserID: userID, ChannelID: channelID, Action: action})
+	if err != nil {
+		p.API.LogWarn("Authorization webhook failed", "user_id", userID, "channel_id", channelID, "action", action, "fail_open", cfg.AuthorizationWebhookFailOpen, "err", err.Error())
+		if cfg.AuthorizationWebhookFailOpen {
+			return true, ""
+		}
+		return false, "Voice messages can't be authorized right now. Try again later."
+	}
+	if !decision.Allow {
+		reason := strings.TrimSpace(decision.Reason)
+		if reason == "" {
+			reason = "Voice messages are not allowed here by your organization's policy."
+		}
+		return false, truncate(reason, 300)
+	}
+	return true, ""
+}
+
+func callAuthorizationWebhook(webhookURL string, req authorizationRequest) (*authorizationResponse, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: authorizationWebhookTimeout}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("network: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, fmt.Errorf("read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d, body: %s", resp.StatusCode, truncate(string(body), 300))
+	}
+	var decision authorizationResponse
+	if err := json.Unmarshal(body, &decision); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &decision, nil
+}
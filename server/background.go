@@ -0,0 +1,43 @@
+package main
+
+import "time"
+
+// maintenanceInterval is how often the background ticker runs periodic jobs.
+const maintenanceInterval = time.Minute
+
+// startBackgroundJobs runs runMaintenance on a ticker until stopBackgroundJobs.
+func (p *Plugin) startBackgroundJobs() {
+	p.stopBackground = make(chan struct{})
+	p.backgroundDone = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(maintenanceInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				p.runMaintenance()
+			}
+		}
+	}(p.stopBackground, p.backgroundDone)
+}
+
+// stopBackgroundJobs ends the ticker and waits for a running pass to finish.
+func (p *Plugin) stopBackgroundJobs() {
+	if p.stopBackground == nil {
+		return
+	}
+	close(p.stopBackground)
+	<-p.backgroundDone
+	p.stopBackground = nil
+}
+
+// runMaintenance performs one pass of every periodic job.
+func (p *Plugin) runMaintenance() {
+	p.drainDeferredTranscriptions()
+	p.postVoiceDigest()
+	p.progress.prune()
+	p.cleanupExpiredMobileTokens()
+}
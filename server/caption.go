@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// maxCaptionRunes bounds the text note sent with a mobile recording.
+	maxCaptionRunes = 500
+
+	headerCaption = "X-Voice-Caption"
+
+	// voiceCaptionProp holds the caption as plain text, apart from the Message,
+	// which may also carry a mention or the transcript.
+	voiceCaptionProp = "voice_caption"
+)
+
+// captionMarkdown are the characters escaped in a caption so Mattermost shows
+// them as typed instead of as Markdown.
+const captionMarkdown = "\\`*_~[]()#<>|!"
+
+// cleanCaption turns a caption from the recording page into plain text: tabs
+// become spaces, other control characters but line breaks are dropped, lines are
+// trimmed, runs of blank lines are collapsed and it is cut to maxCaptionRunes.
+func cleanCaption(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return ' '
+		}
+		if r != '\n' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(strings.TrimSpace(s), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" && blank {
+			continue
+		}
+		blank = line == ""
+		lines = append(lines, line)
+	}
+	s = strings.Join(lines, "\n")
+	if r := []rune(s); len(r) > maxCaptionRunes {
+		s = strings.TrimSpace(string(r[:maxCaptionRunes]))
+	}
+	return s
+}
+
+// requestCaption reads the caption of a mobile upload from the X-Voice-Caption
+// header, where the recording page sends it percent-encoded. A header keeps the
+// note out of the access logs a query parameter would end up in.
+func requestCaption(r *http.Request) (string, error) {
+	raw := r.Header.Get(headerCaption)
+	if raw == "" {
+		return "", nil
+	}
+	caption, err := url.PathUnescape(raw)
+	if err != nil {
+		return "", err
+	}
+	return cleanCaption(caption), nil
+}
+
+// captionMessage escapes a plain-text caption for the post Message, so Markdown
+// characters, and list, rule and heading markers at the start of a line, show
+// as typed.
+func captionMessage(caption string) string {
+	var sb strings.Builder
+	for i, line := range strings.Split(caption, "\n") {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		// "- ", "+ ", "---" and "===" start lists, rules and headings, and "1." an
+		// ordered list; the escape goes on the first character and the dot.
+		if line != "" && strings.ContainsRune("-+=", rune(line[0])) {
+			sb.WriteByte('\\')
+		}
+		digits := len(line) - len(strings.TrimLeft(line, "0123456789"))
+		for j, r := range line {
+			if strings.ContainsRune(captionMarkdown, r) || (digits > 0 && j == digits && r == '.') {
+				sb.WriteByte('\\')
+			}
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// voiceCaption returns the caption of a voice message post, escaped for its
+// Message.
+func voiceCaption(post *model.Post) string {
+	caption, _ := post.GetProp(voiceCaptionProp).(string)
+	return captionMessage(caption)
+}
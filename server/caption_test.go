@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestCaption(t *testing.T) {
+	newRequest := func(header string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/mobile/upload?token=abc", nil)
+		if header != "" {
+			r.Header.Set(headerCaption, header)
+		}
+		return r
+	}
+
+	caption, err := requestCaption(newRequest(""))
+	require.NoError(t, err)
+	assert.Empty(t, caption)
+
+	caption, err = requestCaption(newRequest(url.PathEscape(" Встреча в 15:00\t+ 50%\n\n\nзал Б ")))
+	require.NoError(t, err)
+	assert.Equal(t, "Встреча в 15:00 + 50%\n\nзал Б", caption)
+
+	_, err = requestCaption(newRequest("50%zz"))
+	assert.Error(t, err)
+
+	// A caption in the query string is no longer read.
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/mobile/upload?caption=hello", nil)
+	caption, err = requestCaption(r)
+	require.NoError(t, err)
+	assert.Empty(t, caption)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// kvChannelVoiceCountPrefix counts a channel's voice messages in one hour,
+	// keyed by channel and hour.
+	kvChannelVoiceCountPrefix = "vm_channel_count_"
+
+	channelVoiceCountWindow   = time.Hour
+	channelVoiceCountAttempts = 5
+)
+
+// errChannelVoiceLimit is returned when a channel has used its hourly allowance.
+var errChannelVoiceLimit = errors.New("channel voice message limit reached")
+
+// getChannelVoiceMessagesPerHour returns how many voice messages one
+// channel may receive per clock hour; 0 means no limit.
+func (c *Configuration) getChannelVoiceMessagesPerHour() int {
+	if c == nil {
+		return 0
+	}
+	return intFromCfg(c.ChannelVoiceMessagesPerHour, 0)
+}
+
+// channelVoiceCountKey returns the counter key for channelID in the hour of t.
+func channelVoiceCountKey(channelID string, t time.Time) string {
+	return kvChannelVoiceCountPrefix + channelID + "_" + strconv.FormatInt(t.Unix()/int64(channelVoiceCountWindow.Seconds()), 10)
+}
+
+// takeChannelVoiceSlot counts a voice message against ChannelVoiceMessagesPerHour
+// before it is uploaded. It returns the counter key, to hand to
+// returnChannelVoiceSlot if the message isn't posted after all; the key is empty
+// when there is no limit. errChannelVoiceLimit means the channel is at its limit.
+func (p *Plugin) takeChannelVoiceSlot(cfg *Configuration, channelID string) (string, error) {
+	limit := cfg.getChannelVoiceMessagesPerHour()
+	if limit <= 0 {
+		return "", nil
+	}
+	key := channelVoiceCountKey(channelID, time.Now())
+	for attempt := 0; attempt < channelVoiceCountAttempts; attempt++ {
+		old, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return "", fmt.Errorf("KVGet: %s", appErr.Error())
+		}
+		count, _ := strconv.Atoi(string(old))
+		if count >= limit {
+			return "", errChannelVoiceLimit
+		}
+		ok, appErr := p.API.KVSetWithOptions(key, []byte(strconv.Itoa(count+1)), model.PluginKVSetOptions{
+			Atomic:          true,
+			OldValue:        old,
+			ExpireInSeconds: int64(2 * channelVoiceCountWindow.Seconds()),
+		})
+		if appErr != nil {
+			return "", fmt.Errorf("KVSetWithOptions: %s", appErr.Error())
+		}
+		if ok {
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("counter busy after %d attempts", channelVoiceCountAttempts)
+}
+
+// returnChannelVoiceSlot gives back a slot taken by takeChannelVoiceSlot. Losing
+// the race a few times just leaves the slot counted until the hour ends.
+func (p *Plugin) returnChannelVoiceSlot(key string) {
+	if key == "" {
+		return
+	}
+	for attempt := 0; attempt < channelVoiceCountAttempts; attempt++ {
+		old, appErr := p.API.KVGet(key)
+		if appErr != nil || old == nil {
+			return
+		}
+		count, _ := strconv.Atoi(string(old))
+		if count <= 0 {
+			return
+		}
+		if ok, appErr := p.API.KVSetWithOptions(key, []byte(strconv.Itoa(count-1)), model.PluginKVSetOptions{
+			Atomic:          true,
+			OldValue:        old,
+			ExpireInSeconds: int64(2 * channelVoiceCountWindow.Seconds()),
+		}); appErr != nil || ok {
+			return
+		}
+	}
+}
+
+// writeChannelVoiceSlotError answers an upload that takeChannelVoiceSlot turned
+// down.
+func (p *Plugin) writeChannelVoiceSlotError(w http.ResponseWriter, cfg *Configuration, channelID string, err error) {
+	if errors.Is(err, errChannelVoiceLimit) {
+		next := time.Now().Truncate(channelVoiceCountWindow).Add(channelVoiceCountWindow)
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(time.Until(next).Seconds()))))
+		http.Error(w, fmt.Sprintf("This channel has reached its limit of %d voice messages per hour. Try again later.", cfg.getChannelVoiceMessagesPerHour()), http.StatusTooManyRequests)
+		return
+	}
+	p.API.LogError("Failed to count channel voice message", "channel_id", channelID, "err", err.Error())
+	http.Error(w, "Failed to check the channel's voice message limit, try again", http.StatusInternalServerError)
+}
+
+// writeCreatePostError answers an upload whose CreatePost failed. The plugin API
+// runs MessageWillBePosted of every plugin, so a post can be turned down by
+// another plugin's posting policy; that is the author's to know, not a server
+// error.
+func writeCreatePostError(w http.ResponseWriter, appErr *model.AppError) {
+	if appErr.StatusCode >= 400 && appErr.StatusCode < 500 {
+		reason := appErr.Message
+		if reason == "" {
+			reason = appErr.Id
+		}
+		http.Error(w, "The voice message was rejected: "+reason, http.StatusForbidden)
+		return
+	}
+	http.Error(w, "Failed to create post", http.StatusInternalServerError)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/binary"
+	"mime"
+	"strings"
+)
+
+// parseAudioContentType splits a Content-Type such as `audio/webm; codecs="opus"`
+// into its lowercase base type and its parameters, with names lowercased and
+// quotes removed. A value that doesn't parse still yields the base type, with nil
+// parameters.
+func parseAudioContentType(ct string) (string, map[string]string) {
+	ct = strings.TrimSpace(ct)
+	if ct == "" {
+		return "", nil
+	}
+	if base, params, err := mime.ParseMediaType(ct); err == nil {
+		return base, params
+	}
+	base, _, _ := strings.Cut(ct, ";")
+	return strings.ToLower(strings.TrimSpace(base)), nil
+}
+
+// audioPartType is the Content-Type of the audio part sent to the transcription
+// provider. Known containers get their canonical type, which matches the
+// filename; other audio keeps the upload's own base type rather than the
+// filename's fallback.
+func audioPartType(contentType, filename string) string {
+	if extForContentType(contentType) == ".bin" {
+		if base, _ := parseAudioContentType(contentType); strings.HasPrefix(base, "audio/") {
+			return base
+		}
+	}
+	return mimeForFilename(filename)
+}
+
+// unsupportedAudioMessage answers an upload whose bytes aren't a supported audio
+// container.
+const unsupportedAudioMessage = "Unsupported audio format: the upload must be WebM, Ogg, WAV, MP4/M4A, MP3 or FLAC audio."
+
+// detectAudioFormat returns the MIME type of the audio container data starts with,
+// judged by its signature alone, or "" when it isn't one the plugin stores: WebM
+// (EBML), Ogg, RIFF/WAVE, MP4/M4A (ftyp), MP3 (ID3 tag or MPEG frame sync) or FLAC.
+func detectAudioFormat(data []byte) string {
+	switch {
+	case len(data) >= 4 && binary.BigEndian.Uint32(data) == ebmlIDHeader:
+		return "audio/webm"
+	case len(data) >= 4 && string(data[0:4]) == "OggS":
+		return "audio/ogg"
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return "audio/wav"
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		return "audio/mp4"
+	case len(data) >= 3 && string(data[0:3]) == "ID3":
+		return "audio/mpeg"
+	case len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0 && data[1]&0x06 != 0:
+		// MPEG audio frame sync with a layer set; layer 0 would be ADTS AAC.
+		return "audio/mpeg"
+	case len(data) >= 4 && string(data[0:4]) == "fLaC":
+		return "audio/flac"
+	default:
+		return ""
+	}
+}
+
+// uploadContentType is the type a voice upload is stored under: the client's
+// Content-Type when it names the container the bytes hold, since it may carry a
+// codecs parameter, and otherwise the detected type.
+func uploadContentType(header, detected string) string {
+	if extForContentType(header) == extForContentType(detected) {
+		return header
+	}
+	return detected
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAudioContentType(t *testing.T) {
+	for _, tc := range []struct {
+		in     string
+		base   string
+		params map[string]string
+	}{
+		{"audio/webm", "audio/webm", map[string]string{}},
+		{`Audio/WebM; Codecs="opus"; rate=48000`, "audio/webm", map[string]string{"codecs": "opus", "rate": "48000"}},
+		{"  audio/ogg;codecs=opus  ", "audio/ogg", map[string]string{"codecs": "opus"}},
+		{"audio/webm; codecs=", "audio/webm", nil},
+		{"audio/we bm", "audio/we bm", nil},
+		{"", "", nil},
+	} {
+		base, params := parseAudioContentType(tc.in)
+		assert.Equal(t, tc.base, base, tc.in)
+		assert.Equal(t, tc.params, params, tc.in)
+	}
+}
+
+func TestIsValidRecordingMime(t *testing.T) {
+	for _, m := range []string{"audio/webm", "audio/webm;codecs=opus", `audio/webm; codecs="opus"; rate=48000`, "AUDIO/MP4", "audio/x-m4a"} {
+		assert.True(t, isValidRecordingMime(m), m)
+	}
+	for _, m := range []string{"", "audio/", "video/webm", "audio", "audio/webm; codecs=", "audio/we bm", "audio/webm</script>", "audio/webm/opus"} {
+		assert.False(t, isValidRecordingMime(m), m)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maxTracedResponseBytes bounds the provider response kept in a debug trace.
+const maxTracedResponseBytes = 4000
+
+// tracedCall is one provider request and its outcome, as shown to admins.
+type tracedCall struct {
+	URL        string            `json:"url"`
+	Provider   string            `json:"provider"`
+	Field      string            `json:"field"`
+	Headers    map[string]string `json:"headers"`
+	Form       [][2]string       `json:"form"`
+	BodyBytes  int               `json:"body_bytes"`
+	Status     int               `json:"status,omitempty"`
+	Response   string            `json:"response,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	DurationMs int64             `json:"duration_ms"`
+}
+
+// transcriptionTrace collects the provider calls made for one transcription when
+// an admin asks handleTranscribe for debug=true. It rides along on the
+// transcriptionTarget, so retries, chunks and translations are recorded too. A
+// nil trace records nothing.
+type transcriptionTrace struct {
+	mu    sync.Mutex
+	calls []tracedCall
+}
+
+func (t *transcriptionTrace) record(call tracedCall, started time.Time) {
+	if t == nil {
+		return
+	}
+	call.DurationMs = time.Since(started).Milliseconds()
+	t.mu.Lock()
+	t.calls = append(t.calls, call)
+	t.mu.Unlock()
+}
+
+// redacted returns the calls with API keys taken out of every string, in case a
+// provider echoes one back or it is part of the URL.
+func (t *transcriptionTrace) redacted(cfg *Configuration) []tracedCall {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]tracedCall, len(t.calls))
+	for i, call := range t.calls {
+		call.URL = cfg.redactAPIKeys(call.URL)
+		call.Response = cfg.redactAPIKeys(call.Response)
+		call.Error = cfg.redactAPIKeys(call.Error)
+		form := make([][2]string, len(call.Form))
+		for j, f := range call.Form {
+			form[j] = [2]string{f[0], cfg.redactAPIKeys(f[1])}
+		}
+		call.Form = form
+		out[i] = call
+	}
+	return out
+}
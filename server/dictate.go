@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// transcriptionAvailable reports whether transcription is enabled and has an
+// endpoint and an API key to call it with.
+func (c *Configuration) transcriptionAvailable() bool {
+	return c.EnableTranscription && c.getTranscriptionURL() != "" && len(c.getTranscriptionAPIKeys()) > 0
+}
+
+// transcribeDictation transcribes a "/voice dictate" recording while the upload
+// request waits. Any error means the caller should post the audio instead.
+func (p *Plugin) transcribeDictation(cfg *Configuration, channelID string, data []byte, mimeType string, duration float64) (*transcriptionResult, error) {
+	if !cfg.transcriptionAvailable() {
+		return nil, fmt.Errorf("transcription is not enabled")
+	}
+	if maxDur := cfg.getTranscriptionMaxDur(); maxDur > 0 && duration > float64(maxDur) {
+		return nil, fmt.Errorf("recording too long to transcribe (%.0fs > %ds limit)", duration, maxDur)
+	}
+	result, err := p.transcribe(heldAudio(data), mimeType, p.resolveTranscriptionTarget(cfg, channelID))
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(result.Text) == "" {
+		return nil, fmt.Errorf("empty transcript")
+	}
+	if cfg.withholdsTranscript(result.Language) {
+		return nil, fmt.Errorf("%s", cfg.transcriptLanguageWarning(result.Language))
+	}
+	return result, nil
+}
+
+// dictationText is the message of a dictation post, paragraphed when FormatTranscript is on.
+func dictationText(cfg *Configuration, result *transcriptionResult) string {
+	if cfg.FormatTranscript {
+		if formatted := formatTranscript(result); formatted != "" {
+			return formatted
+		}
+	}
+	return strings.TrimSpace(result.Text)
+}
+
+// createDictationPost posts a dictated transcript as a plain text message, with the
+// recording attached when DictationAttachAudio is on. The archive gets the
+// recording either way. It returns the post and the attached file ID ("" when the
+// audio was discarded). On failure it has already written the response and
+// returns false.
+func (p *Plugin) createDictationPost(w http.ResponseWriter, cfg *Configuration, mt *mobileToken, result *transcriptionResult, data []byte, filename string) (*model.Post, string, bool) {
+	post := &model.Post{
+		UserId:    mt.UserID,
+		ChannelId: mt.ChannelID,
+		RootId:    mt.RootID,
+		Message:   dictationText(cfg, result),
+		Props:     model.StringInterface{"voice_dictated": true},
+	}
+	if warning := cfg.transcriptLanguageWarning(result.Language); warning != "" {
+		post.Props["voice_transcript_language_warning"] = warning
+	}
+	if mt.Silent {
+		post.Message = muteMentions(post.Message)
+		post.Props[voiceSilentProp] = true
+	}
+
+	fileID := ""
+	if cfg.DictationAttachAudio {
+		fileInfo, appErr := p.uploadVoiceFile(cfg, data, mt.ChannelID, filename)
+		if appErr != nil {
+			p.metrics.uploadFailures.Add(1)
+			p.writeUploadError(w, appErr)
+			return nil, "", false
+		}
+		fileID = fileInfo.Id
+		post.FileIds = []string{fileID}
+	}
+
+	created, appErr := p.API.CreatePost(post)
+	if appErr != nil {
+		p.API.LogError("CreatePost failed", "err", appErr.Error(), "orphaned_file_id", fileID)
+		p.markOrphanedUpload(fileID)
+		p.metrics.uploadFailures.Add(1)
+		writeCreatePostError(w, appErr)
+		return nil, "", false
+	}
+	p.metrics.uploads.Add(1)
+	go p.archiveVoiceMessage(cfg, created, data, filename)
+	return created, fileID, true
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// kvLastDigestKey holds the scheduled time, in ms, of the last digest posted.
+	kvLastDigestKey = "vm_digest_last"
+
+	defaultVoiceDigestTime = "09:00"
+
+	// digestSnippetRunes caps the transcript excerpt shown per voice message.
+	digestSnippetRunes = 200
+)
+
+// digestSchedule is the daily time, in a given time zone, at which the digest
+// covering the preceding 24 hours is posted.
+type digestSchedule struct {
+	minute int // minutes since midnight
+	loc    *time.Location
+}
+
+// parseDigestSchedule reads "HH:MM [Time/Zone]", e.g. "09:00 Europe/Berlin". The
+// zone defaults to UTC.
+func parseDigestSchedule(s string) (*digestSchedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("expected \"HH:MM [Time/Zone]\"")
+	}
+	ds := &digestSchedule{loc: time.UTC}
+	var err error
+	if ds.minute, err = parseClock(fields[0]); err != nil {
+		return nil, err
+	}
+	if len(fields) == 2 {
+		if ds.loc, err = time.LoadLocation(fields[1]); err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", fields[1])
+		}
+	}
+	return ds, nil
+}
+
+// latest returns the most recent scheduled time at or before now.
+func (d *digestSchedule) latest(now time.Time) time.Time {
+	lt := now.In(d.loc)
+	at := time.Date(lt.Year(), lt.Month(), lt.Day(), d.minute/60, d.minute%60, 0, 0, d.loc)
+	if at.After(lt) {
+		at = at.AddDate(0, 0, -1)
+	}
+	return at
+}
+
+// getVoiceDigestSchedule returns the digest schedule, falling back to 09:00 UTC
+// when VoiceDigestTime is unset or invalid.
+func (c *Configuration) getVoiceDigestSchedule() *digestSchedule {
+	if ds, err := parseDigestSchedule(c.VoiceDigestTime); err == nil {
+		return ds
+	}
+	ds, _ := parseDigestSchedule(defaultVoiceDigestTime)
+	return ds
+}
+
+func validDigestTime(s string) bool {
+	_, err := parseDigestSchedule(s)
+	return err == nil
+}
+
+// getVoiceDigestChannels returns the channel IDs whose voice messages the digest covers.
+func (c *Configuration) getVoiceDigestChannels() []string {
+	var ids []string
+	for _, id := range strings.Split(c.VoiceDigestChannels, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// postVoiceDigest posts the daily digest once the scheduled time has passed. The
+// last scheduled time is claimed in KV with a compare-and-set first, so a digest
+// is posted once even when several cluster nodes run the ticker.
+func (p *Plugin) postVoiceDigest() {
+	cfg := p.getConfig()
+	digestID := strings.TrimSpace(cfg.VoiceDigestChannelID)
+	sources := cfg.getVoiceDigestChannels()
+	if digestID == "" || len(sources) == 0 || p.botID == "" {
+		return
+	}
+
+	end := cfg.getVoiceDigestSchedule().latest(time.Now())
+	old, appErr := p.API.KVGet(kvLastDigestKey)
+	if appErr != nil {
+		return
+	}
+	if last, _ := strconv.ParseInt(string(old), 10, 64); last >= end.UnixMilli() {
+		return
+	}
+	claimed, appErr := p.API.KVSetWithOptions(kvLastDigestKey, []byte(strconv.FormatInt(end.UnixMilli(), 10)), model.PluginKVSetOptions{
+		Atomic:   true,
+		OldValue: old,
+	})
+	if appErr != nil || !claimed {
+		return
+	}
+
+	start := end.AddDate(0, 0, -1)
+	var posts []*model.Post
+	for _, channelID := range sources {
+		list, appErr := p.API.GetPostsSince(channelID, start.UnixMilli())
+		if appErr != nil {
+			p.API.LogWarn("Voice digest: failed to read channel", "channel_id", channelID, "err", appErr.Error())
+			continue
+		}
+		for _, post := range list.Posts {
+			if post.Type == "custom_voice_message" && post.DeleteAt == 0 &&
+				post.CreateAt >= start.UnixMilli() && post.CreateAt < end.UnixMilli() {
+				posts = append(posts, post)
+			}
+		}
+	}
+	if len(posts) == 0 {
+		return
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].CreateAt < posts[j].CreateAt })
+
+	if err := p.ensureBotInChannel(digestID); err != nil {
+		p.API.LogWarn("Voice digest not posted: bot can't join digest channel", "channel_id", digestID, "err", err.Error())
+		return
+	}
+	if _, appErr := p.API.CreatePost(&model.Post{
+		UserId:    p.botID,
+		ChannelId: digestID,
+		Message:   p.digestMessage(cfg, posts, end),
+	}); appErr != nil {
+		p.API.LogError("Voice digest CreatePost failed", "err", appErr.Error())
+	}
+}
+
+// digestMessage lists the voice messages, oldest first, with their author, channel,
+// duration, a transcript excerpt and a permalink. Entries that don't fit in one
+// post are summarized in a final line.
+func (p *Plugin) digestMessage(cfg *Configuration, posts []*model.Post, end time.Time) string {
+	loc := cfg.getVoiceDigestSchedule().loc
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "#### 🎙️ Voice messages, %s – %s\n", end.AddDate(0, 0, -1).Format("Mon 2 Jan 15:04"), end.Format("Mon 2 Jan 15:04 MST"))
+
+	usernames := map[string]string{}
+	channels := map[string]string{}
+	for i, post := range posts {
+		author, ok := usernames[post.UserId]
+		if !ok {
+			author = post.UserId
+			if u, appErr := p.API.GetUser(post.UserId); appErr == nil {
+				author = "@" + u.Username
+			}
+			usernames[post.UserId] = author
+		}
+		where, ok := channels[post.ChannelId]
+		if !ok {
+			where = post.ChannelId
+			if ch, appErr := p.API.GetChannel(post.ChannelId); appErr == nil {
+				where = "~" + ch.Name
+			}
+			channels[post.ChannelId] = where
+		}
+
+		line := fmt.Sprintf("- %s %s in %s", time.UnixMilli(post.CreateAt).In(loc).Format("15:04"), author, where)
+		if d, _ := post.GetProp("voice_duration").(string); d != "" {
+			line += " (" + d + ")"
+		}
+		if t := []rune(strings.Join(strings.Fields(displayTranscript(post)), " ")); len(t) > digestSnippetRunes {
+			line += ": " + string(t[:digestSnippetRunes]) + "…"
+		} else if len(t) > 0 {
+			line += ": " + string(t)
+		}
+		line += " [link](" + p.buildPostPermalink(post.Id) + ")\n"
+
+		more := fmt.Sprintf("…and %d more\n", len(posts)-i)
+		if len([]rune(sb.String()))+len([]rune(line))+len([]rune(more)) > model.PostMessageMaxRunesV2 {
+			sb.WriteString(more)
+			break
+		}
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// directChannelFor resolves the "@username" of "/voice @username" to the direct
+// message channel between userID and that user, creating it when needed. It
+// honors the server's direct message policy: the caller needs permission to
+// create direct channels and, with RestrictDirectMessage set to team, a team in
+// common with the other user. On failure it returns the text to show instead.
+func (p *Plugin) directChannelFor(userID, mention string) (*model.Channel, string) {
+	username := strings.ToLower(strings.TrimPrefix(mention, "@"))
+	if username == "" {
+		return nil, "usage: /voice @username"
+	}
+	other, appErr := p.API.GetUserByUsername(username)
+	if appErr != nil || other == nil {
+		return nil, fmt.Sprintf("No user named @%s.", username)
+	}
+	if other.DeleteAt != 0 {
+		return nil, fmt.Sprintf("@%s is deactivated.", username)
+	}
+
+	cantDM := fmt.Sprintf("You can't send a direct message to @%s.", username)
+	if other.Id != userID {
+		if !p.API.HasPermissionTo(userID, model.PermissionCreateDirectChannel) {
+			return nil, cantDM
+		}
+		if cfg := p.API.GetConfig(); cfg != nil && cfg.TeamSettings.RestrictDirectMessage != nil &&
+			*cfg.TeamSettings.RestrictDirectMessage == model.DirectMessageTeam && !p.shareTeam(userID, other.Id) {
+			return nil, cantDM
+		}
+	}
+
+	channel, appErr := p.API.GetDirectChannel(userID, other.Id)
+	if appErr != nil {
+		p.API.LogWarn("Failed to get direct channel for /voice @username", "user_id", userID, "other_id", other.Id, "err", appErr.Error())
+		return nil, cantDM
+	}
+	return channel, ""
+}
+
+// shareTeam reports whether two users are members of at least one common team.
+func (p *Plugin) shareTeam(userID, otherID string) bool {
+	teams, appErr := p.API.GetTeamsForUser(userID)
+	if appErr != nil {
+		return false
+	}
+	for _, team := range teams {
+		if member, appErr := p.API.GetTeamMember(team.Id, otherID); appErr == nil && member.DeleteAt == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// exportChannelsPerPage is how many of the user's channels one export page covers.
+	exportChannelsPerPage = 50
+
+	exportPostsPerPage   = 200
+	exportZipContentType = "application/zip"
+
+	// exportCoverage states what an export leaves out, in every manifest.
+	exportCoverage = "Voice messages in the channels the user is currently a member of. Channels they have left are not included."
+)
+
+// exportedVoiceMessage is one manifest entry of a user export.
+type exportedVoiceMessage struct {
+	PostID       string   `json:"post_id"`
+	ChannelID    string   `json:"channel_id"`
+	ChannelName  string   `json:"channel_name"`
+	TeamID       string   `json:"team_id,omitempty"`
+	RootID       string   `json:"root_id,omitempty"`
+	CreateAt     int64    `json:"create_at"`
+	Duration     string   `json:"duration,omitempty"`
+	Transcript   string   `json:"transcript,omitempty"`
+	FileIDs      []string `json:"file_ids"`
+	DownloadURLs []string `json:"download_urls"`
+}
+
+// exportManifest describes one page of a user export.
+type exportManifest struct {
+	UserID         string                 `json:"user_id"`
+	GeneratedAt    int64                  `json:"generated_at"`
+	Page           int                    `json:"page"`
+	ChannelsTotal  int                    `json:"channels_total"`
+	ChannelsInPage int                    `json:"channels_in_page"`
+	HasMore        bool                   `json:"has_more"`
+	Coverage       string                 `json:"coverage"`
+	VoiceMessages  []exportedVoiceMessage `json:"voice_messages"`
+}
+
+// handleExport serves GET /api/v1/admin/export?user_id=...&page=...&format=json|zip
+// for data-subject requests. It gathers the user's voice messages from the channels
+// they belong to, exportChannelsPerPage channels per page and every post of each,
+// and returns a manifest with download links; format=zip streams the manifest
+// together with the audio. Channels the user has left aren't covered, which the
+// manifest says in coverage.
+func (p *Plugin) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	userID := q.Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id required", http.StatusBadRequest)
+		return
+	}
+	if _, appErr := p.API.GetUser(userID); appErr != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page < 0 {
+		page = 0
+	}
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		http.Error(w, "format must be json or zip", http.StatusBadRequest)
+		return
+	}
+
+	channels, err := p.userChannels(userID)
+	if err != nil {
+		p.API.LogError("Export: listing channels failed", "user_id", userID, "err", err.Error())
+		http.Error(w, "Failed to list channels", http.StatusInternalServerError)
+		return
+	}
+
+	manifest := exportManifest{
+		UserID:        userID,
+		GeneratedAt:   model.GetMillis(),
+		Page:          page,
+		ChannelsTotal: len(channels),
+		Coverage:      exportCoverage,
+		VoiceMessages: []exportedVoiceMessage{},
+	}
+	start := page * exportChannelsPerPage
+	end := start + exportChannelsPerPage
+	if start > len(channels) {
+		start = len(channels)
+	}
+	if end > len(channels) {
+		end = len(channels)
+	}
+	manifest.ChannelsInPage = end - start
+	manifest.HasMore = end < len(channels)
+
+	for _, ch := range channels[start:end] {
+		msgs, err := p.exportChannelVoiceMessages(r.Context(), ch, userID)
+		if r.Context().Err() != nil {
+			return
+		}
+		if err != nil {
+			p.API.LogError("Export: reading channel failed", "user_id", userID, "channel_id", ch.Id, "err", err.Error())
+			http.Error(w, "Failed to read posts", http.StatusInternalServerError)
+			return
+		}
+		manifest.VoiceMessages = append(manifest.VoiceMessages, msgs...)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if format != "zip" {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(manifest)
+		return
+	}
+	p.writeExportZip(r.Context(), w, &manifest)
+}
+
+// userChannels returns every channel the user belongs to across their teams,
+// including DMs and group messages, in a stable order.
+func (p *Plugin) userChannels(userID string) ([]*model.Channel, error) {
+	teams, appErr := p.API.GetTeamsForUser(userID)
+	if appErr != nil {
+		return nil, appErr
+	}
+	seen := map[string]bool{}
+	var channels []*model.Channel
+	for _, team := range teams {
+		list, appErr := p.API.GetChannelsForTeamForUser(team.Id, userID, true)
+		if appErr != nil {
+			return nil, appErr
+		}
+		for _, ch := range list {
+			if !seen[ch.Id] {
+				seen[ch.Id] = true
+				channels = append(channels, ch)
+			}
+		}
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Id < channels[j].Id })
+	return channels, nil
+}
+
+// exportChannelVoiceMessages pages through a whole channel and collects the user's
+// voice messages, oldest first. It stops early when ctx is done.
+func (p *Plugin) exportChannelVoiceMessages(ctx context.Context, ch *model.Channel, userID string) ([]exportedVoiceMessage, error) {
+	var msgs []exportedVoiceMessage
+	for page := 0; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		list, appErr := p.API.GetPostsForChannel(ch.Id, page, exportPostsPerPage)
+		if appErr != nil {
+			return nil, appErr
+		}
+		for _, id := range list.Order {
+			post := list.Posts[id]
+			if post == nil || post.UserId != userID || post.Type != "custom_voice_message" || post.DeleteAt != 0 {
+				continue
+			}
+			duration, _ := post.GetProp("voice_duration").(string)
+			transcript, _ := post.GetProp("voice_transcript").(string)
+			m := exportedVoiceMessage{
+				PostID:       post.Id,
+				ChannelID:    ch.Id,
+				ChannelName:  ch.Name,
+				TeamID:       ch.TeamId,
+				RootID:       post.RootId,
+				CreateAt:     post.CreateAt,
+				Duration:     duration,
+				Transcript:   transcript,
+				FileIDs:      append([]string{}, post.FileIds...),
+				DownloadURLs: []string{},
+			}
+			for _, fileID := range post.FileIds {
+				m.DownloadURLs = append(m.DownloadURLs, p.buildFileDownloadURL(fileID))
+			}
+			msgs = append(msgs, m)
+		}
+		if len(list.Order) < exportPostsPerPage {
+			break
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].CreateAt < msgs[j].CreateAt })
+	return msgs, nil
+}
+
+// exportAudioFile is one audio file of a zip export, read and converted.
+type exportAudioFile struct {
+	fileID string
+	name   string
+	data   []byte
+	// created is the voice message's CreateAt.
+	created int64
+}
+
+// writeExportZip streams manifest.json followed by each audio file under audio/,
+// converted to MP3 with ConvertToMP3ForArchive. Files are read and converted up
+// to maxMP3Conversions at a time ahead of the writer, which adds them in manifest
+// order, and the work stops when ctx, the request, is done.
+// Files that can't be read are listed in missing_files.json instead of failing
+// the whole export, since the response has already started.
+func (p *Plugin) writeExportZip(ctx context.Context, w http.ResponseWriter, manifest *exportManifest) {
+	name := fmt.Sprintf("voice-export-%s-page%d.zip", manifest.UserID, manifest.Page)
+	w.Header().Set("Content-Type", exportZipContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: time.UnixMilli(manifest.GeneratedAt)})
+	if err != nil {
+		return
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cfg := p.getConfig()
+	pending := make(chan chan *exportAudioFile, maxMP3Conversions)
+	go func() {
+		defer close(pending)
+		for _, m := range manifest.VoiceMessages {
+			for _, fileID := range m.FileIDs {
+				result := make(chan *exportAudioFile, 1)
+				select {
+				case pending <- result:
+				case <-ctx.Done():
+					return
+				}
+				go func(postID string, created int64, fileID string) {
+					result <- p.readExportAudio(ctx, cfg, postID, created, fileID)
+				}(m.PostID, m.CreateAt, fileID)
+			}
+		}
+	}()
+
+	missing := []string{}
+	for result := range pending {
+		var f *exportAudioFile
+		select {
+		case f = <-result:
+		case <-ctx.Done():
+			return
+		}
+		if f.data == nil {
+			missing = append(missing, f.fileID)
+			continue
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     path.Join("audio", f.name),
+			Method:   zip.Store, // audio is already compressed
+			Modified: time.UnixMilli(f.created),
+		})
+		if err != nil {
+			return
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return
+		}
+	}
+	if len(missing) > 0 {
+		p.API.LogWarn("Export: some audio files could not be read", "user_id", manifest.UserID, "count", len(missing))
+		if fw, err := zw.Create("missing_files.json"); err == nil {
+			_ = json.NewEncoder(fw).Encode(missing)
+		}
+	}
+}
+
+// readExportAudio reads one file of a zip export and converts it for the archive;
+// the result has no data when the file can't be read.
+func (p *Plugin) readExportAudio(ctx context.Context, cfg *Configuration, postID string, created int64, fileID string) *exportAudioFile {
+	f := &exportAudioFile{fileID: fileID, created: created}
+	info, appErr := p.API.GetFileInfo(fileID)
+	if appErr != nil {
+		return f
+	}
+	data, appErr := p.API.GetFile(fileID)
+	if appErr != nil {
+		return f
+	}
+	data, name := p.exportAudio(ctx, cfg, data, path.Base(info.Name))
+	f.data, f.name = data, postID+"_"+name
+	return f
+}
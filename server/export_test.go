@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportChannelVoiceMessagesReadsWholeChannel(t *testing.T) {
+	const pages = 120
+	api := newFakeKVAPI()
+	api.On("GetConfig").Return(&model.Config{})
+	api.On("GetPostsForChannel", "channel1", mock.Anything, exportPostsPerPage).Return(func(channelID string, page, perPage int) (*model.PostList, *model.AppError) {
+		list := model.NewPostList()
+		if page >= pages {
+			return list, nil
+		}
+		for i := 0; i < perPage; i++ {
+			id := fmt.Sprintf("post-%d-%d", page, i)
+			post := &model.Post{Id: id, UserId: "other", CreateAt: int64(-page*perPage - i)}
+			if i == 0 {
+				post.UserId, post.Type = "user1", "custom_voice_message"
+			}
+			list.AddPost(post)
+			list.AddOrder(id)
+		}
+		return list, nil
+	})
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	msgs, err := p.exportChannelVoiceMessages(context.Background(), &model.Channel{Id: "channel1"}, "user1")
+	require.NoError(t, err)
+	require.Len(t, msgs, pages, "posts past the 100th page are exported too")
+	assert.Equal(t, fmt.Sprintf("post-%d-0", pages-1), msgs[0].PostID, "oldest first")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = p.exportChannelVoiceMessages(ctx, &model.Channel{Id: "channel1"}, "user1")
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// defaultFallbackAccept is the accept attribute of the page's system-recorder
+// input when FallbackAcceptTypes is empty.
+const defaultFallbackAccept = "audio/*"
+
+// getFallbackAccept returns the FallbackAcceptTypes allowlist: file extensions
+// such as ".m4a" and audio MIME types such as "audio/mpeg" or "audio/*". Entries
+// that are neither are returned separately so they can be logged. An empty
+// allowlist means any audio file.
+func (c *Configuration) getFallbackAccept() ([]string, []string) {
+	if c == nil {
+		return nil, nil
+	}
+	var valid, invalid []string
+	for _, raw := range strings.Split(c.FallbackAcceptTypes, ",") {
+		a := strings.ToLower(strings.TrimSpace(raw))
+		if a == "" {
+			continue
+		}
+		if isValidFallbackExt(a) || a == defaultFallbackAccept || isValidRecordingMime(a) && !strings.Contains(a, ";") {
+			valid = append(valid, a)
+		} else {
+			invalid = append(invalid, raw)
+		}
+	}
+	return valid, invalid
+}
+
+func isValidFallbackExt(a string) bool {
+	if len(a) < 2 || len(a) > 10 || a[0] != '.' {
+		return false
+	}
+	for _, r := range a[1:] {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// fallbackAcceptMatches reports whether a file with the given extension and MIME
+// type is covered by the allowlist.
+func fallbackAcceptMatches(allow []string, ext, mime string) bool {
+	for _, a := range allow {
+		switch {
+		case a == ext && ext != "":
+			return true
+		case a == defaultFallbackAccept && strings.HasPrefix(mime, "audio/"):
+			return true
+		case a == mime && mime != "":
+			return true
+		}
+	}
+	return false
+}
+
+// sniffAudioType names the audio container the data starts with, as the MIME type
+// used for voice messages. It returns "" when the content isn't recognized and
+// "-" when it is recognizably something other than audio.
+func sniffAudioType(data []byte) string {
+	ct, _ := parseAudioContentType(http.DetectContentType(data))
+	switch ct {
+	case "audio/wave":
+		return "audio/wav"
+	case "audio/mpeg":
+		return "audio/mpeg"
+	case "application/ogg":
+		return "audio/ogg"
+	case "video/webm":
+		return "audio/webm"
+	case "video/mp4":
+		return "audio/mp4"
+	case "application/octet-stream":
+		return ""
+	}
+	if strings.HasPrefix(ct, "audio/") {
+		return ""
+	}
+	return "-"
+}
+
+// fallbackFileAllowed cross-checks a file from the system-recorder input against
+// FallbackAcceptTypes: its name must be allowed, and so must the container its
+// bytes actually hold, so renaming a file doesn't get it past the list.
+func fallbackFileAllowed(allow []string, filename string, data []byte) bool {
+	if len(allow) == 0 {
+		return true
+	}
+	ext := strings.ToLower(path.Ext(filename))
+	if !fallbackAcceptMatches(allow, ext, mimeForFilename(filename)) {
+		return false
+	}
+	switch sniffed := sniffAudioType(data); sniffed {
+	case "":
+		return true
+	case "-":
+		return false
+	default:
+		return sniffed == mimeForFilename(filename) || fallbackAcceptMatches(allow, extForContentType(sniffed), sniffed)
+	}
+}
+
+// recorderContainers are the containers MediaRecorder produces on the recording
+// page.
+var recorderContainers = map[string]bool{"audio/webm": true, "audio/ogg": true, "audio/mp4": true}
+
+// mobileUploadFormatProblem checks a mobile upload against the fallback settings.
+// The recorder only produces WebM, Ogg and MP4, so any other detected container
+// came from the system-recorder fallback whatever the request says. Those uploads,
+// and any sent with source=file, need the fallback enabled and their detected
+// container on FallbackAcceptTypes. It returns the status and message to reject
+// the upload with, or 0.
+func (c *Configuration) mobileUploadFormatProblem(source, filename, detected string, data []byte) (int, string) {
+	if source != "file" && recorderContainers[detected] {
+		return 0, ""
+	}
+	if c.DisableRecorderFallback {
+		return http.StatusForbidden, "The system-recorder fallback is disabled"
+	}
+	accept, _ := c.getFallbackAccept()
+	if len(accept) == 0 {
+		return 0, ""
+	}
+	if filename == "" {
+		filename = "recording" + extForContentType(detected)
+	}
+	if !fallbackFileAllowed(accept, filename, data) ||
+		detected != mimeForFilename(filename) && !fallbackAcceptMatches(accept, extForContentType(detected), detected) {
+		return http.StatusUnsupportedMediaType, "This file type is not accepted"
+	}
+	return 0, ""
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMobileUploadFormatProblem(t *testing.T) {
+	wav := testWAV()
+	webm := []byte{0x1A, 0x45, 0xDF, 0xA3, 0x80}
+	mp3 := append([]byte("ID3"), make([]byte, 64)...)
+
+	mp3Only := &Configuration{FallbackAcceptTypes: ".mp3"}
+	disabled := &Configuration{DisableRecorderFallback: true}
+	anyAudio := &Configuration{}
+
+	for name, tc := range map[string]struct {
+		cfg      *Configuration
+		source   string
+		filename string
+		data     []byte
+		want     int
+	}{
+		"recorder webm":                   {mp3Only, "", "", webm, 0},
+		"recorder webm, fallback off":     {disabled, "", "", webm, 0},
+		"wav without source=file":         {mp3Only, "", "", wav, http.StatusUnsupportedMediaType},
+		"wav without source=file, off":    {disabled, "", "", wav, http.StatusForbidden},
+		"mp3 without source=file":         {mp3Only, "", "", mp3, 0},
+		"mp3 picked file":                 {mp3Only, "file", "memo.mp3", mp3, 0},
+		"wav renamed to mp3":              {mp3Only, "file", "memo.mp3", wav, http.StatusUnsupportedMediaType},
+		"webm picked file, not allowed":   {mp3Only, "file", "memo.webm", webm, http.StatusUnsupportedMediaType},
+		"picked file, fallback off":       {disabled, "file", "memo.mp3", mp3, http.StatusForbidden},
+		"wav without source=file, any ok": {anyAudio, "", "", wav, 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			status, _ := tc.cfg.mobileUploadFormatProblem(tc.source, tc.filename, detectAudioFormat(tc.data), tc.data)
+			assert.Equal(t, tc.want, status)
+		})
+	}
+}
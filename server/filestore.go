@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// uploadRetryDelay is the pause before the single retry of a transient UploadFile failure.
+const uploadRetryDelay = 500 * time.Millisecond
+
+const (
+	// headerUploadError marks an upload failure the recording page handles on its
+	// own, whatever the wording of the message.
+	headerUploadError = "X-Voice-Upload-Error"
+	// uploadErrorStorageUnavailable is a file store refusing writes for lack of
+	// permissions or configuration; the page doesn't retry it.
+	uploadErrorStorageUnavailable = "storage_unavailable"
+)
+
+// uploadFailure classifies why UploadFile failed, so that deployment problems can
+// be told apart from a storage hiccup.
+type uploadFailure int
+
+const (
+	uploadFailureTransient  uploadFailure = iota // storage I/O error; may succeed on retry
+	uploadFailurePermission                      // storage missing, misconfigured or denying writes
+	uploadFailureQuota                           // storage full or over quota
+	uploadFailureTooLarge                        // above FileSettings.MaxFileSize
+	uploadFailureRejected                        // e.g. the channel can't take files
+)
+
+func (f uploadFailure) String() string {
+	switch f {
+	case uploadFailurePermission:
+		return "permission"
+	case uploadFailureQuota:
+		return "quota"
+	case uploadFailureTooLarge:
+		return "too_large"
+	case uploadFailureRejected:
+		return "rejected"
+	}
+	return "transient"
+}
+
+// classifyUploadError sorts an UploadFile error by its status code and, since file
+// store backends report most failures as a plain 500, by the wording of its error.
+func classifyUploadError(appErr *model.AppError) uploadFailure {
+	text := strings.ToLower(appErr.Id + " " + appErr.Message + " " + appErr.DetailedError)
+	switch {
+	case appErr.StatusCode == http.StatusRequestEntityTooLarge || strings.Contains(text, "too_large"):
+		return uploadFailureTooLarge
+	case appErr.StatusCode == http.StatusInsufficientStorage || strings.Contains(text, "quota") ||
+		strings.Contains(text, "no space left") || strings.Contains(text, "storage limit"):
+		return uploadFailureQuota
+	case appErr.StatusCode == http.StatusUnauthorized || appErr.StatusCode == http.StatusForbidden ||
+		appErr.StatusCode == http.StatusNotImplemented || strings.Contains(text, "permission denied") ||
+		strings.Contains(text, "access denied") || strings.Contains(text, "accessdenied") ||
+		strings.Contains(text, "read-only file system"):
+		return uploadFailurePermission
+	case appErr.StatusCode == http.StatusBadRequest:
+		return uploadFailureRejected
+	}
+	return uploadFailureTransient
+}
+
+// uploadVoiceFile stores audio with UploadFile, retrying a transient failure once
+// when RetryFailedFileUpload is on.
+func (p *Plugin) uploadVoiceFile(cfg *Configuration, data []byte, channelID, filename string) (*model.FileInfo, *model.AppError) {
+	fileInfo, appErr := p.API.UploadFile(data, channelID, filename)
+	if appErr == nil || !cfg.RetryFailedFileUpload || classifyUploadError(appErr) != uploadFailureTransient {
+		return fileInfo, appErr
+	}
+	p.API.LogWarn("Upload failed; retrying once", "channel_id", channelID, "err", appErr.Error())
+	time.Sleep(uploadRetryDelay)
+	return p.API.UploadFile(data, channelID, filename)
+}
+
+// writeUploadError logs an UploadFile failure with its class and answers with a
+// status and message that say what went wrong.
+func (p *Plugin) writeUploadError(w http.ResponseWriter, appErr *model.AppError) {
+	failure := classifyUploadError(appErr)
+	p.API.LogError("Upload failed", "class", failure.String(), "status", appErr.StatusCode, "err", appErr.Error())
+	switch failure {
+	case uploadFailurePermission:
+		w.Header().Set(headerUploadError, uploadErrorStorageUnavailable)
+		http.Error(w, "File storage is unavailable: the server can't store files. An administrator should check the file storage settings and permissions.", http.StatusInternalServerError)
+	case uploadFailureQuota:
+		http.Error(w, "File storage is full", http.StatusInsufficientStorage)
+	case uploadFailureTooLarge:
+		http.Error(w, "Recording exceeds the server's maximum file size", http.StatusRequestEntityTooLarge)
+	case uploadFailureRejected:
+		http.Error(w, "The channel can't receive files", http.StatusBadRequest)
+	default:
+		http.Error(w, "Upload failed: file storage error, try again", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteUploadError(t *testing.T) {
+	p := &Plugin{}
+	p.SetAPI(newFakeKVAPI())
+
+	for _, tc := range []struct {
+		name   string
+		appErr *model.AppError
+		status int
+		// storageDown is whether the answer is marked as a misconfigured file
+		// store, which the recording page doesn't retry.
+		storageDown bool
+	}{
+		{"permission", model.NewAppError("UploadFile", "api.file.upload_file.storage.app_error", nil, "open /data/x: permission denied", http.StatusInternalServerError), http.StatusInternalServerError, true},
+		{"not implemented", model.NewAppError("UploadFile", "app.file.not_implemented", nil, "", http.StatusNotImplemented), http.StatusInternalServerError, true},
+		{"transient", model.NewAppError("UploadFile", "api.file.upload_file.storage.app_error", nil, "connection reset", http.StatusInternalServerError), http.StatusInternalServerError, false},
+		{"quota", model.NewAppError("UploadFile", "api.file.upload_file.storage.app_error", nil, "no space left on device", http.StatusInternalServerError), http.StatusInsufficientStorage, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			p.writeUploadError(w, tc.appErr)
+			assert.Equal(t, tc.status, w.Code)
+			assert.Equal(t, tc.storageDown, w.Header().Get(headerUploadError) == uploadErrorStorageUnavailable)
+		})
+	}
+}
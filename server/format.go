@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// paragraphPauseSeconds is the gap between segments that ends a paragraph at
+	// the next sentence boundary.
+	paragraphPauseSeconds = 1.5
+	// paragraphMaxSentences ends a paragraph when the speaker never pauses that long.
+	paragraphMaxSentences = 4
+)
+
+// formatTranscript breaks a transcript into paragraphs for reading. Breaks go at
+// sentence ends, after a pause between segments when the provider reports timed
+// segments, and otherwise every few sentences. Text without sentence punctuation
+// is split at segment boundaries instead. It returns "" when no break applies.
+func formatTranscript(result *transcriptionResult) string {
+	text := strings.TrimSpace(result.Text)
+	if text == "" {
+		return ""
+	}
+
+	// Character offsets in the stitched segment text where a long pause occurred.
+	var pauses []int
+	if len(result.Segments) > 1 {
+		var sb strings.Builder
+		for i, seg := range result.Segments {
+			t := strings.TrimSpace(seg.Text)
+			if t == "" {
+				continue
+			}
+			if sb.Len() > 0 {
+				if seg.Start-result.Segments[i-1].End >= paragraphPauseSeconds {
+					pauses = append(pauses, sb.Len())
+				}
+				sb.WriteByte(' ')
+			}
+			sb.WriteString(t)
+		}
+		// Only trust the offsets if the segments spell out the same text.
+		if strings.Join(strings.Fields(sb.String()), " ") == strings.Join(strings.Fields(text), " ") {
+			text = sb.String()
+		} else {
+			pauses = nil
+		}
+	}
+
+	sentences, starts := splitSentences(text)
+	if len(sentences) <= 1 && len(result.Segments) > 1 {
+		sentences, starts, pauses = segmentTexts(result.Segments)
+	}
+	if len(sentences) <= 1 {
+		return ""
+	}
+
+	var paragraphs []string
+	var current []string
+	pending := false
+	for i, s := range sentences {
+		for len(pauses) > 0 && pauses[0] <= starts[i] {
+			pending = true
+			pauses = pauses[1:]
+		}
+		if len(current) > 0 && (pending || len(current) >= paragraphMaxSentences) {
+			paragraphs = append(paragraphs, strings.Join(current, " "))
+			current = nil
+		}
+		pending = false
+		current = append(current, s)
+	}
+	paragraphs = append(paragraphs, strings.Join(current, " "))
+	if len(paragraphs) == 1 {
+		return ""
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// splitSentences splits text after ".", "!", "?" or "…" (and their CJK forms)
+// followed by whitespace, returning each sentence and its offset in text.
+func splitSentences(text string) ([]string, []int) {
+	var sentences []string
+	var starts []int
+	start := 0
+	runes := []rune(text)
+	offset := 0
+	for i, r := range runes {
+		offset += len(string(r))
+		if !strings.ContainsRune(".!?…。！？", r) {
+			continue
+		}
+		if i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && !strings.ContainsRune("\"'”’)", runes[i+1]) {
+			continue
+		}
+		end := offset
+		// Keep closing quotes and brackets with the sentence they close.
+		for j := i + 1; j < len(runes) && strings.ContainsRune("\"'”’)", runes[j]); j++ {
+			end += len(string(runes[j]))
+		}
+		if s := strings.TrimSpace(text[start:end]); s != "" && end > start {
+			sentences = append(sentences, s)
+			starts = append(starts, start)
+		}
+		if end > start {
+			start = end
+		}
+	}
+	if s := strings.TrimSpace(text[start:]); s != "" {
+		sentences = append(sentences, s)
+		starts = append(starts, start)
+	}
+	return sentences, starts
+}
+
+// segmentTexts returns the non-empty segment texts for formatting, with segment
+// indexes standing in for offsets: each text's own index, and the indexes of
+// segments that follow a long pause.
+func segmentTexts(segments []transcriptSegment) ([]string, []int, []int) {
+	var texts []string
+	var starts, pauses []int
+	for i, seg := range segments {
+		if t := strings.TrimSpace(seg.Text); t != "" {
+			if i > 0 && seg.Start-segments[i-1].End >= paragraphPauseSeconds {
+				pauses = append(pauses, i)
+			}
+			texts = append(texts, t)
+			starts = append(starts, i)
+		}
+	}
+	return texts, starts, pauses
+}
+
+// displayTranscript returns the transcript to show for a post: the formatted one
+// when present, otherwise the raw voice_transcript.
+func displayTranscript(post *model.Post) string {
+	if t, _ := post.GetProp("voice_transcript_formatted").(string); t != "" {
+		return t
+	}
+	t, _ := post.GetProp("voice_transcript").(string)
+	return t
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// executeHelpCommand handles "/voice help", and any subcommand it doesn't know:
+// how recording works and the limits that apply to the calling user.
+func (p *Plugin) executeHelpCommand(args *model.CommandArgs, trigger, unknown string) *model.CommandResponse {
+	cfg := p.getConfig()
+
+	var sb strings.Builder
+	if unknown != "" {
+		fmt.Fprintf(&sb, "⚠️ Unknown subcommand `%s`.\n\n", unknown)
+	}
+	fmt.Fprintf(&sb, "#### 🎤 Voice Message\n\n"+
+		"In the web and desktop apps, `/%[1]s` opens the recorder right in the browser; so do the Voice Message button in the channel header and the microphone in the attachment menu. Elsewhere, e.g. in the mobile app, it replies with a one-time link to a recording page: open it, record, and tap Send to post the voice message in this channel or thread.\n\n"+
+		"| Command | |\n|---|---|\n"+
+		"| `/%[1]s` | Record a voice message here |\n"+
+		"| `/%[1]s @username` | Record a voice message for a direct message with that user |\n"+
+		"| `/%[1]s dictate` | Post only the transcript of your recording |\n"+
+		"| `/%[1]s silent` | Post without notifying anyone by mention |\n"+
+		"| `/%[1]s tag <name>[,<name>…]` | Tag the recording |\n"+
+		"| `/%[1]s redo` | Link to record again where you last recorded |\n"+
+		"| `/%[1]s link [dm]` | Show your latest unused link again, or have it sent as a direct message |\n"+
+		"| `/%[1]s help` | This help |\n", trigger)
+	if p.isSystemAdmin(args.UserId) {
+		fmt.Fprintf(&sb, "| `/%[1]s config` | Effective plugin settings (admins) |\n"+
+			"| `/%[1]s usage` | Transcription usage (admins) |\n", trigger)
+	}
+
+	maxDur := "no limit"
+	if seconds := p.maxDurationFor(cfg, args.UserId); seconds > 0 {
+		maxDur = formatMinutes(seconds)
+	}
+	transcription := "not enabled"
+	switch {
+	case cfg.transcriptionAvailable() && cfg.AutoTranscribe:
+		transcription = "enabled; new voice messages are transcribed automatically"
+	case cfg.transcriptionAvailable():
+		transcription = "enabled; use the transcribe button on a voice message"
+	case cfg.EnableTranscription:
+		transcription = "enabled but not configured; ask a system admin"
+	}
+	who := "all users"
+	if cfg.AllowedRoles != "" && cfg.AllowedRoles != "all" {
+		who = "system and team admins only"
+	}
+	fmt.Fprintf(&sb, "\n**Limits**\n"+
+		"- Recording length: %s\n"+
+		"- File size: %d MB\n"+
+		"- Link valid for: %s, once\n"+
+		"- Transcription: %s\n"+
+		"- Who can record: %s\n",
+		maxDur, cfg.getMaxFileSizeBytes()>>20, formatMinutes(cfg.getMobileTokenTTLSeconds()), transcription, who)
+	if !p.isUserAllowed(args.UserId) {
+		sb.WriteString("\n⛔ You don't have permission to send voice messages.")
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         sb.String(),
+		ChannelId:    args.ChannelId,
+	}
+}
+
+// formatMinutes renders a whole number of seconds as e.g. "5 min", "45 sec" or
+// "2 min 30 sec".
+func formatMinutes(seconds int) string {
+	switch {
+	case seconds < 60:
+		return fmt.Sprintf("%d sec", seconds)
+	case seconds%60 == 0:
+		return fmt.Sprintf("%d min", seconds/60)
+	default:
+		return fmt.Sprintf("%d min %d sec", seconds/60, seconds%60)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKeyCooldown is how long a key that hit a rate limit is tried only after the others.
+const apiKeyCooldown = time.Minute
+
+// getTranscriptionAPIKeys returns the keys in TranscriptionAPIKey, which may list
+// several separated by commas, without blanks or duplicates.
+func (c *Configuration) getTranscriptionAPIKeys() []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, k := range strings.Split(c.TranscriptionAPIKey, ",") {
+		k = strings.TrimSpace(k)
+		if k != "" && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// redactAPIKeys replaces any of the configured keys that leaked into s.
+func (c *Configuration) redactAPIKeys(s string) string {
+	keys := c.getTranscriptionAPIKeys()
+	creds, _ := c.getProviderCredentials()
+	for _, pc := range creds {
+		keys = append(keys, pc.keys...)
+	}
+	for _, k := range keys {
+		if len(k) > 8 {
+			s = strings.ReplaceAll(s, k, "***")
+		}
+	}
+	return s
+}
+
+// providerStatusError is a transcription provider's answer with a status other
+// than 200. Its message keeps the "status N" wording the logs have always had.
+type providerStatusError struct {
+	status int
+	body   string
+}
+
+func (e *providerStatusError) Error() string {
+	return fmt.Sprintf("api_error: status %d, body: %s", e.status, e.body)
+}
+
+// providerStatus returns the HTTP status of a provider error, or 0 when err
+// didn't come from a provider answer.
+func providerStatus(err error) int {
+	var se *providerStatusError
+	if errors.As(err, &se) {
+		return se.status
+	}
+	return 0
+}
+
+// providerCredentials are the keys and model a channel override that switches
+// to another provider uses instead of the global ones.
+type providerCredentials struct {
+	keys  []string
+	model string
+}
+
+// getProviderCredentials parses ProviderCredentials: one "provider=key[,key...]
+// [model]" entry per line, e.g. "openai=sk-abc whisper-1". Entries that don't
+// parse are returned as invalid by line number, so no key ends up in a log.
+func (c *Configuration) getProviderCredentials() (map[string]providerCredentials, []string) {
+	creds := map[string]providerCredentials{}
+	var invalid []string
+	for i, line := range strings.Split(c.ProviderCredentials, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		provider, rest, ok := strings.Cut(line, "=")
+		provider = strings.TrimSpace(provider)
+		fields := strings.Fields(rest)
+		_, known := responseParsers[provider]
+		if !ok || !known || len(fields) == 0 || len(fields) > 2 {
+			invalid = append(invalid, "line "+strconv.Itoa(i+1))
+			continue
+		}
+		pc := providerCredentials{keys: (&Configuration{TranscriptionAPIKey: fields[0]}).getTranscriptionAPIKeys()}
+		if len(fields) == 2 {
+			pc.model = fields[1]
+		}
+		creds[provider] = pc
+	}
+	return creds, invalid
+}
+
+// credentialsFor returns the keys and model for provider from ProviderCredentials.
+// Without a model in the entry the provider's default is used; a custom endpoint
+// has none, so it needs one.
+func (c *Configuration) credentialsFor(provider string) (providerCredentials, bool) {
+	creds, _ := c.getProviderCredentials()
+	pc, ok := creds[provider]
+	if !ok || len(pc.keys) == 0 {
+		return providerCredentials{}, false
+	}
+	if pc.model == "" {
+		switch provider {
+		case "deepinfra":
+			pc.model = defaultTranscriptionModel
+		case "openai":
+			pc.model = "whisper-1"
+		default:
+			return providerCredentials{}, false
+		}
+	}
+	return pc, true
+}
+
+// apiKeyPool rotates transcription requests across the configured keys and
+// remembers, per node and only for apiKeyCooldown, which keys were rate-limited.
+// The zero value is ready to use.
+type apiKeyPool struct {
+	mu        sync.Mutex
+	next      int
+	coolUntil map[string]time.Time
+}
+
+// order returns the keys to try for one request: round-robin from the pool's
+// cursor, with keys still cooling down moved to the end, soonest available first.
+func (kp *apiKeyPool) order(keys []string) []string {
+	if len(keys) <= 1 {
+		return keys
+	}
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	start := kp.next % len(keys)
+	kp.next = start + 1
+
+	now := time.Now()
+	ready := make([]string, 0, len(keys))
+	var cooling []string
+	for i := range keys {
+		k := keys[(start+i)%len(keys)]
+		if now.Before(kp.coolUntil[k]) {
+			cooling = append(cooling, k)
+		} else {
+			ready = append(ready, k)
+		}
+	}
+	sort.SliceStable(cooling, func(i, j int) bool { return kp.coolUntil[cooling[i]].Before(kp.coolUntil[cooling[j]]) })
+	return append(ready, cooling...)
+}
+
+// rateLimited puts a key on cooldown after a 429.
+func (kp *apiKeyPool) rateLimited(key string) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	if kp.coolUntil == nil {
+		kp.coolUntil = map[string]time.Time{}
+	}
+	now := time.Now()
+	for k, until := range kp.coolUntil {
+		if now.After(until) {
+			delete(kp.coolUntil, k)
+		}
+	}
+	kp.coolUntil[key] = now.Add(apiKeyCooldown)
+}
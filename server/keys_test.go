@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviderStatus(t *testing.T) {
+	err := fmt.Errorf("%w (chunk 2 of 3)", &providerStatusError{status: http.StatusTooManyRequests, body: "slow down"})
+	assert.Equal(t, http.StatusTooManyRequests, providerStatus(err))
+	assert.Contains(t, err.Error(), "status 429")
+	assert.Equal(t, 0, providerStatus(fmt.Errorf("network: status 429 in a proxy page")))
+}
+
+func TestCallWhisperAPIFailsOverOnRateLimit(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Get("Authorization"))
+		mu.Unlock()
+		if r.Header.Get("Authorization") == "Bearer limited-key" {
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"text":"hello"}`))
+	}))
+	defer srv.Close()
+
+	p := &Plugin{}
+	p.SetAPI(newFakeKVAPI())
+	p.configuration = &Configuration{}
+	target := &transcriptionTarget{Provider: "custom", URL: srv.URL, APIKeys: []string{"limited-key", "spare-key"}, Model: "m"}
+
+	result, err := p.callWhisperAPI(testWAV(), "audio/wav", target)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", result.Text)
+	assert.Equal(t, []string{"Bearer limited-key", "Bearer spare-key"}, seen)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+// fakeKVAPI is a plugin API whose KV store is an in-memory map with real
+// compare-and-set semantics; every other call goes to the embedded mock.
+type fakeKVAPI struct {
+	*plugintest.API
+
+	mu sync.Mutex
+	kv map[string][]byte
+
+	// beforeSet, when set, runs before KVSetWithOptions takes the lock.
+	beforeSet func(key string)
+	// afterDelete, when set, runs after KVDelete has removed a key.
+	afterDelete func(key string)
+}
+
+func newFakeKVAPI() *fakeKVAPI {
+	api := &fakeKVAPI{API: &plugintest.API{}, kv: map[string][]byte{}}
+	for _, level := range []string{"LogDebug", "LogInfo", "LogWarn", "LogError"} {
+		for n := 0; n <= 24; n++ {
+			args := make([]any, n+1)
+			for i := range args {
+				args[i] = mock.Anything
+			}
+			api.On(level, args...).Maybe()
+		}
+	}
+	return api
+}
+
+func (a *fakeKVAPI) KVGet(key string) ([]byte, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.kv[key], nil
+}
+
+func (a *fakeKVAPI) KVSet(key string, value []byte) *model.AppError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.kv[key] = value
+	return nil
+}
+
+func (a *fakeKVAPI) KVSetWithOptions(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+	if a.beforeSet != nil {
+		a.beforeSet(key)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if options.Atomic {
+		current, ok := a.kv[key]
+		if options.OldValue == nil && ok || options.OldValue != nil && !bytes.Equal(current, options.OldValue) {
+			return false, nil
+		}
+	}
+	if value == nil {
+		delete(a.kv, key)
+	} else {
+		a.kv[key] = value
+	}
+	return true, nil
+}
+
+func (a *fakeKVAPI) KVCompareAndSet(key string, oldValue, newValue []byte) (bool, *model.AppError) {
+	return a.KVSetWithOptions(key, newValue, model.PluginKVSetOptions{Atomic: true, OldValue: oldValue})
+}
+
+func (a *fakeKVAPI) KVCompareAndDelete(key string, oldValue []byte) (bool, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if current, ok := a.kv[key]; !ok || !bytes.Equal(current, oldValue) {
+		return false, nil
+	}
+	delete(a.kv, key)
+	return true, nil
+}
+
+func (a *fakeKVAPI) KVDelete(key string) *model.AppError {
+	a.mu.Lock()
+	delete(a.kv, key)
+	a.mu.Unlock()
+	if a.afterDelete != nil {
+		a.afterDelete(key)
+	}
+	return nil
+}
+
+func (a *fakeKVAPI) KVList(page, perPage int) ([]string, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	keys := make([]string, 0, len(a.kv))
+	for k := range a.kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	start := page * perPage
+	if start >= len(keys) {
+		return []string{}, nil
+	}
+	return keys[start:min(start+perPage, len(keys))], nil
+}
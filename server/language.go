@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// whisperLanguageCodes maps the language names Whisper's verbose_json reports to
+// the ISO 639-1 codes admins list in AllowedTranscriptionLanguages.
+var whisperLanguageCodes = map[string]string{
+	"arabic": "ar", "chinese": "zh", "czech": "cs", "danish": "da", "dutch": "nl",
+	"english": "en", "finnish": "fi", "french": "fr", "german": "de", "greek": "el",
+	"hebrew": "he", "hindi": "hi", "hungarian": "hu", "indonesian": "id", "italian": "it",
+	"japanese": "ja", "korean": "ko", "norwegian": "no", "polish": "pl", "portuguese": "pt",
+	"romanian": "ro", "russian": "ru", "spanish": "es", "swedish": "sv", "thai": "th",
+	"turkish": "tr", "ukrainian": "uk", "vietnamese": "vi",
+}
+
+// normalizeLanguage turns "English", "en" or "en-US" into "en". Names outside
+// whisperLanguageCodes are returned lowercased.
+func normalizeLanguage(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if code, ok := whisperLanguageCodes[s]; ok {
+		return code
+	}
+	if base, _, ok := strings.Cut(strings.ReplaceAll(s, "_", "-"), "-"); ok && len(base) == 2 {
+		return base
+	}
+	return s
+}
+
+// getAllowedTranscriptionLanguages returns the normalized AllowedTranscriptionLanguages
+// list; empty means any language is allowed.
+func (c *Configuration) getAllowedTranscriptionLanguages() []string {
+	var langs []string
+	for _, l := range strings.Split(c.AllowedTranscriptionLanguages, ",") {
+		if l = normalizeLanguage(l); l != "" {
+			langs = append(langs, l)
+		}
+	}
+	return langs
+}
+
+// transcriptLanguageWarning explains why a detected language is not allowed, or
+// returns "" when it is. A transcript whose language the provider didn't report
+// passes, since there is nothing to check.
+func (c *Configuration) transcriptLanguageWarning(detected string) string {
+	allowed := c.getAllowedTranscriptionLanguages()
+	lang := normalizeLanguage(detected)
+	if len(allowed) == 0 || lang == "" {
+		return ""
+	}
+	for _, a := range allowed {
+		if a == lang {
+			return ""
+		}
+	}
+	return fmt.Sprintf("Detected language %q is not one of the allowed languages (%s)", lang, strings.Join(allowed, ", "))
+}
+
+// withholdsTranscript reports whether a transcript in the detected language must
+// not be stored.
+func (c *Configuration) withholdsTranscript(detected string) bool {
+	return c.SuppressDisallowedLanguages && c.transcriptLanguageWarning(detected) != ""
+}
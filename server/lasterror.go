@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	kvLastTranscriptionErrorPrefix = "vm_last_transcription_error_"
+
+	// lastErrorMaxProviders bounds the keys read by the last-error endpoint.
+	lastErrorMaxProviders = 50
+)
+
+// transcriptionErrorRecord is the most recent failed transcription for one
+// provider. Error is redacted before it is stored.
+type transcriptionErrorRecord struct {
+	Provider string `json:"provider"`
+	PostID   string `json:"post_id"`
+	Error    string `json:"error"`
+	At       int64  `json:"at"`
+}
+
+// recordTranscriptionError keeps the latest failure per provider so admins can see
+// why auto-transcription failed without reading the server logs.
+func (p *Plugin) recordTranscriptionError(cfg *Configuration, provider, postID string, err error) {
+	provider = strings.TrimSpace(provider)
+	if provider == "" {
+		provider = "default"
+	}
+	b, _ := json.Marshal(transcriptionErrorRecord{
+		Provider: provider,
+		PostID:   postID,
+		Error:    truncate(cfg.redactAPIKeys(err.Error()), 1000),
+		At:       model.GetMillis(),
+	})
+	if appErr := p.API.KVSet(kvLastTranscriptionErrorPrefix+provider, b); appErr != nil {
+		p.API.LogWarn("Failed to store last transcription error", "provider", provider, "err", appErr.Error())
+	}
+}
+
+// handleLastTranscriptionError serves GET /api/v1/admin/transcribe/last-error with
+// the latest failure of each provider, newest first. ?provider= narrows it to one.
+func (p *Plugin) handleLastTranscriptionError(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var keys []string
+	if provider := strings.TrimSpace(r.URL.Query().Get("provider")); provider != "" {
+		keys = []string{kvLastTranscriptionErrorPrefix + provider}
+	} else {
+		var err error
+		keys, err = p.listKeys(kvLastTranscriptionErrorPrefix, lastErrorMaxProviders)
+		if err != nil {
+			http.Error(w, "Failed to list errors", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	records := []transcriptionErrorRecord{}
+	for _, key := range keys {
+		b, appErr := p.API.KVGet(key)
+		if appErr != nil || b == nil {
+			continue
+		}
+		var rec transcriptionErrorRecord
+		if json.Unmarshal(b, &rec) == nil {
+			records = append(records, rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].At > records[j].At })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]any{"errors": records})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// kvCurrentLinkPrefix holds the token of the user's latest recording link, so
+// "/voice link" can show it again. It expires with the token.
+const kvCurrentLinkPrefix = "vm_current_link_"
+
+// rememberCurrentLink records token as the user's latest recording link.
+func (p *Plugin) rememberCurrentLink(userID, token string, expiresAt int64) {
+	ttl := expiresAt - time.Now().Unix()
+	if ttl <= 0 {
+		return
+	}
+	if appErr := p.API.KVSetWithExpiry(kvCurrentLinkPrefix+userID, []byte(token), ttl); appErr != nil {
+		p.API.LogWarn("Failed to remember recording link", "user_id", userID, "err", appErr.Error())
+	}
+}
+
+// executeLinkCommand handles "/voice link [dm]": the user's latest recording link,
+// if still unused and valid, shown again as an ephemeral post or, with "dm", sent
+// by the bot as a direct message that doesn't scroll away. The same token is
+// re-sent, so no additional valid link is created.
+func (p *Plugin) executeLinkCommand(args *model.CommandArgs, rest []string) *model.CommandResponse {
+	reply := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+			ChannelId:    args.ChannelId,
+		}
+	}
+	toDM := len(rest) > 0 && strings.EqualFold(rest[0], "dm")
+	if len(rest) > 0 && !toDM {
+		return reply("⚠️ usage: /voice link [dm]")
+	}
+
+	token := ""
+	if b, appErr := p.API.KVGet(kvCurrentLinkPrefix + args.UserId); appErr == nil {
+		token = string(b)
+	}
+	mt, err := p.getMobileToken(token)
+	if token == "" || err != nil || mt.UserID != args.UserId {
+		return reply("⚠️ You have no unused recording link. Run /voice to get one, or /voice redo for your last channel.")
+	}
+
+	recURL := p.buildMobileRecordURL(token, mt.ChannelID, mt.RootID)
+	where := mt.ChannelID
+	if ch, appErr := p.API.GetChannel(mt.ChannelID); appErr == nil && ch.DisplayName != "" {
+		where = ch.DisplayName
+	}
+	minutesLeft := max(1, (mt.ExpiresAt-time.Now().Unix()+59)/60)
+	text := fmt.Sprintf("🎤 **Voice Message** for %s\n\nOpen the recording page:\n%s\n\n*Link valid for ~%d more min (one-time use).*", where, recURL, minutesLeft)
+
+	if !toDM {
+		sent := p.API.SendEphemeralPost(args.UserId, &model.Post{
+			UserId:    args.UserId,
+			ChannelId: args.ChannelId,
+			Message:   text,
+		})
+		if sent != nil && sent.Id != "" && args.ChannelId == mt.ChannelID {
+			// The page replaces this post with the result once sent.
+			_ = p.setMobileTokenEphemeralPostID(token, sent.Id)
+		}
+		return reply("")
+	}
+
+	if p.botID == "" {
+		return reply("⚠️ The voice message bot isn't available, so the link can't be sent as a direct message.")
+	}
+	dm, appErr := p.API.GetDirectChannel(args.UserId, p.botID)
+	if appErr != nil {
+		p.API.LogError("GetDirectChannel failed", "user_id", args.UserId, "err", appErr.Error())
+		return reply("⚠️ Couldn't open a direct message with the voice message bot.")
+	}
+	if _, appErr := p.API.CreatePost(&model.Post{
+		UserId:    p.botID,
+		ChannelId: dm.Id,
+		Message:   text,
+	}); appErr != nil {
+		p.API.LogError("Failed to send recording link by DM", "user_id", args.UserId, "err", appErr.Error())
+		return reply("⚠️ Couldn't send the recording link as a direct message.")
+	}
+	return reply("📬 Your recording link was sent to you as a direct message.")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// metrics holds plugin-wide counters. Every field is an atomic so handlers and
+// background goroutines can update them concurrently without extra locking.
+type metrics struct {
+	uploads                atomic.Int64
+	uploadFailures         atomic.Int64
+	transcriptions         atomic.Int64
+	transcriptionFailures  atomic.Int64
+	autoTranscribeSkipped  atomic.Int64
+	autoTranscribeDeferred atomic.Int64 // queued during quiet hours
+}
+
+// metricsSnapshot is a point-in-time copy of the counters, safe to serialize.
+type metricsSnapshot struct {
+	Uploads                int64 `json:"uploads"`
+	UploadFailures         int64 `json:"upload_failures"`
+	Transcriptions         int64 `json:"transcriptions"`
+	TranscriptionFailures  int64 `json:"transcription_failures"`
+	AutoTranscribeSkipped  int64 `json:"auto_transcribe_skipped"`
+	AutoTranscribeDeferred int64 `json:"auto_transcribe_deferred"`
+}
+
+func (m *metrics) snapshot() metricsSnapshot {
+	return metricsSnapshot{
+		Uploads:                m.uploads.Load(),
+		UploadFailures:         m.uploadFailures.Load(),
+		Transcriptions:         m.transcriptions.Load(),
+		TranscriptionFailures:  m.transcriptionFailures.Load(),
+		AutoTranscribeSkipped:  m.autoTranscribeSkipped.Load(),
+		AutoTranscribeDeferred: m.autoTranscribeDeferred.Load(),
+	}
+}
+
+// handleMetrics returns the current counters (system admins only).
+func (p *Plugin) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !p.isSystemAdmin(userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(p.metrics.snapshot())
+}
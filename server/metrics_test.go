@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsConcurrentIncrements(t *testing.T) {
+	const goroutines, perGoroutine = 50, 1000
+	var m metrics
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perGoroutine; j++ {
+				m.uploads.Add(1)
+				m.transcriptions.Add(1)
+				if j%10 == 0 {
+					m.uploadFailures.Add(1)
+				}
+				_ = m.snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+
+	b, err := json.Marshal(m.snapshot())
+	require.NoError(t, err)
+	var got map[string]int64
+	require.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, map[string]int64{
+		"uploads":                  goroutines * perGoroutine,
+		"upload_failures":          goroutines * perGoroutine / 10,
+		"transcriptions":           goroutines * perGoroutine,
+		"transcription_failures":   0,
+		"auto_transcribe_skipped":  0,
+		"auto_transcribe_deferred": 0,
+	}, got)
+}
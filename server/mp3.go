@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// mp3ConversionTimeout bounds one ffmpeg run, conversion or decode.
+	mp3ConversionTimeout = 2 * time.Minute
+
+	// maxMP3Bytes bounds ffmpeg's output; a larger result is discarded.
+	maxMP3Bytes = 200 << 20
+
+	// maxMP3Conversions bounds concurrent ffmpeg processes; conversions beyond it
+	// wait for a slot.
+	maxMP3Conversions = 2
+)
+
+// ffmpegInputFormats maps a detected container to the ffmpeg demuxer that reads
+// it, so uploaded bytes are never probed as some other format.
+var ffmpegInputFormats = map[string]string{
+	"audio/webm": "matroska",
+	"audio/ogg":  "ogg",
+	"audio/wav":  "wav",
+	"audio/mp4":  "mov",
+	"audio/mpeg": "mp3",
+	"audio/flac": "flac",
+}
+
+// limitedBuffer collects up to max bytes and fails writes beyond that, which
+// makes ffmpeg stop on an oversized result.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(data []byte) (int, error) {
+	if b.Len()+len(data) > b.max {
+		return 0, fmt.Errorf("output over %d bytes", b.max)
+	}
+	return b.Buffer.Write(data)
+}
+
+// ffmpegAvailable reports whether ffmpeg is on the server's PATH.
+func ffmpegAvailable() bool {
+	_, err := exec.LookPath("ffmpeg")
+	return err == nil
+}
+
+// convertToMP3 re-encodes audio to MP3 with ffmpeg from the server's PATH.
+func (p *Plugin) convertToMP3(ctx context.Context, data []byte, filename string) ([]byte, error) {
+	return p.runFFmpeg(ctx, data, filename, maxMP3Bytes,
+		"-vn", "-codec:a", "libmp3lame", "-q:a", "4",
+		"-f", "mp3",
+	)
+}
+
+// runFFmpeg runs ffmpeg from the server's PATH on audio, once a slot is free or
+// ctx is done, and returns what it writes with outputArgs, up to maxOut bytes.
+// The input goes through a temporary file because MP4 audio can't be demuxed
+// from a pipe; ffmpeg reads it with the demuxer for the detected container and
+// may open no other file or protocol.
+func (p *Plugin) runFFmpeg(ctx context.Context, data []byte, filename string, maxOut int, outputArgs ...string) ([]byte, error) {
+	inputFormat, ok := ffmpegInputFormats[detectAudioFormat(data)]
+	if !ok {
+		return nil, errors.New("unrecognized audio format")
+	}
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg not found: %w", err)
+	}
+
+	select {
+	case p.mp3Sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-p.mp3Sem }()
+
+	in, err := os.CreateTemp("", "voice-*"+path.Ext(filename))
+	if err != nil {
+		return nil, fmt.Errorf("temp file: %w", err)
+	}
+	defer os.Remove(in.Name())
+	_, err = in.Write(data)
+	if closeErr := in.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("temp file: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mp3ConversionTimeout)
+	defer cancel()
+	args := []string{
+		"-nostdin", "-hide_banner", "-loglevel", "error",
+		"-protocol_whitelist", "file", "-f", inputFormat,
+		"-i", in.Name(),
+	}
+	cmd := exec.CommandContext(ctx, ffmpeg, append(append(args, outputArgs...), "pipe:1")...)
+	out := &limitedBuffer{max: maxOut}
+	var stderr bytes.Buffer
+	cmd.Stdout = out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("ffmpeg timed out after %s", mp3ConversionTimeout)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, truncate(strings.TrimSpace(stderr.String()), 300))
+	}
+	if out.Len() == 0 {
+		return nil, errors.New("ffmpeg produced no audio")
+	}
+	return out.Bytes(), nil
+}
+
+// exportAudio returns the audio to put in an archive copy or export: converted to
+// MP3 when ConvertToMP3ForArchive is on, and otherwise, or when conversion fails,
+// the original with a logged warning. ctx bounds the wait for a conversion slot.
+// The stored original is never changed.
+func (p *Plugin) exportAudio(ctx context.Context, cfg *Configuration, data []byte, filename string) ([]byte, string) {
+	if !cfg.ConvertToMP3ForArchive || strings.EqualFold(path.Ext(filename), ".mp3") {
+		return data, filename
+	}
+	converted, err := p.convertToMP3(ctx, data, filename)
+	if err != nil {
+		p.API.LogWarn("MP3 conversion failed; keeping the original format", "filename", filename, "err", err.Error())
+		return data, filename
+	}
+	return converted, strings.TrimSuffix(filename, path.Ext(filename)) + ".mp3"
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeFFmpeg puts an ffmpeg on PATH that records its arguments and prints "mp3".
+func fakeFFmpeg(t *testing.T) (argsFile string) {
+	dir := t.TempDir()
+	argsFile = filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\nprintf mp3\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0o755))
+	t.Setenv("PATH", dir)
+	return argsFile
+}
+
+func TestConvertToMP3PinsInputFormat(t *testing.T) {
+	argsFile := fakeFFmpeg(t)
+	p := &Plugin{mp3Sem: make(chan struct{}, maxMP3Conversions)}
+
+	out, err := p.convertToMP3(context.Background(), testWAV(), "voice.webm")
+	require.NoError(t, err)
+	assert.Equal(t, "mp3", string(out))
+	args, err := os.ReadFile(argsFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(args), "-protocol_whitelist file -f wav -i ", "the demuxer follows the bytes, not the filename")
+
+	_, err = p.convertToMP3(context.Background(), []byte("#EXTM3U\nhttp://example.com/a\n"), "voice.webm")
+	assert.Error(t, err, "bytes of no known container are not handed to ffmpeg")
+}
+
+func TestConvertToMP3WaitsForSlot(t *testing.T) {
+	fakeFFmpeg(t)
+	p := &Plugin{mp3Sem: make(chan struct{}, 1)}
+	p.mp3Sem <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := p.convertToMP3(ctx, testWAV(), "voice.wav")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		<-p.mp3Sem
+	}()
+	out, err := p.convertToMP3(context.Background(), testWAV(), "voice.wav")
+	require.NoError(t, err)
+	assert.Equal(t, "mp3", string(out))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// maxStoredTimingBytes caps the JSON size of voice_transcript_segments.
+const maxStoredTimingBytes = 32 << 10
+
+// transcriptSegment is one timed piece of a transcript.
+type transcriptSegment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+// transcriptionResult is a provider response normalized by a responseParser.
+type transcriptionResult struct {
+	Text     string
+	Segments []transcriptSegment
+	// Words holds word-level timings when the provider returns them.
+	Words    []transcriptSegment
+	Language string
+	// English is the translation requested with AutoTranslateToEnglish, if any.
+	English string
+	// Confidence is on a 0–1 scale, or nil when the provider doesn't report one.
+	Confidence *float64
+}
+
+// downsampleSegments merges runs of consecutive segments so that at most limit
+// remain, keeping each merged segment's first start and last end so captions
+// still line up approximately. limit <= 0 keeps every segment.
+func downsampleSegments(segments []transcriptSegment, limit int) []transcriptSegment {
+	if limit <= 0 || len(segments) <= limit {
+		return segments
+	}
+	per := (len(segments) + limit - 1) / limit
+	merged := make([]transcriptSegment, 0, limit)
+	for i := 0; i < len(segments); i += per {
+		group := segments[i:min(i+per, len(segments))]
+		texts := make([]string, 0, len(group))
+		for _, seg := range group {
+			texts = append(texts, seg.Text)
+		}
+		merged = append(merged, transcriptSegment{
+			Start: group[0].Start,
+			End:   group[len(group)-1].End,
+			Text:  strings.Join(texts, " "),
+		})
+	}
+	return merged
+}
+
+// storedTimings returns the timed entries kept in voice_transcript_segments: the
+// words when there are any, else the segments, with times rounded to centiseconds.
+// Only the leading entries whose JSON fits in maxStoredTimingBytes are kept, so a
+// very long recording has timings for its start; the transcript itself is whole.
+func storedTimings(result *transcriptionResult) []transcriptSegment {
+	source := result.Words
+	if len(source) == 0 {
+		source = result.Segments
+	}
+	timings := make([]transcriptSegment, 0, len(source))
+	size := len("[]")
+	for _, seg := range source {
+		entry := transcriptSegment{
+			Start: math.Round(seg.Start*100) / 100,
+			End:   math.Round(seg.End*100) / 100,
+			Text:  seg.Text,
+		}
+		b, err := json.Marshal(entry)
+		if err != nil {
+			continue
+		}
+		if size += len(b) + 1; size > maxStoredTimingBytes {
+			break
+		}
+		timings = append(timings, entry)
+	}
+	return timings
+}
+
+// responseParser turns a provider's raw response body into transcript text,
+// timed segments, the detected language (empty when unknown) and confidence.
+type responseParser interface {
+	parse(body []byte) (*transcriptionResult, error)
+}
+
+// responseParsers maps a TranscriptionProvider key to its parser. New providers
+// register here; unknown keys fall back to the Whisper-compatible parser.
+var responseParsers = map[string]responseParser{
+	"deepinfra": whisperParser{},
+	"openai":    whisperParser{},
+	"custom":    whisperParser{},
+}
+
+func parserForProvider(provider string) responseParser {
+	if rp, ok := responseParsers[strings.TrimSpace(provider)]; ok {
+		return rp
+	}
+	return whisperParser{}
+}
+
+// whisperParser handles OpenAI-compatible JSON and verbose_json responses,
+// including DeepInfra's inference endpoint.
+type whisperParser struct{}
+
+func (whisperParser) parse(body []byte) (*transcriptionResult, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("parse_error: invalid JSON: %w (body: %s)", err, truncate(string(body), 200))
+	}
+
+	// Fields are decoded individually so one unexpected shape doesn't discard the rest.
+	var text, language string
+	if v, ok := raw["text"]; ok {
+		_ = json.Unmarshal(v, &text)
+	}
+	if v, ok := raw["language"]; ok {
+		_ = json.Unmarshal(v, &language)
+	}
+
+	var segments []transcriptSegment
+	var logprobSum float64
+	var logprobCount int
+	if v, ok := raw["segments"]; ok {
+		var all []struct {
+			transcriptSegment
+			AvgLogprob *float64 `json:"avg_logprob"`
+		}
+		if err := json.Unmarshal(v, &all); err == nil {
+			for _, seg := range all {
+				if seg.AvgLogprob != nil {
+					logprobSum += *seg.AvgLogprob
+					logprobCount++
+				}
+				if t := strings.TrimSpace(seg.Text); t != "" {
+					seg.Text = t
+					segments = append(segments, seg.transcriptSegment)
+				}
+			}
+		}
+	}
+
+	// OpenAI's timestamp_granularities[]=word reports {word, start, end} entries.
+	var words []transcriptSegment
+	if v, ok := raw["words"]; ok {
+		var all []struct {
+			Word  string  `json:"word"`
+			Start float64 `json:"start"`
+			End   float64 `json:"end"`
+		}
+		if err := json.Unmarshal(v, &all); err == nil {
+			for _, w := range all {
+				if t := strings.TrimSpace(w.Word); t != "" {
+					words = append(words, transcriptSegment{Start: w.Start, End: w.End, Text: t})
+				}
+			}
+		}
+	}
+
+	result := &transcriptionResult{Segments: segments, Words: words, Language: strings.TrimSpace(language)}
+
+	// Prefer an explicit overall confidence; otherwise derive one from Whisper's
+	// verbose_json segment log-probabilities (exp of the mean maps onto 0–1).
+	var overall float64
+	if v, ok := raw["confidence"]; ok && json.Unmarshal(v, &overall) == nil && overall >= 0 && overall <= 1 {
+		result.Confidence = &overall
+	} else if logprobCount > 0 {
+		c := math.Min(1, math.Max(0, math.Exp(logprobSum/float64(logprobCount))))
+		result.Confidence = &c
+	}
+
+	// Prefer the top-level "text" field.
+	if t := strings.TrimSpace(text); t != "" {
+		result.Text = t
+		return result, nil
+	}
+
+	// Fallback: build text from segments (DeepInfra sometimes returns text="" with segments filled).
+	if len(segments) > 0 {
+		parts := make([]string, 0, len(segments))
+		for _, seg := range segments {
+			parts = append(parts, seg.Text)
+		}
+		result.Text = strings.Join(parts, " ")
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("parse_error: no transcript text found in response (body: %s)", truncate(string(body), 300))
+}
@@ -0,0 +1,40 @@
+package main
+
+import "time"
+
+// playlistWindow is how soon after the previous clip a voice message must follow
+// to join its playlist.
+const playlistWindow = 5 * time.Minute
+
+// playlistIDFor returns the voice_playlist_id for a new voice message when it
+// directly follows another voice message by the same author in the same thread
+// within playlistWindow, or "" when it starts nothing new. The playlist is named
+// after its first post; when the previous clip isn't tagged yet it is tagged here
+// so the playlist covers it too.
+func (p *Plugin) playlistIDFor(cfg *Configuration, channelID, rootID, userID string) string {
+	if !cfg.GroupConsecutiveVoice {
+		return ""
+	}
+	list, appErr := p.API.GetPostsForChannel(channelID, 0, 1)
+	if appErr != nil || len(list.Order) == 0 {
+		return ""
+	}
+	prev := list.Posts[list.Order[0]]
+	if prev == nil || prev.Type != "custom_voice_message" || prev.DeleteAt != 0 ||
+		prev.UserId != userID || prev.RootId != rootID {
+		return ""
+	}
+	if time.Since(time.UnixMilli(prev.CreateAt)) > playlistWindow {
+		return ""
+	}
+
+	if id, _ := prev.GetProp("voice_playlist_id").(string); id != "" {
+		return id
+	}
+	prev.AddProp("voice_playlist_id", prev.Id)
+	if _, appErr := p.API.UpdatePost(prev); appErr != nil {
+		p.API.LogWarn("Failed to tag previous voice message with playlist", "post_id", prev.Id, "err", appErr.Error())
+		return ""
+	}
+	return prev.Id
+}
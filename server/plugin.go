@@ -2,18 +2,26 @@ package main
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io"
+	"math"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/textproto"
 	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
@@ -30,16 +38,48 @@ const (
 	defaultMobileTokenTTLSeconds       = 15 * 60
 	defaultMaxFileSizeMB               = 50
 	defaultTranscriptionMaxDurSec      = 300
-
-	kvMobileTokenPrefix = "vm_mobile_token_"
+	defaultMaxConcurrentUploads        = 8
+	defaultSilenceThreshold            = 0.05
+	defaultMobileUploadRetries         = 2
+	maxMobileUploadRetries             = 5
+	minSilenceAutoStopSeconds          = 2
+	maxStartCountdownSeconds           = 10
+	defaultTranscriptionChunkSeconds   = 120
+	minTranscriptionChunkSeconds       = 10
+	defaultMaxTranscriptionsPerChannel = 0
+	defaultMaxTranscriptChars          = 4000
+	defaultTranscriptionModel          = "openai/whisper-large-v3-turbo"
+	maxRecordingPageNoticeRunes        = 1000
+	maxSentScreenMessageRunes          = 300
+	maxRecordingGain                   = 10
+	mobileTokenUpdateAttempts          = 3
+	defaultSuccessMessageSeconds       = 6
+
+	playbackOff      = "off"
+	playbackAdvisory = "advisory"
+	playbackEnforce  = "enforce"
+
+	transcriptInMessageOff     = "off"
+	transcriptInMessageAppend  = "append"
+	transcriptInMessageReplace = "replace"
+
+	headerPlaybackConfirmed = "X-Voice-Playback-Confirmed"
+
+	kvMobileTokenPrefix          = "vm_mobile_token_"
+	kvChannelTranscriptionPrefix = "vm_channel_transcription_"
 )
 
 type mobileToken struct {
 	UserID          string `json:"user_id"`
 	ChannelID       string `json:"channel_id"`
 	RootID          string `json:"root_id,omitempty"`
-	EphemeralPostID string `json:"ephemeral_post_id,omitempty"`
-	ExpiresAt       int64  `json:"expires_at"`
+	EphemeralPostID string   `json:"ephemeral_post_id,omitempty"`
+	Tags            []string `json:"tags,omitempty"`
+	Dictate         bool     `json:"dictate,omitempty"`
+	Silent          bool     `json:"silent,omitempty"`
+	ExpiresAt       int64    `json:"expires_at"`
+	// MaxDurationSeconds is the user's recording limit when the token was issued.
+	MaxDurationSeconds *int `json:"max_duration_seconds,omitempty"`
 }
 
 // Plugin implements plugin.MattermostPlugin.
@@ -48,6 +88,24 @@ type Plugin struct {
 	configLock       sync.RWMutex
 	configuration    *Configuration
 	transcribeSem    chan struct{} // limits concurrent auto-transcribe goroutines
+	searchIndexSem   chan struct{} // limits concurrent search index deliveries
+	mp3Sem           chan struct{} // limits concurrent ffmpeg processes
+	botID            string
+	stopBackground   chan struct{} // closed on deactivate to end the maintenance ticker
+	backgroundDone   chan struct{}
+	metrics          metrics
+	uploadsInFlight  atomic.Int64 // server-wide in-flight uploads, bounded by MaxConcurrentUploads
+	progress         uploadProgressHub
+	apiKeys          apiKeyPool
+
+	channelTranscribeLock sync.Mutex
+	channelTranscribing   map[string]int // in-flight auto-transcriptions per channel
+
+	transcriptionFlightLock sync.Mutex
+	transcriptionFlights    map[string]*transcriptionFlight // in-flight manual transcriptions per post
+
+	signingLock sync.Mutex
+	signingKey  []byte // cached HMAC key for signed audio URLs
 }
 
 // Configuration from System Console settings.
@@ -62,8 +120,69 @@ type Configuration struct {
 	TranscriptionServiceURL        string `json:"TranscriptionServiceURL"`
 	TranscriptionModel             string `json:"TranscriptionModel"`
 	TranscriptionLanguage          string `json:"TranscriptionLanguage"`
+	AllowedTranscriptionLanguages  string `json:"AllowedTranscriptionLanguages"`
+	SuppressDisallowedLanguages    bool   `json:"SuppressDisallowedLanguages"`
 	TranscriptionMaxDurationSeconds string `json:"TranscriptionMaxDurationSeconds"`
 	AutoTranscribe                 bool   `json:"AutoTranscribe"`
+	NormalizeLoudness              bool   `json:"NormalizeLoudness"`
+	RequireSessionForMobileUpload  bool   `json:"RequireSessionForMobileUpload"`
+	MaxConcurrentUploads           string `json:"MaxConcurrentUploads"`
+	SilenceAutoStopSeconds         string `json:"SilenceAutoStopSeconds"`
+	SilenceThreshold               string `json:"SilenceThreshold"`
+	RequirePlaybackBeforeSend      string `json:"RequirePlaybackBeforeSend"`
+	MobileUploadRetries            string `json:"MobileUploadRetries"`
+	PreferredRecordingMimes        string `json:"PreferredRecordingMimes"`
+	StartCountdownSeconds          string `json:"StartCountdownSeconds"`
+	EnableChunkedTranscription     bool   `json:"EnableChunkedTranscription"`
+	TranscriptionChunkSeconds      string `json:"TranscriptionChunkSeconds"`
+	MentionOnVoiceMessage          string `json:"MentionOnVoiceMessage"`
+	MaxTranscriptionsPerChannel    string `json:"MaxTranscriptionsPerChannel"`
+	ArchiveChannelID               string `json:"ArchiveChannelID"`
+	AutoTranscribeQuietHours       string `json:"AutoTranscribeQuietHours"`
+	DisableTranscriptCache         bool   `json:"DisableTranscriptCache"`
+	GroupConsecutiveVoice          bool   `json:"GroupConsecutiveVoice"`
+	DictationAttachAudio           bool   `json:"DictationAttachAudio"`
+	AuthorizationWebhookURL        string `json:"AuthorizationWebhookURL"`
+	AuthorizationWebhookFailOpen   bool   `json:"AuthorizationWebhookFailOpen"`
+	RetryFailedFileUpload          bool   `json:"RetryFailedFileUpload"`
+	DeleteAudioAfterTranscription  bool   `json:"DeleteAudioAfterTranscription"`
+	FormatTranscript               bool   `json:"FormatTranscript"`
+	TrustProxyHeaders              bool   `json:"TrustProxyHeaders"`
+	AutoTranscribePutInMessage     string `json:"AutoTranscribePutInMessage"`
+	MaxTranscriptChars             string `json:"MaxTranscriptChars"`
+	TranscriptionCostPerMinute     string `json:"TranscriptionCostPerMinute"`
+	FallbackAcceptTypes            string `json:"FallbackAcceptTypes"`
+	SilentChannels                 string `json:"SilentChannels"`
+	RecordingPageNotice            string `json:"RecordingPageNotice"`
+	RecordingBeep                  bool   `json:"RecordingBeep"`
+	PrewarmMic                     bool   `json:"PrewarmMic"`
+	DisableRecorderFallback        bool   `json:"DisableRecorderFallback"`
+	SuccessMessageTemplate         string `json:"SuccessMessageTemplate"`
+	SuccessMessageSeconds          string `json:"SuccessMessageSeconds"`
+	MaxStoredSegments              string `json:"MaxStoredSegments"`
+	VoiceDigestChannelID           string `json:"VoiceDigestChannelID"`
+	VoiceDigestChannels            string `json:"VoiceDigestChannels"`
+	VoiceDigestTime                string `json:"VoiceDigestTime"`
+	TranscriptionPermission        string `json:"TranscriptionPermission"`
+	ExtraPostProps                 string `json:"ExtraPostProps"`
+	TranscribeViaURL               bool   `json:"TranscribeViaURL"`
+	SearchIndexWebhookURL          string `json:"SearchIndexWebhookURL"`
+	TranscriptWordTimestamps       bool   `json:"TranscriptWordTimestamps"`
+	TranscriptionTimeoutSeconds    string `json:"TranscriptionTimeoutSeconds"`
+	TranscriptionMaxRetries        string `json:"TranscriptionMaxRetries"`
+	TranscriptionBackoffBaseMs     string `json:"TranscriptionBackoffBaseMs"`
+	TranscriptReplacements         string `json:"TranscriptReplacements"`
+	AutoTranscribeSkippedMessage   string `json:"AutoTranscribeSkippedMessage"`
+	HideChannelNameOnRecordPage    bool   `json:"HideChannelNameOnRecordPage"`
+	AutoTranslateToEnglish         bool   `json:"AutoTranslateToEnglish"`
+	ConvertToMP3ForArchive         bool   `json:"ConvertToMP3ForArchive"`
+	RecordingSizeWarningMB         string `json:"RecordingSizeWarningMB"`
+	RoleMaxDurationSeconds         string `json:"RoleMaxDurationSeconds"`
+	SentScreenMessage              string `json:"SentScreenMessage"`
+	MobileTokenCleanupMinutes      string `json:"MobileTokenCleanupMinutes"`
+	ChannelVoiceMessagesPerHour    string `json:"ChannelVoiceMessagesPerHour"`
+	RecordingGain                  string `json:"RecordingGain"`
+	ProviderCredentials            string `json:"ProviderCredentials"`
 }
 
 func intFromCfg(s string, def int) int {
@@ -103,6 +222,279 @@ func (c *Configuration) getMaxFileSizeBytes() int64 {
 	return int64(mb) << 20
 }
 
+// getMaxConcurrentUploads returns the server-wide upload limit; 0 means unlimited.
+func (c *Configuration) getMaxConcurrentUploads() int {
+	if c == nil {
+		return defaultMaxConcurrentUploads
+	}
+	return intFromCfg(c.MaxConcurrentUploads, defaultMaxConcurrentUploads)
+}
+
+// getSilenceAutoStopSeconds returns how long the recording page waits in silence
+// before stopping; 0 disables auto-stop. Very short windows are raised to a minimum
+// so ordinary speech pauses don't end the recording.
+func (c *Configuration) getSilenceAutoStopSeconds() int {
+	if c == nil {
+		return 0
+	}
+	v := intFromCfg(c.SilenceAutoStopSeconds, 0)
+	if v > 0 && v < minSilenceAutoStopSeconds {
+		v = minSilenceAutoStopSeconds
+	}
+	return v
+}
+
+// getTranscriptionChunkSeconds returns the chunk length used when long WAV audio is
+// split for transcription.
+func (c *Configuration) getTranscriptionChunkSeconds() int {
+	if c == nil {
+		return defaultTranscriptionChunkSeconds
+	}
+	v := intFromCfg(c.TranscriptionChunkSeconds, defaultTranscriptionChunkSeconds)
+	if v < minTranscriptionChunkSeconds {
+		v = minTranscriptionChunkSeconds
+	}
+	return v
+}
+
+// getMaxTranscriptionsPerChannel returns how many auto-transcriptions one channel
+// may run at once; 0 lets a channel use every global slot.
+func (c *Configuration) getMaxTranscriptionsPerChannel() int {
+	if c == nil {
+		return defaultMaxTranscriptionsPerChannel
+	}
+	return intFromCfg(c.MaxTranscriptionsPerChannel, defaultMaxTranscriptionsPerChannel)
+}
+
+// getRecordingSizeWarningBytes returns the recording size past which the mobile
+// page suggests stopping, capped at the maximum file size. 0 disables the warning.
+func (c *Configuration) getRecordingSizeWarningBytes() int64 {
+	if c == nil {
+		return 0
+	}
+	mb := intFromCfg(c.RecordingSizeWarningMB, 0)
+	if mb <= 0 {
+		return 0
+	}
+	return min(int64(mb)<<20, c.getMaxFileSizeBytes())
+}
+
+// getStartCountdownSeconds returns the countdown shown on the mobile page before
+// recording starts; 0 starts immediately.
+func (c *Configuration) getStartCountdownSeconds() int {
+	if c == nil {
+		return 0
+	}
+	v := intFromCfg(c.StartCountdownSeconds, 0)
+	if v > maxStartCountdownSeconds {
+		v = maxStartCountdownSeconds
+	}
+	return v
+}
+
+// getSilenceThreshold returns the input level (0–1) below which audio counts as silence.
+func (c *Configuration) getSilenceThreshold() float64 {
+	if c == nil {
+		return defaultSilenceThreshold
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(c.SilenceThreshold), 64)
+	if err != nil || v <= 0 || v >= 1 {
+		return defaultSilenceThreshold
+	}
+	return v
+}
+
+// parseRecordingGain parses RecordingGain, a multiplier between 1 and
+// maxRecordingGain applied to the microphone signal on the mobile page. Empty
+// means 1, no gain.
+func parseRecordingGain(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 1, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || v < 1 || v > maxRecordingGain {
+		return 1, fmt.Errorf("must be a number between 1 and %g", float64(maxRecordingGain))
+	}
+	return v, nil
+}
+
+// getRecordingGain returns the mobile page's input gain; 1, also for invalid
+// values, leaves the signal as the browser's auto gain control delivers it.
+func (c *Configuration) getRecordingGain() float64 {
+	if c == nil {
+		return 1
+	}
+	v, _ := parseRecordingGain(c.RecordingGain)
+	return v
+}
+
+// getMobileUploadRetries returns how many times the recording page retries a
+// failed upload (network errors and 5xx only).
+func (c *Configuration) getMobileUploadRetries() int {
+	if c == nil {
+		return defaultMobileUploadRetries
+	}
+	v := intFromCfg(c.MobileUploadRetries, defaultMobileUploadRetries)
+	if v > maxMobileUploadRetries {
+		v = maxMobileUploadRetries
+	}
+	return v
+}
+
+// getPreferredRecordingMimes returns the admin's recording MIME order from a
+// comma-separated list. Entries that aren't plausible audio MIME types are dropped
+// with a warning; the page appends its built-in list as a fallback.
+func (c *Configuration) getPreferredRecordingMimes() ([]string, []string) {
+	if c == nil {
+		return nil, nil
+	}
+	var valid, invalid []string
+	for _, raw := range strings.Split(c.PreferredRecordingMimes, ",") {
+		m := strings.ToLower(strings.TrimSpace(raw))
+		if m == "" {
+			continue
+		}
+		if isValidRecordingMime(m) {
+			valid = append(valid, m)
+		} else {
+			invalid = append(invalid, m)
+		}
+	}
+	return valid, invalid
+}
+
+// getVoiceMention returns the mentions prepended to voice message posts, e.g.
+// "@here" or "@support @oncall". The second result is false when the setting holds
+// anything other than @-mentions, in which case no mention is added.
+func (c *Configuration) getVoiceMention() (string, bool) {
+	if c == nil {
+		return "", true
+	}
+	fields := strings.FieldsFunc(c.MentionOnVoiceMessage, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	for _, f := range fields {
+		if !isValidMention(f) {
+			return "", false
+		}
+	}
+	return strings.Join(fields, " "), true
+}
+
+// getExtraPostProps parses ExtraPostProps, a JSON object merged into the Props of
+// every voice message post for other plugins' renderers. Keys starting with
+// "voice_" belong to this plugin and make the whole setting invalid. Empty means
+// no extra props (nil, nil).
+func (c *Configuration) getExtraPostProps() (map[string]any, error) {
+	if c == nil || strings.TrimSpace(c.ExtraPostProps) == "" {
+		return nil, nil
+	}
+	var props map[string]any
+	if err := json.Unmarshal([]byte(c.ExtraPostProps), &props); err != nil {
+		return nil, fmt.Errorf("expected a JSON object: %w", err)
+	}
+	for key := range props {
+		if key == "" || strings.HasPrefix(key, "voice_") {
+			return nil, fmt.Errorf("key %q is reserved", key)
+		}
+	}
+	return props, nil
+}
+
+// applyExtraPostProps adds the configured ExtraPostProps to a voice message post,
+// leaving any prop the post already has untouched.
+func applyExtraPostProps(cfg *Configuration, post *model.Post) {
+	props, err := cfg.getExtraPostProps()
+	if err != nil {
+		return
+	}
+	for key, value := range props {
+		if post.GetProp(key) == nil {
+			post.AddProp(key, value)
+		}
+	}
+}
+
+// isValidMention accepts "@" followed by a Mattermost username or group name.
+func isValidMention(m string) bool {
+	name, ok := strings.CutPrefix(m, "@")
+	if !ok || name == "" || len(name) > model.UserNameMaxLength {
+		return false
+	}
+	for _, r := range strings.ToLower(name) {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// voicePostMessage builds the Message of a voice message post: the configured
+// mention, if any, followed by text. Silent posts get no mention and their text's
+// mentions are muted.
+func voicePostMessage(cfg *Configuration, text string, silent bool) string {
+	if silent {
+		return muteMentions(strings.TrimSpace(text))
+	}
+	mention, _ := cfg.getVoiceMention()
+	return strings.TrimSpace(mention + " " + strings.TrimSpace(text))
+}
+
+// isValidRecordingMime accepts a well-formed "audio/<subtype>" media type with
+// optional parameters, in any case, e.g. `audio/webm; codecs="opus"`.
+func isValidRecordingMime(m string) bool {
+	base, params := parseAudioContentType(m)
+	return params != nil && strings.HasPrefix(base, "audio/") && len(base) > len("audio/")
+}
+
+// getPlaybackRequirement returns off, advisory (page gates Send, server logs) or
+// enforce (server also rejects uploads without confirmed playback).
+func (c *Configuration) getPlaybackRequirement() string {
+	if c == nil {
+		return playbackOff
+	}
+	switch v := strings.TrimSpace(c.RequirePlaybackBeforeSend); v {
+	case playbackAdvisory, playbackEnforce:
+		return v
+	default:
+		return playbackOff
+	}
+}
+
+// getTranscriptInMessage returns whether auto-transcripts also go into the post
+// Message: off, append (after the existing text) or replace (after the mention only).
+func (c *Configuration) getTranscriptInMessage() string {
+	if c == nil {
+		return transcriptInMessageOff
+	}
+	switch v := strings.TrimSpace(c.AutoTranscribePutInMessage); v {
+	case transcriptInMessageAppend, transcriptInMessageReplace:
+		return v
+	default:
+		return transcriptInMessageOff
+	}
+}
+
+// getMaxTranscriptChars caps the transcript copied into a post Message; the
+// post's own length limit always applies on top.
+func (c *Configuration) getMaxTranscriptChars() int {
+	v := intFromCfg(c.MaxTranscriptChars, defaultMaxTranscriptChars)
+	if v <= 0 || v > model.PostMessageMaxRunesV2 {
+		return model.PostMessageMaxRunesV2
+	}
+	return v
+}
+
+// getMaxStoredSegments returns how many timed segments a transcript keeps; 0
+// keeps them all.
+func (c *Configuration) getMaxStoredSegments() int {
+	if c == nil {
+		return 0
+	}
+	return intFromCfg(c.MaxStoredSegments, 0)
+}
+
 func (c *Configuration) getTranscriptionMaxDur() int {
 	if c == nil {
 		return defaultTranscriptionMaxDurSec
@@ -114,8 +506,13 @@ func (c *Configuration) getTranscriptionURL() string {
 	if c == nil {
 		return ""
 	}
-	provider := strings.TrimSpace(c.TranscriptionProvider)
-	switch provider {
+	return c.transcriptionURLFor(c.TranscriptionProvider)
+}
+
+// transcriptionURLFor returns the endpoint for a provider key; "custom" uses the
+// configured TranscriptionServiceURL.
+func (c *Configuration) transcriptionURLFor(provider string) string {
+	switch strings.TrimSpace(provider) {
 	case "deepinfra":
 		return "https://api.deepinfra.com/v1/inference/openai/whisper-large-v3-turbo"
 	case "openai":
@@ -129,7 +526,7 @@ func (c *Configuration) getTranscriptionURL() string {
 
 func (c *Configuration) getTranscriptionModel() string {
 	if c == nil || strings.TrimSpace(c.TranscriptionModel) == "" {
-		return "openai/whisper-large-v3-turbo"
+		return defaultTranscriptionModel
 	}
 	return strings.TrimSpace(c.TranscriptionModel)
 }
@@ -151,6 +548,39 @@ func (p *Plugin) OnConfigurationChange() error {
 	if err := p.API.LoadPluginConfiguration(&cfg); err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	if _, invalid := cfg.getPreferredRecordingMimes(); len(invalid) > 0 {
+		p.API.LogWarn("Ignoring invalid PreferredRecordingMimes entries", "entries", strings.Join(invalid, ","))
+	}
+	if _, invalid := cfg.getFallbackAccept(); len(invalid) > 0 {
+		p.API.LogWarn("Ignoring invalid FallbackAcceptTypes entries", "entries", strings.Join(invalid, ","))
+	}
+	if _, err := parseQuietHours(cfg.AutoTranscribeQuietHours); err != nil {
+		p.API.LogWarn("Ignoring invalid AutoTranscribeQuietHours", "value", cfg.AutoTranscribeQuietHours, "err", err.Error())
+	}
+	if id := strings.TrimSpace(cfg.TranscriptionPermission); id != "" && permissionByID(id) == nil {
+		p.API.LogWarn("TranscriptionPermission is not a built-in permission; only roles granting it in a custom scheme can transcribe", "value", id)
+	}
+	if warning := cfg.transcriptionModelWarning(); warning != "" {
+		p.API.LogWarn("Transcription model may not match the provider", "provider", cfg.TranscriptionProvider, "warning", warning)
+	}
+	if _, err := cfg.getExtraPostProps(); err != nil {
+		p.API.LogWarn("Ignoring invalid ExtraPostProps", "err", err.Error())
+	}
+	if _, invalid := cfg.getProviderCredentials(); len(invalid) > 0 {
+		p.API.LogWarn("Ignoring invalid ProviderCredentials entries", "entries", strings.Join(invalid, ", "))
+	}
+	if _, invalid := cfg.getRoleMaxDurations(); len(invalid) > 0 {
+		p.API.LogWarn("Ignoring invalid RoleMaxDurationSeconds entries", "entries", strings.Join(invalid, " | "))
+	}
+	if _, err := parseRecordingGain(cfg.RecordingGain); err != nil {
+		p.API.LogWarn("Ignoring invalid RecordingGain", "value", cfg.RecordingGain, "err", err.Error())
+	}
+	if _, invalid := cfg.getTranscriptReplacements(); len(invalid) > 0 {
+		p.API.LogWarn("Ignoring invalid TranscriptReplacements rules", "rules", strings.Join(invalid, " | "))
+	}
+	if _, ok := cfg.getVoiceMention(); !ok {
+		p.API.LogWarn("Ignoring invalid MentionOnVoiceMessage; expected @-mentions such as @here or @team-name", "value", cfg.MentionOnVoiceMessage)
+	}
 	p.configLock.Lock()
 	p.configuration = &cfg
 	p.configLock.Unlock()
@@ -165,11 +595,16 @@ func (p *Plugin) OnActivate() error {
 		return err
 	}
 	p.transcribeSem = make(chan struct{}, 2) // max 2 concurrent auto-transcriptions
+	p.searchIndexSem = make(chan struct{}, maxSearchIndexDeliveries)
+	p.mp3Sem = make(chan struct{}, maxMP3Conversions)
+	p.ensureBot()
+	p.startBackgroundJobs()
 	p.API.LogInfo("Voice Message plugin activated", "version", "2.0.0")
 	return nil
 }
 
 func (p *Plugin) OnDeactivate() error {
+	p.stopBackgroundJobs()
 	for _, trig := range []string{commandVoice, commandVM} {
 		_ = p.API.UnregisterCommand("", trig)
 	}
@@ -183,7 +618,7 @@ func (p *Plugin) registerSlashCommands() error {
 			Trigger:          trig,
 			AutoComplete:     true,
 			AutoCompleteDesc: "Record a voice message",
-			AutoCompleteHint: "",
+			AutoCompleteHint: "[@username | tag <name> | dictate | silent | redo | link [dm] | help]",
 			DisplayName:      "Voice Message",
 		}
 		if err := p.API.RegisterCommand(cmd); err != nil {
@@ -204,6 +639,25 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 		return &model.CommandResponse{}, nil
 	}
 
+	if len(split) > 1 {
+		switch strings.ToLower(split[1]) {
+		case "config":
+			return p.executeConfigCommand(args), nil
+		case "usage":
+			return p.executeUsageCommand(args), nil
+		case "link":
+			return p.executeLinkCommand(args, split[2:]), nil
+		case "redo", "tag", "dictate", "silent":
+			// Handled below, once the user may record.
+		case "help":
+			return p.executeHelpCommand(args, trigger, ""), nil
+		default:
+			if !strings.HasPrefix(split[1], "@") {
+				return p.executeHelpCommand(args, trigger, split[1]), nil
+			}
+		}
+	}
+
 	if !p.isUserAllowed(args.UserId) {
 		return &model.CommandResponse{
 			ResponseType: model.CommandResponseTypeEphemeral,
@@ -212,8 +666,85 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 		}, nil
 	}
 
-	rootID := args.RootId
-	tok, err := p.issueMobileToken(args.UserId, args.ChannelId, rootID)
+	channelID, rootID := args.ChannelId, args.RootId
+	var tags []string
+	dictate := false
+	silent := p.getConfig().isSilentChannel(channelID)
+
+	// "/voice @username" records into a direct message with that user.
+	dmWith := ""
+	if len(split) > 1 && strings.HasPrefix(split[1], "@") {
+		channel, reason := p.directChannelFor(args.UserId, split[1])
+		if channel == nil {
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         "⚠️ " + reason,
+				ChannelId:    args.ChannelId,
+			}, nil
+		}
+		channelID, rootID = channel.Id, ""
+		silent = p.getConfig().isSilentChannel(channelID)
+		dmWith = strings.ToLower(strings.TrimPrefix(split[1], "@"))
+	}
+
+	// "/voice redo" issues a fresh link for wherever the user last recorded.
+	if len(split) > 1 && strings.EqualFold(split[1], "redo") {
+		target, resp := p.redoTarget(args)
+		if resp != nil {
+			return resp, nil
+		}
+		channelID, rootID, tags, dictate, silent = target.ChannelID, target.RootID, target.Tags, target.Dictate, target.Silent
+	}
+
+	if !p.canPostIn(args.UserId, channelID) {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "⛔ " + cantPostMessage,
+			ChannelId:    args.ChannelId,
+		}, nil
+	}
+	if ok, reason := p.authorizeRecording(p.getConfig(), args.UserId, channelID, authzActionCommand); !ok {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "⛔ " + reason,
+			ChannelId:    args.ChannelId,
+		}, nil
+	}
+
+	// "/voice tag <name>[,<name>…]" issues a link whose recording is tagged.
+	if len(split) > 1 && strings.EqualFold(split[1], "tag") {
+		var err error
+		tags, err = normalizeTags(split[2:])
+		if err == nil && len(tags) == 0 {
+			err = fmt.Errorf("usage: /%s tag <name>[,<name>…]", trigger)
+		}
+		if err != nil {
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         "⚠️ " + err.Error(),
+				ChannelId:    args.ChannelId,
+			}, nil
+		}
+	}
+
+	// "/voice dictate" posts only the transcript of the recording.
+	if len(split) > 1 && strings.EqualFold(split[1], "dictate") {
+		dictate = true
+	}
+	// "/voice silent" posts without mentions, so nobody is notified by name.
+	if len(split) > 1 && strings.EqualFold(split[1], "silent") {
+		silent = true
+	}
+	if dictate && !p.getConfig().transcriptionAvailable() {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "⚠️ Dictation needs transcription, which is not enabled on this server.",
+			ChannelId:    args.ChannelId,
+		}, nil
+	}
+
+	target := recordingTarget{ChannelID: channelID, RootID: rootID, Tags: tags, Dictate: dictate, Silent: silent}
+	tok, err := p.issueMobileToken(args.UserId, target)
 	if err != nil {
 		p.API.LogError("failed to issue mobile token", "err", err.Error())
 		return &model.CommandResponse{
@@ -223,16 +754,33 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 		}, nil
 	}
 
-	recURL := p.buildMobileRecordURL(tok, args.ChannelId, rootID)
-	maxDur := p.getConfig().getMaxDurationSeconds()
-	maxMin := maxDur / 60
+	p.rememberRecordingTarget(args.UserId, target)
+	p.rememberCurrentLink(args.UserId, tok, time.Now().Unix()+int64(p.getConfig().getMobileTokenTTLSeconds()))
+
+	recURL := p.buildMobileRecordURL(tok, channelID, rootID)
+	limit := "no limit"
+	if maxDur := p.maxDurationFor(p.getConfig(), args.UserId); maxDur > 0 {
+		limit = formatMinutes(maxDur)
+	}
 	ttlMin := p.getConfig().getMobileTokenTTLSeconds() / 60
 
-	text := fmt.Sprintf("🎤 **Voice Message**\n\nOpen the recording page:\n%s\n\n*Recording limit: %d min. Link valid for ~%d min (one-time use).*", recURL, maxMin, ttlMin)
+	text := fmt.Sprintf("🎤 **Voice Message**\n\nOpen the recording page:\n%s\n\n*Recording limit: %s. Link valid for ~%d min (one-time use).*", recURL, limit, ttlMin)
+	if dictate {
+		text = fmt.Sprintf("📝 **Dictation**\n\nOpen the recording page:\n%s\n\n*Your recording is posted as text. Recording limit: %s. Link valid for ~%d min (one-time use).*", recURL, limit, ttlMin)
+	}
+	if len(tags) > 0 {
+		text += "\nTags: `" + strings.Join(tags, "`, `") + "`"
+	}
+	if dmWith != "" {
+		text += "\n✉️ Posting in your direct message with @" + dmWith + "."
+	}
+	if silent {
+		text += "\n🔕 Posting silently: no one is mentioned."
+	}
 
 	ep := &model.Post{
 		UserId:    args.UserId,
-		ChannelId: args.ChannelId,
+		ChannelId: channelID,
 		Message:   text,
 	}
 	sent := p.API.SendEphemeralPost(args.UserId, ep)
@@ -248,6 +796,16 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 	}, nil
 }
 
+// cantPostMessage explains a failed canPostIn check to the user.
+const cantPostMessage = "You can't post in this channel."
+
+// canPostIn reports whether the user may create posts in the channel, e.g. not in a
+// read-only channel. It's checked before recording so a recording isn't wasted on
+// a CreatePost that would fail.
+func (p *Plugin) canPostIn(userID, channelID string) bool {
+	return p.API.HasPermissionToChannel(userID, channelID, model.PermissionCreatePost)
+}
+
 // isUserAllowed checks if the user can use voice messages based on AllowedRoles config.
 func (p *Plugin) isUserAllowed(userID string) bool {
 	cfg := p.getConfig()
@@ -262,18 +820,81 @@ func (p *Plugin) isUserAllowed(userID string) bool {
 	return strings.Contains(roles, "system_admin") || strings.Contains(roles, "team_admin")
 }
 
+// getTranscriptionPermission returns the permission required to transcribe, or nil
+// when TranscriptionPermission is unset. IDs outside Mattermost's built-in list
+// are still checked so custom scheme permissions work; one no role grants denies
+// everyone.
+func (c *Configuration) getTranscriptionPermission() *model.Permission {
+	id := strings.TrimSpace(c.TranscriptionPermission)
+	if id == "" {
+		return nil
+	}
+	if perm := permissionByID(id); perm != nil {
+		return perm
+	}
+	return &model.Permission{Id: id, Scope: model.PermissionScopeChannel}
+}
+
+// permissionByID looks up one of Mattermost's built-in permissions.
+func permissionByID(id string) *model.Permission {
+	for _, perm := range model.AllPermissions {
+		if perm.Id == id {
+			return perm
+		}
+	}
+	return nil
+}
+
+// canTranscribeIn reports whether the user holds TranscriptionPermission in the
+// channel, checked through the channel's permission scheme.
+func (p *Plugin) canTranscribeIn(cfg *Configuration, userID, channelID string) bool {
+	perm := cfg.getTranscriptionPermission()
+	return perm == nil || p.API.HasPermissionToChannel(userID, channelID, perm)
+}
+
+// isSystemAdmin reports whether the user holds the manage_system permission.
+func (p *Plugin) isSystemAdmin(userID string) bool {
+	return userID != "" && p.API.HasPermissionTo(userID, model.PermissionManageSystem)
+}
+
 // ServeHTTP routes API requests.
 func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
 	switch {
+	case strings.HasPrefix(path, "/api/v1/config/effective"):
+		p.handleEffectiveConfig(w, r)
 	case strings.HasPrefix(path, "/api/v1/config"):
 		p.handleConfig(w, r)
+	case strings.HasPrefix(path, "/api/v1/features"):
+		p.handleFeatures(w, r)
+	case strings.HasPrefix(path, "/api/v1/mobile/token/refresh"):
+		p.handleMobileTokenRefresh(w, r)
+	case strings.HasPrefix(path, "/api/v1/mobile/token-status"):
+		p.handleMobileTokenStatus(w, r)
 	case strings.HasPrefix(path, "/api/v1/mobile/upload"):
 		p.handleMobileUpload(w, r)
+	case strings.HasPrefix(path, "/api/v1/upload/progress"):
+		p.handleUploadProgress(w, r)
 	case strings.HasPrefix(path, "/api/v1/upload"):
 		p.handleUpload(w, r)
+	case strings.HasPrefix(path, "/api/v1/transcript"):
+		p.handleTranscript(w, r)
 	case strings.HasPrefix(path, "/api/v1/transcribe"):
 		p.handleTranscribe(w, r)
+	case strings.HasPrefix(path, "/api/v1/audio/signed"):
+		p.handleSignedAudio(w, r)
+	case strings.HasPrefix(path, "/api/v1/waveform"):
+		p.handleWaveform(w, r)
+	case strings.HasPrefix(path, "/api/v1/voice-messages"):
+		p.handleListVoiceMessages(w, r)
+	case strings.HasPrefix(path, "/api/v1/channel/transcription"):
+		p.handleChannelTranscription(w, r)
+	case strings.HasPrefix(path, "/api/v1/preferences"):
+		p.handlePreferences(w, r)
+	case strings.HasPrefix(path, "/api/v1/metrics"):
+		p.handleMetrics(w, r)
+	case strings.HasPrefix(path, "/api/v1/admin/"):
+		p.handleAdmin(w, r)
 	case strings.HasPrefix(path, "/mobile/record"):
 		p.handleMobileRecord(w, r)
 	default:
@@ -295,24 +916,76 @@ func (p *Plugin) handleConfig(w http.ResponseWriter, r *http.Request) {
 	cfg := p.getConfig()
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]any{
-		"maxDurationSeconds":      cfg.getMaxDurationSeconds(),
-		"enableTranscription":     cfg.EnableTranscription,
-		"autoTranscribe":          cfg.AutoTranscribe,
+		"maxDurationSeconds":       p.maxDurationFor(cfg, userID),
+		"enableTranscription":      cfg.EnableTranscription,
+		"transcriptionReady":       cfg.transcriptionAvailable(),
+		"autoTranscribe":           cfg.AutoTranscribe,
 		"transcriptionMaxDuration": cfg.getTranscriptionMaxDur(),
 	})
 }
 
+// handleFeatures reports which optional server features are active, from the
+// configuration and what the server can run, so clients can render matching UI
+// instead of assuming them. The webapp uses it to decide whether to ask for a
+// missing waveform.
+func (p *Plugin) handleFeatures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	cfg := p.getConfig()
+	transcription := cfg.transcriptionAvailable()
+	ffmpeg := ffmpegAvailable()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]bool{
+		"transcription":                 transcription,
+		"autoTranscribe":                transcription && cfg.AutoTranscribe,
+		"attachmentTranscription":       transcription,
+		"chunkedTranscription":          transcription && cfg.EnableChunkedTranscription,
+		"channelTranscriptionOverrides": transcription,
+		"dictation":                     transcription,
+		"transcriptEdit":                transcription,
+		"translation":                   transcription && cfg.AutoTranslateToEnglish,
+		"mobileRecording":               true,
+		"directMessageRecording":        true,
+		"pauseResume":                   true,
+		"silent":                        true,
+		"loudnessNormalization":         cfg.NormalizeLoudness,
+		"silenceAutoStop":               cfg.getSilenceAutoStopSeconds() > 0,
+		"startCountdown":                cfg.getStartCountdownSeconds() > 0,
+		"playbackGate":                  cfg.getPlaybackRequirement() != playbackOff,
+		"playlists":                     cfg.GroupConsecutiveVoice,
+		"archive":                       strings.TrimSpace(cfg.ArchiveChannelID) != "",
+		"archiveMP3":                    strings.TrimSpace(cfg.ArchiveChannelID) != "" && cfg.ConvertToMP3ForArchive && ffmpeg,
+		"waveform":                      ffmpeg, // peaks for any recording, not just WAV
+		"caption":                       true,
+		"tags":                          true,
+	})
+}
+
 func (p *Plugin) handleUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	// Stages are published once the upload is bound to its user's stream.
+	uploadID := ""
+	succeeded := false
+	defer func() { p.progress.finish(uploadID, succeeded) }()
+
 	userID := r.Header.Get("Mattermost-User-Id")
 	if userID == "" {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	uploadID = p.progress.bind(uploadIDFromRequest(r), userID)
 
 	if !p.isUserAllowed(userID) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
@@ -329,58 +1002,143 @@ func (p *Plugin) handleUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
+	if !p.canPostIn(userID, channelID) {
+		http.Error(w, cantPostMessage, http.StatusForbidden)
+		return
+	}
+
+	cfg := p.getConfig()
+	if ok, reason := p.authorizeRecording(cfg, userID, channelID, authzActionUpload); !ok {
+		http.Error(w, reason, http.StatusForbidden)
+		return
+	}
 
 	rootID := r.URL.Query().Get("root_id")
-	durationStr := r.URL.Query().Get("duration")
-	if durationStr == "" {
-		durationStr = "0"
+	var tags []string
+	if raw := r.URL.Query().Get("tags"); raw != "" {
+		var err error
+		if tags, err = normalizeTags([]string{raw}); err != nil {
+			http.Error(w, "Invalid tags: "+err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
-	if _, err := strconv.ParseFloat(durationStr, 64); err != nil {
-		durationStr = "0"
+	silent := r.URL.Query().Get("silent") == "true" || cfg.isSilentChannel(channelID)
+	clientDuration := parseClientDuration(r.URL.Query().Get("duration"))
+	quality := parseRecordingQuality(r.URL.Query())
+
+	if !p.acquireUploadSlot(cfg) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Too many uploads in progress, try again shortly", http.StatusServiceUnavailable)
+		return
 	}
+	defer p.releaseUploadSlot()
 
-	cfg := p.getConfig()
 	r.Body = http.MaxBytesReader(w, r.Body, cfg.getMaxFileSizeBytes())
 	data, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Recording too large (limit %d MB)", tooLarge.Limit>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil || len(data) == 0 {
 		http.Error(w, "Failed to read audio data", http.StatusBadRequest)
 		return
 	}
+	p.progress.publish(uploadID, stageReceived)
+
+	detected := detectAudioFormat(data)
+	if detected == "" {
+		http.Error(w, unsupportedAudioMessage, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if fixed, ok := fixupWebMDuration(data, clientDuration); ok {
+		data = fixed
+	}
+	duration, ok := p.checkDuration(w, p.maxDurationFor(cfg, userID), data, clientDuration, userID)
+	if !ok {
+		return
+	}
+
+	normalized := false
+	if cfg.NormalizeLoudness {
+		data, normalized = normalizeWAVPeak(data)
+	}
 
-	ct := r.Header.Get("Content-Type")
+	ct := uploadContentType(r.Header.Get("Content-Type"), detected)
 	filename := fmt.Sprintf("voice_%s%s", time.Now().Format("20060102_150405"), extForContentType(ct))
 
-	fileInfo, appErr := p.API.UploadFile(data, channelID, filename)
+	slot, err := p.takeChannelVoiceSlot(cfg, channelID)
+	if err != nil {
+		p.writeChannelVoiceSlotError(w, cfg, channelID, err)
+		return
+	}
+	defer func() {
+		if !succeeded {
+			p.returnChannelVoiceSlot(slot)
+		}
+	}()
+
+	fileInfo, appErr := p.uploadVoiceFile(cfg, data, channelID, filename)
 	if appErr != nil {
-		p.API.LogError("Upload failed", "err", appErr.Error())
-		http.Error(w, "Upload failed", http.StatusInternalServerError)
+		p.metrics.uploadFailures.Add(1)
+		p.writeUploadError(w, appErr)
 		return
 	}
+	p.progress.publish(uploadID, stageUploaded)
 
 	post := &model.Post{
 		UserId:    userID,
 		ChannelId: channelID,
 		RootId:    rootID,
-		Message:   "",
+		Message:   voicePostMessage(cfg, "", silent),
 		FileIds:   []string{fileInfo.Id},
 		Type:      "custom_voice_message",
 		Props: model.StringInterface{
-			"voice_duration":  durationStr,
-			"voice_mime_type": ct,
+			"voice_duration":        formatDuration(duration),
+			"voice_duration_client": formatDuration(clientDuration),
+			"voice_mime_type":       ct,
 		},
 	}
+	if normalized {
+		post.Props["voice_normalized"] = true
+	}
+	if len(tags) > 0 {
+		post.Props["voice_tags"] = tags
+	}
+	if quality != nil {
+		post.Props["voice_quality"] = quality
+	}
+	hasWaveform := p.addUploadWaveform(post, data)
+	if silent {
+		post.Props[voiceSilentProp] = true
+	}
+	applyExtraPostProps(cfg, post)
+	if id := p.playlistIDFor(cfg, channelID, rootID, userID); id != "" {
+		post.Props["voice_playlist_id"] = id
+	}
 
 	created, appErr := p.API.CreatePost(post)
 	if appErr != nil {
-		p.API.LogError("CreatePost failed", "err", appErr.Error())
-		http.Error(w, "Failed to create post", http.StatusInternalServerError)
+		p.API.LogError("CreatePost failed", "err", appErr.Error(), "orphaned_file_id", fileInfo.Id)
+		p.markOrphanedUpload(fileInfo.Id)
+		p.metrics.uploadFailures.Add(1)
+		writeCreatePostError(w, appErr)
 		return
 	}
+	p.metrics.uploads.Add(1)
+	p.progress.publish(uploadID, stagePostCreated)
+	go p.archiveVoiceMessage(cfg, created, data, filename)
+	if !hasWaveform {
+		go p.decodeUploadWaveform(created.Id, data, filename)
+	}
 
-	// Auto-transcribe if configured
-	if cfg.EnableTranscription && cfg.AutoTranscribe {
-		go p.autoTranscribe(created.Id, fileInfo.Id, data, ct)
+	// Auto-transcribe if configured or the author opted in
+	if p.shouldAutoTranscribe(cfg, userID) {
+		p.progress.publish(uploadID, stageTranscribing)
+		go p.autoTranscribe(created.Id, created.ChannelId, fileInfo.Id, data, ct)
 	}
+	succeeded = true
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -390,6 +1148,22 @@ func (p *Plugin) handleUpload(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// acquireUploadSlot reserves one of the server-wide upload slots so that
+// concurrent large uploads can't exhaust memory. It returns false when the
+// configured limit is already reached; callers must releaseUploadSlot on success.
+func (p *Plugin) acquireUploadSlot(cfg *Configuration) bool {
+	limit := int64(cfg.getMaxConcurrentUploads())
+	if n := p.uploadsInFlight.Add(1); limit > 0 && n > limit {
+		p.uploadsInFlight.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (p *Plugin) releaseUploadSlot() {
+	p.uploadsInFlight.Add(-1)
+}
+
 // handleTranscribe transcribes a voice message via the configured Whisper API.
 func (p *Plugin) handleTranscribe(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -421,23 +1195,77 @@ func (p *Plugin) handleTranscribe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Verify the requesting user has access to the channel where the post lives.
+	if _, appErr := p.API.GetChannelMember(post.ChannelId, userID); appErr != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !p.canTranscribeIn(cfg, userID, post.ChannelId) {
+		http.Error(w, "You don't have permission to transcribe in this channel", http.StatusForbidden)
+		return
+	}
+
+	// force=true re-transcribes over a stored transcript (post author or system admin).
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	if force && post.UserId != userID && !p.isSystemAdmin(userID) {
+		http.Error(w, "Only the author or a system admin can force re-transcription", http.StatusForbidden)
+		return
+	}
+	useCache := !force && !cfg.DisableTranscriptCache
+
+	// debug=true (system admins) also returns the provider requests and responses,
+	// so it always calls the provider. It is a dry run: the result isn't stored.
+	var trace *transcriptionTrace
+	if debug, _ := strconv.ParseBool(r.URL.Query().Get("debug")); debug {
+		if !p.isSystemAdmin(userID) {
+			http.Error(w, "Only a system admin can request a debug trace", http.StatusForbidden)
+			return
+		}
+		trace = &transcriptionTrace{}
+		useCache = false
+	}
+
+	// file_id selects an audio attachment on any post instead of a voice message.
+	if fileID := r.URL.Query().Get("file_id"); fileID != "" {
+		p.handleTranscribeAttachment(w, cfg, post, fileID, useCache, trace)
+		return
+	}
+
+	// Once the audio is gone the stored transcript is all there is.
+	if deleted, _ := post.GetProp("voice_audio_deleted").(bool); deleted {
+		t, _ := post.GetProp("voice_transcript").(string)
+		if t == "" {
+			http.Error(w, "The audio of this voice message was deleted", http.StatusGone)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"transcript": t,
+			"formatted":  post.GetProp("voice_transcript_formatted"),
+			"cached":     true,
+		})
+		return
+	}
+
 	if post.Type != "custom_voice_message" || len(post.FileIds) == 0 {
 		http.Error(w, "Not a voice message", http.StatusBadRequest)
 		return
 	}
 
-	// Verify the requesting user has access to the channel where the voice message was posted.
-	if _, appErr := p.API.GetChannelMember(post.ChannelId, userID); appErr != nil {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	// A withheld transcript stays withheld until someone forces a new attempt.
+	if warning, _ := post.GetProp("voice_transcript_language_warning").(string); useCache && warning != "" && cfg.SuppressDisallowedLanguages && post.GetProp("voice_transcript") == nil {
+		http.Error(w, "Transcript withheld: "+warning, http.StatusUnprocessableEntity)
 		return
 	}
 
 	// Check if already transcribed
-	if t, ok := post.Props["voice_transcript"]; ok && t != nil && t != "" {
+	if t, ok := post.Props["voice_transcript"]; useCache && ok && t != nil && t != "" {
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]any{
-			"transcript": t,
-			"cached":     true,
+			"transcript":    t,
+			"formatted":     post.GetProp("voice_transcript_formatted"),
+			"transcript_en": post.GetProp("voice_transcript_en"),
+			"cached":        true,
 		})
 		return
 	}
@@ -451,127 +1279,472 @@ func (p *Plugin) handleTranscribe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get file data
-	fileData, appErr := p.API.GetFile(post.FileIds[0])
-	if appErr != nil {
-		p.API.LogError("GetFile failed", "err", appErr.Error())
-		http.Error(w, "Failed to read audio file", http.StatusInternalServerError)
+	// Concurrent requests for the same post share one provider call. A debug
+	// trace runs on its own, since its result isn't stored.
+	if trace != nil {
+		p.debugTranscription(w, cfg, post, dur, trace)
+		return
+	}
+	flight, leader := p.joinTranscription(postID)
+	if !leader {
+		select {
+		case <-flight.done:
+		case <-r.Context().Done():
+			return
+		}
+		if flight.err != nil {
+			writeTranscriptionFailure(w, cfg, flight.err, nil)
+			return
+		}
+		writeTranscriptionResult(w, cfg, flight.post, flight.result, nil)
 		return
 	}
+	defer p.finishTranscription(postID, flight)
 
 	mimeType := ""
 	if m, ok := post.Props["voice_mime_type"].(string); ok {
 		mimeType = m
 	}
 
-	// Call Whisper API
-	transcript, err := p.callWhisperAPI(fileData, mimeType, cfg.TranscriptionProvider)
+	// Call Whisper API; the file is only read if the provider needs the bytes.
+	target := p.withAudioURL(cfg, p.resolveTranscriptionTarget(cfg, post.ChannelId), post.FileIds[0])
+	result, err := p.transcribe(p.storedAudio(post.FileIds[0], dur), mimeType, target)
+	if err != nil {
+		flight.err = err
+		p.writeTranscriptionError(w, cfg, target.Provider, postID, err, nil)
+		return
+	}
+
+	// Save transcript to post props
+	applyTranscriptProps(cfg, post, result)
+	p.dropTranscribedAudio(cfg, post)
+	if updated, appErr := p.API.UpdatePost(post); appErr != nil {
+		p.API.LogError("UpdatePost failed after transcription", "err", appErr.Error())
+	} else {
+		p.warnIfAudioKept(updated)
+		p.archiveTranscript(post)
+		p.indexTranscript(cfg, post, result.Language)
+	}
+	flight.post, flight.result = post, result
+	writeTranscriptionResult(w, cfg, post, result, nil)
+}
+
+// debugTranscription answers a debug=true request for a voice message: it calls
+// the provider with trace attached and returns the result without storing it, so
+// the stored transcript, its edits and the usage report are left alone.
+func (p *Plugin) debugTranscription(w http.ResponseWriter, cfg *Configuration, post *model.Post, dur float64, trace *transcriptionTrace) {
+	mimeType, _ := post.GetProp("voice_mime_type").(string)
+	target := p.withAudioURL(cfg, p.resolveTranscriptionTarget(cfg, post.ChannelId), post.FileIds[0])
+	target.Trace = trace
+	result, err := p.transcribe(p.storedAudio(post.FileIds[0], dur), mimeType, target)
 	if err != nil {
-		errStr := err.Error()
-		p.API.LogError("Transcription failed", "post_id", postID, "err", errStr)
-
-		userMsg := "Transcription failed."
-		switch {
-		case strings.HasPrefix(errStr, "config:"):
-			userMsg = "Transcription not configured properly."
-		case strings.HasPrefix(errStr, "input:"):
-			userMsg = "Audio file is empty or unreadable."
-		case strings.HasPrefix(errStr, "network:"):
-			userMsg = "Could not reach transcription service."
-		case strings.Contains(errStr, "status 401") || strings.Contains(errStr, "status 403"):
-			userMsg = "Transcription API auth failed."
-		case strings.Contains(errStr, "status 429"):
-			userMsg = "Rate limit exceeded. Try again later."
-		case strings.Contains(errStr, "status 5"):
-			userMsg = "Transcription service error."
-		case strings.HasPrefix(errStr, "parse_error:"):
-			userMsg = "Unexpected response from transcription service."
+		p.writeTranscriptionError(w, cfg, target.Provider, post.Id, err, trace)
+		return
+	}
+	preview := post.Clone()
+	applyTranscriptProps(cfg, preview, result)
+	writeTranscriptionResult(w, cfg, preview, result, trace)
+}
+
+// writeTranscriptionResult answers a transcription request with a fresh result
+// stored on post, or with 422 when its language makes it withheld. A non-nil trace
+// is added as "debug".
+func writeTranscriptionResult(w http.ResponseWriter, cfg *Configuration, post *model.Post, result *transcriptionResult, trace *transcriptionTrace) {
+	withheld := cfg.withholdsTranscript(result.Language)
+	if withheld && trace == nil {
+		http.Error(w, "Transcript withheld: "+cfg.transcriptLanguageWarning(result.Language), http.StatusUnprocessableEntity)
+		return
+	}
+	resp := map[string]any{
+		"transcript":    result.Text,
+		"formatted":     post.GetProp("voice_transcript_formatted"),
+		"transcript_en": post.GetProp("voice_transcript_en"),
+		"cached":        false,
+	}
+	if withheld {
+		resp = map[string]any{"error": "Transcript withheld: " + cfg.transcriptLanguageWarning(result.Language)}
+	}
+	if trace != nil {
+		resp["debug"] = trace.redacted(cfg)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if withheld {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// applyTranscriptProps stores a transcription result on a voice message post. Word
+// or segment timings go in voice_transcript_segments, the detected language code in
+// voice_transcript_lang and an English translation in voice_transcript_en. With
+// FormatTranscript on, a paragraphed copy goes in voice_transcript_formatted next
+// to the raw text. A transcript in a language outside
+// AllowedTranscriptionLanguages is flagged in voice_transcript_language_warning,
+// and with SuppressDisallowedLanguages it is not stored at all.
+func applyTranscriptProps(cfg *Configuration, post *model.Post, result *transcriptionResult) {
+	for _, key := range []string{"voice_transcript_deferred", "voice_transcript_language_warning", "voice_transcript_edited_by", "voice_transcript_edited_at", "voice_transcript_lang"} {
+		post.DelProp(key)
+	}
+	if lang := normalizeLanguage(result.Language); lang != "" {
+		post.AddProp("voice_transcript_lang", lang)
+	}
+	if warning := cfg.transcriptLanguageWarning(result.Language); warning != "" {
+		post.AddProp("voice_transcript_language_warning", warning)
+		if cfg.withholdsTranscript(result.Language) {
+			for _, key := range []string{"voice_transcript", "voice_transcript_formatted", "voice_transcript_confidence", "voice_transcript_segments", "voice_transcript_en"} {
+				post.DelProp(key)
+			}
+			return
+		}
+	}
+	post.AddProp("voice_transcript", result.Text)
+	if result.Confidence != nil {
+		post.AddProp("voice_transcript_confidence", *result.Confidence)
+	}
+	post.DelProp("voice_transcript_segments")
+	if timings := storedTimings(result); len(timings) > 0 {
+		post.AddProp("voice_transcript_segments", timings)
+	}
+	post.DelProp("voice_transcript_en")
+	if result.English != "" {
+		post.AddProp("voice_transcript_en", result.English)
+	}
+	post.DelProp("voice_transcript_formatted")
+	if cfg.FormatTranscript {
+		if formatted := formatTranscript(result); formatted != "" {
+			post.AddProp("voice_transcript_formatted", formatted)
 		}
+	}
+}
 
-		// Return as JSON with detail for debugging.
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		// Sanitize: strip API key if it leaked into error string.
-		safeErr := errStr
-		if apiKey := strings.TrimSpace(cfg.TranscriptionAPIKey); apiKey != "" && len(apiKey) > 8 {
-			safeErr = strings.ReplaceAll(safeErr, apiKey, "***")
+// applyTranscriptToMessage copies a transcript into the post Message according to
+// AutoTranscribePutInMessage, truncated to MaxTranscriptChars. The voice_transcript
+// prop is set either way. Running it twice leaves the Message unchanged.
+func applyTranscriptToMessage(cfg *Configuration, post *model.Post, transcript string) {
+	mode := cfg.getTranscriptInMessage()
+	transcript = strings.TrimSpace(transcript)
+	if mode == transcriptInMessageOff || transcript == "" {
+		return
+	}
+	if r := []rune(transcript); len(r) > cfg.getMaxTranscriptChars() {
+		transcript = string(r[:cfg.getMaxTranscriptChars()]) + "…"
+	}
+	silent := isSilentPost(post)
+	if silent {
+		transcript = muteMentions(transcript)
+	}
+
+	var msg string
+	switch mode {
+	case transcriptInMessageReplace:
+		// A caption stays above the transcript.
+		msg = voicePostMessage(cfg, strings.TrimSpace(voiceCaption(post)+"\n\n"+transcript), silent)
+	case transcriptInMessageAppend:
+		if strings.HasSuffix(post.Message, transcript) {
+			return
 		}
-		_ = json.NewEncoder(w).Encode(map[string]string{
-			"error":  userMsg,
-			"detail": safeErr,
-		})
+		msg = strings.TrimSpace(post.Message + "\n\n" + transcript)
+	}
+	if r := []rune(msg); len(r) > model.PostMessageMaxRunesV2 {
+		msg = string(r[:model.PostMessageMaxRunesV2-1]) + "…"
+	}
+	post.Message = msg
+}
+
+// updatePostFresh applies mutate to the latest copy of a post and saves it. If the
+// post changed while mutate ran (e.g. the author edited it), it starts over from
+// the new copy so the edit isn't overwritten. The plugin API has no conditional
+// update, so a change in the last instant before saving can still be lost.
+func (p *Plugin) updatePostFresh(postID string, mutate func(*model.Post)) (*model.Post, error) {
+	const attempts = 3
+	for i := 0; ; i++ {
+		post, appErr := p.API.GetPost(postID)
+		if appErr != nil {
+			return nil, appErr
+		}
+		readAt := post.UpdateAt
+		mutate(post)
+		if i < attempts-1 {
+			if current, appErr := p.API.GetPost(postID); appErr == nil && current.UpdateAt != readAt {
+				continue
+			}
+		}
+		updated, appErr := p.API.UpdatePost(post)
+		if appErr != nil {
+			return nil, appErr
+		}
+		return updated, nil
+	}
+}
+
+// dropTranscribedAudio removes the file references from a freshly transcribed
+// voice message when DeleteAudioAfterTranscription is on, so the post carries
+// only the transcript. The file stays in the file store, where only an
+// administrator can remove it, so its IDs are logged.
+func (p *Plugin) dropTranscribedAudio(cfg *Configuration, post *model.Post) {
+	if !cfg.DeleteAudioAfterTranscription || len(post.FileIds) == 0 {
+		return
+	}
+	if t, _ := post.GetProp("voice_transcript").(string); strings.TrimSpace(t) == "" {
 		return
 	}
+	p.API.LogInfo("Detaching audio from transcribed voice message", "post_id", post.Id, "file_ids", strings.Join(post.FileIds, ","))
+	post.FileIds = nil
+	post.AddProp("voice_audio_deleted", true)
+}
 
-	// Save transcript to post props
-	post.Props["voice_transcript"] = transcript
-	if _, appErr := p.API.UpdatePost(post); appErr != nil {
-		p.API.LogError("UpdatePost failed after transcription", "err", appErr.Error())
+// warnIfAudioKept reports a detach the server didn't apply: servers that ignore
+// FileIds on update keep the audio on the post.
+func (p *Plugin) warnIfAudioKept(updated *model.Post) {
+	if deleted, _ := updated.GetProp("voice_audio_deleted").(bool); deleted && len(updated.FileIds) > 0 {
+		p.API.LogWarn("The server kept the audio attached after DeleteAudioAfterTranscription detached it", "post_id", updated.Id)
 	}
+}
+
+// writeTranscriptionError logs and records a failed transcription and returns a
+// JSON error with a user-facing message and a sanitized detail string.
+func (p *Plugin) writeTranscriptionError(w http.ResponseWriter, cfg *Configuration, provider, postID string, err error, trace *transcriptionTrace) {
+	errStr := err.Error()
+	p.API.LogError("Transcription failed", "post_id", postID, "err", errStr)
+	p.recordTranscriptionError(cfg, provider, postID, err)
+	writeTranscriptionFailure(w, cfg, err, trace)
+}
 
+// writeTranscriptionFailure answers with a user-facing summary of a transcription
+// error and the redacted detail. A non-nil trace is added as "debug".
+func writeTranscriptionFailure(w http.ResponseWriter, cfg *Configuration, err error, trace *transcriptionTrace) {
+	errStr := err.Error()
+	status := providerStatus(err)
+	userMsg := "Transcription failed."
+	switch {
+	case strings.HasPrefix(errStr, "config:"):
+		userMsg = "Transcription not configured properly."
+	case strings.HasPrefix(errStr, "input:"):
+		userMsg = "Audio file is empty or unreadable."
+	case strings.HasPrefix(errStr, "network:"):
+		userMsg = "Could not reach transcription service."
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		userMsg = "Transcription API auth failed."
+	case status == http.StatusTooManyRequests:
+		userMsg = "Rate limit exceeded. Try again later."
+	case status >= 500:
+		userMsg = "Transcription service error."
+	case strings.HasPrefix(errStr, "parse_error:"):
+		userMsg = "Unexpected response from transcription service."
+	}
+
+	// Return as JSON with detail for debugging.
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]any{
-		"transcript": transcript,
+	w.WriteHeader(http.StatusInternalServerError)
+	// Sanitize: strip API key if it leaked into error string.
+	safeErr := cfg.redactAPIKeys(errStr)
+	resp := map[string]any{
+		"error":  userMsg,
+		"detail": safeErr,
+	}
+	if trace != nil {
+		resp["debug"] = trace.redacted(cfg)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleTranscribeAttachment transcribes an audio file attached to a regular post
+// (e.g. a drag-dropped recording). The same size and duration limits apply as for
+// voice messages, with the duration probed from the WAV, WebM, Ogg or MP4
+// container. Audio whose length can't be read that way (MP3, FLAC, fragmented
+// MP4) is held to the size limit only. Transcripts are cached per file in the
+// voice_file_transcripts prop.
+func (p *Plugin) handleTranscribeAttachment(w http.ResponseWriter, cfg *Configuration, post *model.Post, fileID string, useCache bool, trace *transcriptionTrace) {
+	attached := false
+	for _, id := range post.FileIds {
+		if id == fileID {
+			attached = true
+			break
+		}
+	}
+	if !attached {
+		http.Error(w, "File is not attached to this post", http.StatusBadRequest)
+		return
+	}
+
+	info, appErr := p.API.GetFileInfo(fileID)
+	if appErr != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if base, _ := parseAudioContentType(info.MimeType); !strings.HasPrefix(base, "audio/") {
+		http.Error(w, "Not an audio file", http.StatusBadRequest)
+		return
+	}
+	if info.Size > cfg.getMaxFileSizeBytes() {
+		http.Error(w, "Audio file too large for transcription", http.StatusBadRequest)
+		return
+	}
+
+	transcripts := map[string]any{}
+	if m, ok := post.Props["voice_file_transcripts"].(map[string]any); ok {
+		transcripts = m
+	}
+	if t, ok := transcripts[fileID].(string); useCache && ok && t != "" {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"transcript": t,
+			"cached":     true,
+		})
+		return
+	}
+
+	// With a duration limit the file is read here to probe its length; without
+	// one it is only read if the provider needs the bytes.
+	audio := p.storedAudio(fileID, 0)
+	if maxDur := cfg.getTranscriptionMaxDur(); maxDur > 0 {
+		fileData, err := audio.bytes()
+		if err != nil {
+			p.API.LogError("GetFile failed", "err", err.Error())
+			http.Error(w, "Failed to read audio file", http.StatusInternalServerError)
+			return
+		}
+		if dur, ok := probeAudioDuration(fileData); ok && dur > float64(maxDur) {
+			http.Error(w, fmt.Sprintf("Audio file too long for transcription (%.0fs > %ds limit)", dur, maxDur), http.StatusBadRequest)
+			return
+		}
+	}
+
+	target := p.withAudioURL(cfg, p.resolveTranscriptionTarget(cfg, post.ChannelId), fileID)
+	target.Trace = trace
+	result, err := p.transcribe(audio, info.MimeType, target)
+	if err != nil {
+		p.writeTranscriptionError(w, cfg, target.Provider, post.Id, err, trace)
+		return
+	}
+
+	// A debug trace is a dry run.
+	if trace == nil {
+		transcripts[fileID] = result.Text
+		post.AddProp("voice_file_transcripts", transcripts)
+		if _, appErr := p.API.UpdatePost(post); appErr != nil {
+			p.API.LogError("UpdatePost failed after transcription", "err", appErr.Error())
+		}
+	}
+
+	resp := map[string]any{
+		"transcript": result.Text,
 		"cached":     false,
-	})
+	}
+	if trace != nil {
+		resp["debug"] = trace.redacted(cfg)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
-// autoTranscribe is called in a goroutine after upload if AutoTranscribe is enabled.
+// autoTranscribe is called in a goroutine after upload if AutoTranscribe is enabled
+// or the author opted in.
 // Uses a semaphore to limit concurrent transcriptions and prevent OOM.
-func (p *Plugin) autoTranscribe(postID, fileID string, data []byte, mimeType string) {
+func (p *Plugin) autoTranscribe(postID, channelID, fileID string, data []byte, mimeType string) {
+	// During quiet hours the job waits in KV for the maintenance ticker.
+	if qh := p.getConfig().getQuietHours(); qh != nil && qh.contains(time.Now()) {
+		p.deferTranscription(postID, channelID, fileID, mimeType)
+		return
+	}
+	if !p.runAutoTranscribe(postID, channelID, fileID, data, mimeType) {
+		p.markTranscriptionDeferred(postID)
+	}
+}
+
+// markTranscriptionDeferred flags a voice message whose auto-transcription was
+// skipped for lack of a free slot with voice_transcript_deferred, so clients can
+// show it as pending and offer manual transcription, and sends the author
+// AutoTranscribeSkippedMessage as an ephemeral note when one is set.
+func (p *Plugin) markTranscriptionDeferred(postID string) {
+	post, err := p.updatePostFresh(postID, func(post *model.Post) {
+		post.AddProp("voice_transcript_deferred", true)
+	})
+	if err != nil {
+		p.API.LogWarn("Failed to mark transcription as deferred", "post_id", postID, "err", err.Error())
+		return
+	}
+	message := strings.TrimSpace(p.getConfig().AutoTranscribeSkippedMessage)
+	if message == "" {
+		return
+	}
+	p.API.SendEphemeralPost(post.UserId, &model.Post{
+		UserId:    post.UserId,
+		ChannelId: post.ChannelId,
+		RootId:    post.RootId,
+		Message:   message,
+	})
+}
+
+// runAutoTranscribe transcribes a voice message if a global and a per-channel slot
+// are free. It returns false when it was skipped for lack of capacity.
+func (p *Plugin) runAutoTranscribe(postID, channelID, fileID string, data []byte, mimeType string) bool {
 	// Non-blocking acquire: if too many transcriptions in flight, skip.
 	select {
 	case p.transcribeSem <- struct{}{}:
 		// acquired
 	default:
 		p.API.LogWarn("Auto-transcribe skipped: too many in flight", "post_id", postID)
-		return
+		p.metrics.autoTranscribeSkipped.Add(1)
+		return false
 	}
 	defer func() { <-p.transcribeSem }()
 
+	cfg := p.getConfig()
+	if !p.acquireChannelTranscription(channelID, cfg.getMaxTranscriptionsPerChannel()) {
+		p.API.LogWarn("Auto-transcribe skipped: channel at its transcription limit", "post_id", postID, "channel_id", channelID)
+		p.metrics.autoTranscribeSkipped.Add(1)
+		return false
+	}
+	defer p.releaseChannelTranscription(channelID)
+
 	time.Sleep(500 * time.Millisecond)
 
-	cfg := p.getConfig()
-	if !cfg.EnableTranscription || strings.TrimSpace(cfg.TranscriptionAPIKey) == "" {
-		return
+	if !cfg.transcriptionAvailable() {
+		return true
 	}
 
-	transcript, err := p.callWhisperAPI(data, mimeType, cfg.TranscriptionProvider)
+	target := p.withAudioURL(cfg, p.resolveTranscriptionTarget(cfg, channelID), fileID)
+	result, err := p.transcribe(heldAudio(data), mimeType, target)
 	// Release audio data from this goroutine's scope immediately.
 	data = nil
 
 	if err != nil {
 		p.API.LogError("Auto-transcription failed", "post_id", postID, "err", err.Error())
-		return
+		p.recordTranscriptionError(cfg, target.Provider, postID, err)
+		return true
 	}
 
-	post, appErr := p.API.GetPost(postID)
-	if appErr != nil {
-		return
-	}
-	post.Props["voice_transcript"] = transcript
-	if _, appErr := p.API.UpdatePost(post); appErr != nil {
-		p.API.LogError("UpdatePost failed after auto-transcription", "err", appErr.Error())
+	post, err := p.updatePostFresh(postID, func(post *model.Post) {
+		applyTranscriptProps(cfg, post, result)
+		applyTranscriptToMessage(cfg, post, displayTranscript(post))
+		p.dropTranscribedAudio(cfg, post)
+	})
+	if err != nil {
+		p.API.LogError("UpdatePost failed after auto-transcription", "err", err.Error())
+		return true
 	}
+	p.warnIfAudioKept(post)
+	p.archiveTranscript(post)
+	p.indexTranscript(cfg, post, result.Language)
+	return true
 }
 
-// callWhisperAPI sends audio data to a Whisper-compatible endpoint and returns the transcript text.
-// Retries up to 2 times on transient (5xx / timeout) errors.
-func (p *Plugin) callWhisperAPI(audioData []byte, mimeType string, provider string) (string, error) {
-	cfg := p.getConfig()
-	apiURL := cfg.getTranscriptionURL()
-	apiKey := strings.TrimSpace(cfg.TranscriptionAPIKey)
-	modelName := cfg.getTranscriptionModel()
-	language := strings.TrimSpace(cfg.TranscriptionLanguage)
-
-	if apiURL == "" {
-		return "", fmt.Errorf("config: transcription URL not configured")
+// callWhisperAPI sends audio data to the resolved Whisper-compatible endpoint and
+// returns the parsed result. Transient (5xx / timeout) errors are retried up to
+// TranscriptionMaxRetries times with exponential backoff. With several API keys,
+// requests rotate between them and a 429 on one key fails over to the next within
+// the same attempt. All attempts share a deadline of two request timeouts, so
+// retries can't hold an auto-transcription slot indefinitely.
+func (p *Plugin) callWhisperAPI(audioData []byte, mimeType string, target *transcriptionTarget) (*transcriptionResult, error) {
+	if target.URL == "" {
+		return nil, fmt.Errorf("config: transcription URL not configured")
 	}
-	if apiKey == "" {
-		return "", fmt.Errorf("config: transcription API key not configured")
+	if len(target.APIKeys) == 0 {
+		return nil, fmt.Errorf("config: transcription API key not configured")
 	}
-	if len(audioData) == 0 {
-		return "", fmt.Errorf("input: audio data is empty")
+	if len(audioData) == 0 && target.AudioURL == "" {
+		return nil, fmt.Errorf("input: audio data is empty")
 	}
 
 	ext := extForContentType(mimeType)
@@ -580,7 +1753,7 @@ func (p *Plugin) callWhisperAPI(audioData []byte, mimeType string, provider stri
 	}
 	filename := "voice" + ext
 
-	isDeepInfra := strings.TrimSpace(provider) == "deepinfra"
+	isDeepInfra := target.Provider == "deepinfra"
 
 	// DeepInfra inference endpoint uses "audio" field; OpenAI-compatible endpoints use "file".
 	fieldName := "file"
@@ -589,93 +1762,170 @@ func (p *Plugin) callWhisperAPI(audioData []byte, mimeType string, provider stri
 	}
 
 	p.API.LogDebug("Transcription request",
-		"provider", provider,
-		"url", apiURL,
+		"provider", target.Provider,
+		"url", target.URL,
+		"model", target.Model,
 		"field", fieldName,
 		"filename", filename,
 		"audio_bytes", len(audioData),
 		"mime", mimeType,
 	)
 
+	cfg := p.getConfig()
+	seconds := target.AudioSeconds
+	if len(audioData) > 0 {
+		seconds = transcriptionAudioSeconds(audioData)
+	}
+	timeout := cfg.getTranscriptionTimeout(seconds)
+	deadline := time.Now().Add(2 * timeout)
+
 	var lastErr error
-	maxAttempts := 2
+	maxAttempts := 1 + cfg.getTranscriptionMaxRetries()
 
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
 		if attempt > 1 {
-			delay := time.Duration(attempt) * time.Second
+			delay := cfg.transcriptionBackoff(attempt - 1)
+			if time.Until(deadline) < delay+minTranscriptionTimeout/2 {
+				p.API.LogWarn("Transcription retry skipped: out of time", "attempt", attempt, "timeout", timeout.String())
+				break
+			}
 			p.API.LogInfo("Transcription retry", "attempt", attempt, "delay", delay.String())
 			time.Sleep(delay)
 		}
 
-		transcript, retryable, err := p.doWhisperRequest(apiURL, apiKey, fieldName, filename, modelName, language, audioData, isDeepInfra)
-		if err == nil {
-			return transcript, nil
+		keys := p.apiKeys.order(target.APIKeys)
+		retryable := false
+		for i, key := range keys {
+			left := time.Until(deadline)
+			if left <= 0 {
+				break
+			}
+			result, keyRetryable, err := p.doWhisperRequest(target, key, fieldName, filename, audioPartType(mimeType, filename), audioData, min(timeout, left))
+			if err == nil {
+				return result, nil
+			}
+			lastErr, retryable = err, keyRetryable
+			p.API.LogWarn("Transcription attempt failed",
+				"attempt", attempt,
+				"key", i+1,
+				"of", len(keys),
+				"retryable", retryable,
+				"err", err.Error(),
+			)
+			if providerStatus(err) != http.StatusTooManyRequests {
+				break
+			}
+			p.apiKeys.rateLimited(key)
 		}
-		lastErr = err
-		p.API.LogWarn("Transcription attempt failed",
-			"attempt", attempt,
-			"retryable", retryable,
-			"err", err.Error(),
-		)
 		if !retryable {
 			break
 		}
 	}
 
-	return "", lastErr
+	return nil, lastErr
 }
 
-// doWhisperRequest performs a single Whisper API call.
-// Returns (transcript, retryable, error).
-func (p *Plugin) doWhisperRequest(apiURL, apiKey, fieldName, filename, modelName, language string, audioData []byte, isDeepInfra bool) (string, bool, error) {
+// doWhisperRequest performs a single Whisper API call and parses the response
+// with the provider's responseParser. Returns (result, retryable, error).
+func (p *Plugin) doWhisperRequest(target *transcriptionTarget, apiKey, fieldName, filename, partType string, audioData []byte, timeout time.Duration) (*transcriptionResult, bool, error) {
+	isDeepInfra := target.Provider == "deepinfra"
+
 	var buf bytes.Buffer
 	writer := multipart.NewWriter(&buf)
-
-	// CreateFormFile always sets Content-Type: application/octet-stream.
-	// DeepInfra needs the real audio MIME type, so we create the part manually.
-	partHeader := textproto.MIMEHeader{}
-	partHeader.Set("Content-Disposition",
-		fmt.Sprintf(`form-data; name="%s"; filename="%s"`, fieldName, filename))
-	partHeader.Set("Content-Type", mimeForFilename(filename))
-
-	part, err := writer.CreatePart(partHeader)
-	if err != nil {
-		return "", false, fmt.Errorf("create form file: %w", err)
-	}
-	if _, err := part.Write(audioData); err != nil {
-		return "", false, fmt.Errorf("write audio data: %w", err)
+	// form mirrors the text fields for a debug trace.
+	var form [][2]string
+	writeField := func(name, value string) {
+		_ = writer.WriteField(name, value)
+		form = append(form, [2]string{name, value})
+	}
+
+	if target.AudioURL != "" {
+		// The provider fetches the audio itself from the signed URL.
+		_ = writer.WriteField(fieldName, target.AudioURL)
+		form = append(form, [2]string{fieldName, "(signed audio URL)"})
+	} else {
+		// CreateFormFile always sets Content-Type: application/octet-stream.
+		// DeepInfra needs the real audio MIME type, so we create the part manually.
+		partHeader := textproto.MIMEHeader{}
+		partHeader.Set("Content-Disposition",
+			fmt.Sprintf(`form-data; name="%s"; filename="%s"`, fieldName, filename))
+		partHeader.Set("Content-Type", partType)
+
+		part, err := writer.CreatePart(partHeader)
+		if err != nil {
+			return nil, false, fmt.Errorf("create form file: %w", err)
+		}
+		if _, err := part.Write(audioData); err != nil {
+			return nil, false, fmt.Errorf("write audio data: %w", err)
+		}
+		form = append(form, [2]string{fieldName, fmt.Sprintf("(%s, %s, %d bytes)", filename, partType, len(audioData))})
 	}
 
 	// DeepInfra inference endpoint has model in URL; OpenAI-compatible endpoints need these fields.
 	if !isDeepInfra {
-		_ = writer.WriteField("model", modelName)
-		_ = writer.WriteField("response_format", "json")
+		writeField("model", target.Model)
+		writeField("response_format", target.responseFormat())
+		if target.WordTimestamps {
+			// Asking for words alone would drop the segments.
+			writeField("timestamp_granularities[]", "word")
+			writeField("timestamp_granularities[]", "segment")
+		}
+	}
+	if target.Language != "" {
+		writeField("language", target.Language)
 	}
-	if language != "" {
-		_ = writer.WriteField("language", language)
+	if target.Translate && target.Provider != "openai" {
+		// OpenAI translates on its own endpoint; the rest take Whisper's task.
+		writeField("task", "translate")
+	}
+	if target.Prompt != "" {
+		// DeepInfra's native endpoint calls Whisper's prompt "initial_prompt".
+		promptField := "prompt"
+		if isDeepInfra {
+			promptField = "initial_prompt"
+		}
+		writeField(promptField, target.Prompt)
 	}
 	writer.Close()
 
-	req, err := http.NewRequest(http.MethodPost, apiURL, &buf)
+	req, err := http.NewRequest(http.MethodPost, target.URL, &buf)
 	if err != nil {
-		return "", false, fmt.Errorf("create request: %w", err)
+		return nil, false, fmt.Errorf("create request: %w", err)
 	}
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	req.Header.Set("Authorization", "Bearer "+apiKey)
 
-	client := &http.Client{Timeout: 30 * time.Second}
+	call := tracedCall{
+		URL:       target.URL,
+		Provider:  target.Provider,
+		Field:     fieldName,
+		Headers:   map[string]string{"Content-Type": req.Header.Get("Content-Type"), "Authorization": "Bearer ***"},
+		Form:      form,
+		BodyBytes: int(req.ContentLength),
+	}
+	started := time.Now()
+
+	client := &http.Client{Timeout: timeout}
 	resp, err := client.Do(req)
 	if err != nil {
+		call.Error = err.Error()
+		target.Trace.record(call, started)
 		// EOF means the server closed connection — likely down, don't retry.
 		errMsg := err.Error()
 		retryable := !strings.Contains(errMsg, "EOF")
-		return "", retryable, fmt.Errorf("network: %w", err)
+		return nil, retryable, fmt.Errorf("network: %w", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := readResponseBody(resp)
+	call.Status = resp.StatusCode
+	call.Response = truncate(string(body), maxTracedResponseBytes)
+	if err != nil {
+		call.Error = err.Error()
+	}
+	target.Trace.record(call, started)
 	if err != nil {
-		return "", true, fmt.Errorf("read response body: %w", err)
+		return nil, true, fmt.Errorf("read response body: %w", err)
 	}
 
 	p.API.LogDebug("Transcription API response",
@@ -686,42 +1936,44 @@ func (p *Plugin) doWhisperRequest(apiURL, apiKey, fieldName, filename, modelName
 
 	if resp.StatusCode != http.StatusOK {
 		retryable := resp.StatusCode >= 500 || resp.StatusCode == 429
-		return "", retryable, fmt.Errorf("api_error: status %d, body: %s", resp.StatusCode, truncate(string(body), 300))
+		return nil, retryable, &providerStatusError{status: resp.StatusCode, body: truncate(string(body), 300)}
 	}
 
-	// Parse response — try "text" field first (standard), then look for segments.
-	var raw map[string]json.RawMessage
-	if err := json.Unmarshal(body, &raw); err != nil {
-		return "", false, fmt.Errorf("parse_error: invalid JSON: %w (body: %s)", err, truncate(string(body), 200))
+	result, err := parserForProvider(target.Provider).parse(body)
+	if err != nil {
+		return nil, false, err
 	}
+	return result, false, nil
+}
 
-	// Try top-level "text" field.
-	if textRaw, ok := raw["text"]; ok {
-		var text string
-		if err := json.Unmarshal(textRaw, &text); err == nil && strings.TrimSpace(text) != "" {
-			return strings.TrimSpace(text), false, nil
-		}
+// readResponseBody reads a response body, decoding gzip or deflate content that
+// some gateways send even though the transport didn't ask for it.
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
 	}
 
-	// Fallback: build text from "segments" array (DeepInfra sometimes returns text="" with segments filled).
-	if segRaw, ok := raw["segments"]; ok {
-		var segments []struct {
-			Text string `json:"text"`
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
 		}
-		if err := json.Unmarshal(segRaw, &segments); err == nil && len(segments) > 0 {
-			var parts []string
-			for _, seg := range segments {
-				if t := strings.TrimSpace(seg.Text); t != "" {
-					parts = append(parts, t)
-				}
-			}
-			if len(parts) > 0 {
-				return strings.Join(parts, " "), false, nil
-			}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	case "deflate":
+		// "deflate" is meant to be zlib-wrapped, but raw DEFLATE is common in practice.
+		if zr, err := zlib.NewReader(bytes.NewReader(raw)); err == nil {
+			defer zr.Close()
+			return io.ReadAll(zr)
 		}
+		fr := flate.NewReader(bytes.NewReader(raw))
+		defer fr.Close()
+		return io.ReadAll(fr)
+	default:
+		return raw, nil
 	}
-
-	return "", false, fmt.Errorf("parse_error: no transcript text found in response (body: %s)", truncate(string(body), 300))
 }
 
 func truncate(s string, max int) string {
@@ -754,15 +2006,26 @@ func (p *Plugin) handleMobileRecord(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
+	// Checked again here: the channel may have become read-only since /voice.
+	if !p.canPostIn(mt.UserID, mt.ChannelID) {
+		http.Error(w, cantPostMessage, http.StatusForbidden)
+		return
+	}
 
 	cfg := p.getConfig()
-	maxSeconds := cfg.getMaxDurationSeconds()
-	basePath := p.getBasePathFromSiteURL()
-	uploadURL := fmt.Sprintf("%s/plugins/%s/api/v1/mobile/upload?token=%s", basePath, pluginID, url.QueryEscape(token))
+	maxSeconds := mt.maxDuration(cfg)
+	uploadURL, tokenStatusURL, refreshURL, progressURL := p.mobileTokenURLs(token)
 
-	channelDisplay := mt.ChannelID
-	if ch, appErr := p.API.GetChannel(mt.ChannelID); appErr == nil && ch != nil && ch.DisplayName != "" {
-		channelDisplay = ch.DisplayName
+	channelDisplay, teamID := mt.ChannelID, ""
+	if ch, appErr := p.API.GetChannel(mt.ChannelID); appErr == nil && ch != nil {
+		if ch.DisplayName != "" {
+			channelDisplay = ch.DisplayName
+		}
+		teamID = ch.TeamId
+	}
+	if cfg.HideChannelNameOnRecordPage {
+		// The page may be seen over the user's shoulder; don't name the channel.
+		channelDisplay = "Selected channel"
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -771,7 +2034,33 @@ func (p *Plugin) handleMobileRecord(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Referrer-Policy", "no-referrer")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'unsafe-inline'; script-src 'unsafe-inline'; connect-src 'self'; img-src 'self' data:; media-src 'self' blob: data:;")
-	_, _ = w.Write([]byte(renderMobileRecordHTML(channelDisplay, mt.ChannelID, mt.RootID, uploadURL, maxSeconds)))
+	opts := recordPageOptions{
+		SilenceAutoStopSeconds: cfg.getSilenceAutoStopSeconds(),
+		SilenceThreshold:       cfg.getSilenceThreshold(),
+		RecordingGain:          cfg.getRecordingGain(),
+		CaptionMaxChars:        maxCaptionRunes,
+		RequirePlayback:        cfg.getPlaybackRequirement() != playbackOff,
+		UploadRetries:          cfg.getMobileUploadRetries(),
+		StartCountdownSeconds:  cfg.getStartCountdownSeconds(),
+		RecordingBeep:          cfg.RecordingBeep,
+		PrewarmMic:             cfg.PrewarmMic,
+		FallbackEnabled:        !cfg.DisableRecorderFallback,
+		TokenStatusURL:         tokenStatusURL,
+		RefreshURL:             refreshURL,
+		LinkExpiresInSeconds:   max(0, mt.ExpiresAt-time.Now().Unix()),
+		SizeWarningBytes:       cfg.getRecordingSizeWarningBytes(),
+		Dictate:                mt.Dictate,
+		ProgressURL:            progressURL,
+	}
+	opts.PreferredMimes, _ = cfg.getPreferredRecordingMimes()
+	opts.Notice = cfg.RecordingPageNotice
+	opts.SentMessage = p.sentScreenMessage(cfg, mt)
+	opts.Theme = p.recordPageTheme(r, mt.UserID, teamID)
+	opts.FallbackAccept = defaultFallbackAccept
+	if accept, _ := cfg.getFallbackAccept(); len(accept) > 0 {
+		opts.FallbackAccept = strings.Join(accept, ",")
+	}
+	_, _ = w.Write([]byte(renderMobileRecordHTML(channelDisplay, mt.ChannelID, mt.RootID, uploadURL, maxSeconds, opts)))
 }
 
 func (p *Plugin) handleMobileUpload(w http.ResponseWriter, r *http.Request) {
@@ -780,6 +2069,11 @@ func (p *Plugin) handleMobileUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Stages are published once the upload is bound to its user's stream.
+	uploadID := ""
+	succeeded := false
+	defer func() { p.progress.finish(uploadID, succeeded) }()
+
 	token := strings.TrimSpace(r.URL.Query().Get("token"))
 	if token == "" {
 		http.Error(w, "missing token", http.StatusBadRequest)
@@ -792,86 +2086,258 @@ func (p *Plugin) handleMobileUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cfg := p.getConfig()
 	mmUser := r.Header.Get("Mattermost-User-Id")
 	if mmUser != "" && mmUser != mt.UserID {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
+	uploadID = p.progress.bind(uploadIDFromRequest(r), mt.UserID)
+	if mmUser == "" && cfg.RequireSessionForMobileUpload {
+		http.Error(w, "Mattermost session required", http.StatusUnauthorized)
+		return
+	}
 	if mmUser == "" {
 		origin := strings.TrimSpace(r.Header.Get("Origin"))
-		if origin != "" && !p.isAllowedOrigin(origin) {
+		if origin != "" && !p.isAllowedOrigin(origin, r) {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
 	}
 
+	// The channel may have been archived or deleted since the link was issued; the
+	// token can never be used again, so drop it.
+	if ch, appErr := p.API.GetChannel(mt.ChannelID); appErr != nil || ch.DeleteAt != 0 {
+		if appErr != nil && appErr.StatusCode != http.StatusNotFound {
+			p.API.LogError("GetChannel failed", "channel_id", mt.ChannelID, "err", appErr.Error())
+			http.Error(w, "Failed to look up channel", http.StatusInternalServerError)
+			return
+		}
+		_ = p.API.KVDelete(kvMobileTokenPrefix + token)
+		http.Error(w, "This channel is no longer available", http.StatusGone)
+		return
+	}
+
 	if _, appErr := p.API.GetChannelMember(mt.ChannelID, mt.UserID); appErr != nil {
 		http.Error(w, "not a channel member", http.StatusForbidden)
 		return
 	}
+	if !p.canPostIn(mt.UserID, mt.ChannelID) {
+		http.Error(w, cantPostMessage, http.StatusForbidden)
+		return
+	}
+	if ok, reason := p.authorizeRecording(cfg, mt.UserID, mt.ChannelID, authzActionUpload); !ok {
+		http.Error(w, reason, http.StatusForbidden)
+		return
+	}
+
+	if mode := cfg.getPlaybackRequirement(); mode != playbackOff && r.Header.Get(headerPlaybackConfirmed) != "1" {
+		p.API.LogWarn("Voice upload without confirmed playback", "user_id", mt.UserID, "mode", mode)
+		if mode == playbackEnforce {
+			http.Error(w, "Listen to your recording before sending", http.StatusPreconditionRequired)
+			return
+		}
+	}
+
+	// Only one request may consume the token; a failed one gives it back.
+	claimed, err := p.claimMobileToken(token)
+	if err != nil {
+		p.API.LogError("Failed to claim recording token", "user_id", mt.UserID, "err", err.Error())
+		http.Error(w, "Failed to start upload, try again", http.StatusInternalServerError)
+		return
+	}
+	if !claimed {
+		http.Error(w, tokenInUseMessage, http.StatusConflict)
+		return
+	}
+	defer func() {
+		if !succeeded {
+			p.releaseMobileToken(token)
+		}
+	}()
+	// The token was read before the claim, so an upload that finished in between
+	// has already consumed it and released its claim; only the token being gone
+	// tells.
+	if mt, err = p.getMobileToken(token); err != nil {
+		http.Error(w, tokenUsedMessage, http.StatusGone)
+		return
+	}
+
+	if !p.acquireUploadSlot(cfg) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Too many uploads in progress, try again shortly", http.StatusServiceUnavailable)
+		return
+	}
+	defer p.releaseUploadSlot()
 
-	cfg := p.getConfig()
 	r.Body = http.MaxBytesReader(w, r.Body, cfg.getMaxFileSizeBytes())
 	defer r.Body.Close()
 
 	data, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Recording too large (limit %d MB)", tooLarge.Limit>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil || len(data) == 0 {
 		http.Error(w, "Failed to read audio data", http.StatusBadRequest)
 		return
 	}
+	p.progress.publish(uploadID, stageReceived)
+
+	detected := detectAudioFormat(data)
+	if detected == "" {
+		http.Error(w, unsupportedAudioMessage, http.StatusUnsupportedMediaType)
+		return
+	}
+	// Files picked through the system-recorder fallback must match FallbackAcceptTypes.
+	if status, msg := cfg.mobileUploadFormatProblem(r.URL.Query().Get("source"), r.URL.Query().Get("filename"), detected, data); status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	if reason, empty := audioLooksEmpty(data); empty {
+		p.API.LogWarn("Rejected empty recording",
+			"user_id", mt.UserID,
+			"client", clientFamily(r.UserAgent()),
+			"content_type", r.Header.Get("Content-Type"),
+			"bytes", len(data),
+			"reason", reason,
+		)
+		http.Error(w, "Recording appears empty — please try again", http.StatusUnprocessableEntity)
+		return
+	}
+
+	clientDuration := parseClientDuration(r.URL.Query().Get("duration"))
+	if fixed, ok := fixupWebMDuration(data, clientDuration); ok {
+		data = fixed
+	}
+	duration, ok := p.checkDuration(w, mt.maxDuration(cfg), data, clientDuration, mt.UserID)
+	if !ok {
+		return
+	}
+
+	normalized := false
+	if cfg.NormalizeLoudness {
+		data, normalized = normalizeWAVPeak(data)
+	}
+
+	ct := uploadContentType(r.Header.Get("Content-Type"), detected)
+	filename := fmt.Sprintf("voice_%s%s", time.Now().Format("20060102_150405"), extForContentType(ct))
+	// A caption is only posted with a voice message; dictations are text already.
+	caption, err := requestCaption(r)
+	if err != nil {
+		http.Error(w, "Invalid caption", http.StatusBadRequest)
+		return
+	}
+
+	slot, err := p.takeChannelVoiceSlot(cfg, mt.ChannelID)
+	if err != nil {
+		p.writeChannelVoiceSlotError(w, cfg, mt.ChannelID, err)
+		return
+	}
+	defer func() {
+		if !succeeded {
+			p.returnChannelVoiceSlot(slot)
+		}
+	}()
 
-	ct := r.Header.Get("Content-Type")
-	filename := fmt.Sprintf("voice_%s%s", time.Now().Format("20060102_150405"), extForContentType(ct))
+	if mt.Dictate {
+		p.progress.publish(uploadID, stageTranscribing)
+		result, err := p.transcribeDictation(cfg, mt.ChannelID, data, ct, duration)
+		if err == nil {
+			created, fileID, ok := p.createDictationPost(w, cfg, mt, result, data, filename)
+			if ok {
+				p.progress.publish(uploadID, stagePostCreated)
+				p.completeMobileUpload(w, r, token, mt, created, fileID)
+				succeeded = true
+			}
+			return
+		}
+		p.API.LogWarn("Dictation failed; posting the recording as a voice message", "user_id", mt.UserID, "err", err.Error())
+	}
 
-	fileInfo, appErr := p.API.UploadFile(data, mt.ChannelID, filename)
+	fileInfo, appErr := p.uploadVoiceFile(cfg, data, mt.ChannelID, filename)
 	if appErr != nil {
-		p.API.LogError("Upload failed", "err", appErr.Error())
-		http.Error(w, "Upload failed", http.StatusInternalServerError)
+		p.metrics.uploadFailures.Add(1)
+		p.writeUploadError(w, appErr)
 		return
 	}
+	p.progress.publish(uploadID, stageUploaded)
 
 	post := &model.Post{
 		UserId:    mt.UserID,
 		ChannelId: mt.ChannelID,
 		RootId:    mt.RootID,
-		Message:   "",
+		Message:   voicePostMessage(cfg, captionMessage(caption), mt.Silent),
 		FileIds:   []string{fileInfo.Id},
 		Type:      "custom_voice_message",
 		Props: model.StringInterface{
-			"voice_duration":  "0",
-			"voice_mime_type": ct,
+			"voice_duration":        formatDuration(duration),
+			"voice_duration_client": formatDuration(clientDuration),
+			"voice_mime_type":       ct,
 		},
 	}
+	if normalized {
+		post.Props["voice_normalized"] = true
+	}
+	if len(mt.Tags) > 0 {
+		post.Props["voice_tags"] = mt.Tags
+	}
+	if quality := parseRecordingQuality(r.URL.Query()); quality != nil {
+		post.Props["voice_quality"] = quality
+	}
+	hasWaveform := p.addUploadWaveform(post, data)
+	if mt.Silent {
+		post.Props[voiceSilentProp] = true
+	}
+	if caption != "" {
+		post.Props[voiceCaptionProp] = caption
+	}
+	applyExtraPostProps(cfg, post)
+	if id := p.playlistIDFor(cfg, mt.ChannelID, mt.RootID, mt.UserID); id != "" {
+		post.Props["voice_playlist_id"] = id
+	}
 
 	created, appErr := p.API.CreatePost(post)
 	if appErr != nil {
-		p.API.LogError("CreatePost failed", "err", appErr.Error())
-		http.Error(w, "Failed to create post", http.StatusInternalServerError)
+		p.API.LogError("CreatePost failed", "err", appErr.Error(), "orphaned_file_id", fileInfo.Id)
+		p.markOrphanedUpload(fileInfo.Id)
+		p.metrics.uploadFailures.Add(1)
+		writeCreatePostError(w, appErr)
 		return
 	}
+	p.metrics.uploads.Add(1)
+	p.progress.publish(uploadID, stagePostCreated)
+	go p.archiveVoiceMessage(cfg, created, data, filename)
+	if !hasWaveform {
+		go p.decodeUploadWaveform(created.Id, data, filename)
+	}
+
+	// Auto-transcribe for mobile uploads too
+	if p.shouldAutoTranscribe(cfg, mt.UserID) {
+		p.progress.publish(uploadID, stageTranscribing)
+		go p.autoTranscribe(created.Id, created.ChannelId, fileInfo.Id, data, ct)
+	}
+
+	p.completeMobileUpload(w, r, token, mt, created, fileInfo.Id)
+	succeeded = true
+}
 
+// completeMobileUpload consumes the token, turns the recorder link into a success
+// note and answers the page.
+func (p *Plugin) completeMobileUpload(w http.ResponseWriter, r *http.Request, token string, mt *mobileToken, created *model.Post, fileID string) {
 	_ = p.API.KVDelete(kvMobileTokenPrefix + token)
+	p.releaseMobileToken(token)
 
 	if mt.EphemeralPostID != "" {
-		successMsg := "✅ Voice message sent."
-		if pl := p.buildPostPermalink(created.Id); pl != "" {
-			successMsg = successMsg + "\n" + pl
+		cfg := p.getConfig()
+		status := "✅ Voice message sent."
+		if dictated, _ := created.GetProp("voice_dictated").(bool); dictated {
+			status = "✅ Dictation posted."
 		}
-		p.API.UpdateEphemeralPost(mt.UserID, &model.Post{
-			Id:        mt.EphemeralPostID,
-			UserId:    mt.UserID,
-			ChannelId: mt.ChannelID,
-			Message:   successMsg,
-		})
-		go func(uid, pid string) {
-			time.Sleep(6 * time.Second)
-			p.API.DeleteEphemeralPost(uid, pid)
-		}(mt.UserID, mt.EphemeralPostID)
-	}
-
-	// Auto-transcribe for mobile uploads too
-	if cfg.EnableTranscription && cfg.AutoTranscribe {
-		go p.autoTranscribe(created.Id, fileInfo.Id, data, ct)
+		msg := cfg.successMessage(status, p.buildRequestPermalink(r, created.Id))
+		p.replaceEphemeralPost(mt.UserID, mt.ChannelID, mt.EphemeralPostID, msg, cfg.getSuccessMessageLifetime())
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -879,21 +2345,157 @@ func (p *Plugin) handleMobileUpload(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 	_ = json.NewEncoder(w).Encode(map[string]string{
 		"post_id":   created.Id,
-		"file_id":   fileInfo.Id,
-		"permalink": p.buildPostPermalink(created.Id),
+		"file_id":   fileID,
+		"permalink": p.buildRequestPermalink(r, created.Id),
+	})
+}
+
+// successMessage renders SuccessMessageTemplate with {permalink} replaced by the
+// link to the new post. Without a template it is status followed by the link.
+func (c *Configuration) successMessage(status, permalink string) string {
+	tmpl := strings.TrimSpace(c.SuccessMessageTemplate)
+	if tmpl == "" {
+		tmpl = status + "\n{permalink}"
+	}
+	return strings.TrimSpace(strings.ReplaceAll(tmpl, "{permalink}", permalink))
+}
+
+// getSuccessMessageLifetime returns how long the success message stays before it
+// is deleted; 0 keeps it.
+func (c *Configuration) getSuccessMessageLifetime() time.Duration {
+	return time.Duration(intFromCfg(c.SuccessMessageSeconds, defaultSuccessMessageSeconds)) * time.Second
+}
+
+// replaceEphemeralPost swaps the text of a user's ephemeral post and, when
+// lifetime is positive, deletes the post after that long.
+func (p *Plugin) replaceEphemeralPost(userID, channelID, postID, message string, lifetime time.Duration) {
+	p.API.UpdateEphemeralPost(userID, &model.Post{
+		Id:        postID,
+		UserId:    userID,
+		ChannelId: channelID,
+		Message:   message,
 	})
+	if lifetime <= 0 {
+		return
+	}
+	go func() {
+		time.Sleep(lifetime)
+		p.API.DeleteEphemeralPost(userID, postID)
+	}()
+}
+
+// parseClientDuration reads the duration a client reported, in seconds; anything
+// unparsable or negative counts as unknown (0).
+func parseClientDuration(s string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
+// clientFamily names the platform in a User-Agent for logs, e.g. "ios" or "android".
+func clientFamily(ua string) string {
+	ua = strings.ToLower(ua)
+	switch {
+	case ua == "":
+		return "unknown"
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad") || strings.Contains(ua, "ipod"):
+		return "ios"
+	case strings.Contains(ua, "android"):
+		return "android"
+	case strings.Contains(ua, "mac os x") || strings.Contains(ua, "macintosh"):
+		return "macos" // includes iPadOS in desktop mode
+	case strings.Contains(ua, "windows"):
+		return "windows"
+	case strings.Contains(ua, "linux") || strings.Contains(ua, "cros"):
+		return "linux"
+	}
+	return "other"
+}
+
+func formatDuration(seconds float64) string {
+	return strconv.FormatFloat(math.Round(seconds*10)/10, 'f', -1, 64)
+}
+
+// checkDuration cross-checks the client-reported duration against the one probed
+// from the audio and returns the value to store. The probed value wins when the
+// two diverge, and it is what the maxDur limit (0 for none) is enforced against.
+// When the container can't be probed the client value is used as-is. On
+// rejection it has already written the response and returns false.
+func (p *Plugin) checkDuration(w http.ResponseWriter, maxDur int, data []byte, clientDuration float64, userID string) (float64, bool) {
+	probed, ok := probeAudioDuration(data)
+	if !ok {
+		p.API.LogWarn("Could not read duration from audio; using client-reported value",
+			"user_id", userID,
+			"container", audioContainer(data),
+			"bytes", len(data),
+			"client_seconds", formatDuration(clientDuration),
+		)
+		return clientDuration, true
+	}
+
+	if clientDuration > 0 && durationsDiverge(clientDuration, probed) {
+		p.API.LogWarn("Client-reported duration differs from audio; using probed value",
+			"user_id", userID,
+			"client_seconds", formatDuration(clientDuration),
+			"probed_seconds", formatDuration(probed),
+		)
+	}
+	if maxDur > 0 && probed > float64(maxDur)+durationToleranceSeconds {
+		http.Error(w, fmt.Sprintf("Recording too long (%.0fs > %ds limit)", probed, maxDur), http.StatusBadRequest)
+		return 0, false
+	}
+	return probed, true
+}
+
+// handleMobileTokenStatus reports whether a recording token is still usable
+// without consuming it, so the page can check before a deferred send.
+func (p *Plugin) handleMobileTokenStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimSpace(r.URL.Query().Get("token"))
+	if token == "" {
+		http.Error(w, "missing token", http.StatusBadRequest)
+		return
+	}
+
+	status := map[string]any{"valid": false}
+	if mt, err := p.getMobileToken(token); err == nil {
+		if mmUser := r.Header.Get("Mattermost-User-Id"); mmUser == "" || mmUser == mt.UserID {
+			status["valid"] = true
+			status["expires_at"] = mt.ExpiresAt
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(status)
 }
 
 // ----- Token & URL helpers -----
 
-func (p *Plugin) issueMobileToken(userID, channelID, rootID string) (string, error) {
+func (p *Plugin) issueMobileToken(userID string, target recordingTarget) (string, error) {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
 	tok := base64.RawURLEncoding.EncodeToString(b)
-	exp := time.Now().Add(time.Duration(p.getConfig().getMobileTokenTTLSeconds()) * time.Second).Unix()
-	mt := &mobileToken{UserID: userID, ChannelID: channelID, RootID: rootID, ExpiresAt: exp}
+	cfg := p.getConfig()
+	exp := time.Now().Add(time.Duration(cfg.getMobileTokenTTLSeconds()) * time.Second).Unix()
+	maxDur := p.maxDurationFor(cfg, userID)
+	mt := &mobileToken{
+		UserID:             userID,
+		ChannelID:          target.ChannelID,
+		RootID:             target.RootID,
+		Tags:               target.Tags,
+		Dictate:            target.Dictate,
+		Silent:             target.Silent,
+		ExpiresAt:          exp,
+		MaxDurationSeconds: &maxDur,
+	}
 	payload, err := json.Marshal(mt)
 	if err != nil {
 		return "", err
@@ -904,65 +2506,133 @@ func (p *Plugin) issueMobileToken(userID, channelID, rootID string) (string, err
 	return tok, nil
 }
 
+// setMobileTokenEphemeralPostID links a token to the ephemeral post that carries
+// its link. The write is compare-and-set against the value read, so a token that
+// is consumed (deleted) in between is left deleted instead of being recreated.
 func (p *Plugin) setMobileTokenEphemeralPostID(token, postID string) error {
 	if strings.TrimSpace(token) == "" || strings.TrimSpace(postID) == "" {
 		return nil
 	}
-	mt, err := p.getMobileToken(token)
-	if err != nil {
-		return err
-	}
-	mt.EphemeralPostID = postID
-	payload, err := json.Marshal(mt)
-	if err != nil {
-		return err
-	}
-	if appErr := p.API.KVSet(kvMobileTokenPrefix+token, payload); appErr != nil {
-		return fmt.Errorf("KVSet: %s", appErr.Error())
+	key := kvMobileTokenPrefix + token
+	for i := 0; i < mobileTokenUpdateAttempts; i++ {
+		old, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return fmt.Errorf("KVGet: %s", appErr.Error())
+		}
+		if old == nil {
+			return nil // already consumed or expired
+		}
+		var mt mobileToken
+		if err := json.Unmarshal(old, &mt); err != nil {
+			return err
+		}
+		mt.EphemeralPostID = postID
+		payload, err := json.Marshal(&mt)
+		if err != nil {
+			return err
+		}
+		ok, appErr := p.API.KVSetWithOptions(key, payload, model.PluginKVSetOptions{Atomic: true, OldValue: old})
+		if appErr != nil {
+			return fmt.Errorf("KVSetWithOptions: %s", appErr.Error())
+		}
+		if ok {
+			return nil
+		}
 	}
-	return nil
+	return fmt.Errorf("token changed concurrently")
 }
 
-func (p *Plugin) isAllowedOrigin(origin string) bool {
+// isAllowedOrigin accepts an Origin matching the SiteURL or, with TrustProxyHeaders,
+// the scheme and host a reverse proxy forwarded for r. Without either to compare
+// against, every origin is allowed.
+func (p *Plugin) isAllowedOrigin(origin string, r *http.Request) bool {
 	origin = strings.TrimSpace(origin)
 	if origin == "" {
 		return true
 	}
-	su := p.getSiteURL()
-	if su == "" {
+	o, err := url.Parse(origin)
+	if err != nil || o.Host == "" {
 		return true
 	}
-	site, err := url.Parse(su)
-	if err != nil || site.Host == "" {
-		return true
+
+	var candidates []*url.URL
+	if site, err := url.Parse(p.getSiteURL()); err == nil && site.Host != "" {
+		candidates = append(candidates, site)
 	}
-	o, err := url.Parse(origin)
-	if err != nil || o.Host == "" {
+	if fwd := p.forwardedOrigin(r); fwd != nil {
+		candidates = append(candidates, fwd)
+	}
+	if len(candidates) == 0 {
 		return true
 	}
-	return strings.EqualFold(o.Host, site.Host)
+	for _, c := range candidates {
+		if hostKey(o) == hostKey(c) {
+			return true
+		}
+	}
+	return false
 }
 
-func (p *Plugin) getMobileToken(token string) (*mobileToken, error) {
-	b, appErr := p.API.KVGet(kvMobileTokenPrefix + token)
-	if appErr != nil {
-		return nil, fmt.Errorf("KVGet: %s", appErr.Error())
+// forwardedOrigin returns the scheme and host from X-Forwarded-Proto and
+// X-Forwarded-Host, or nil unless TrustProxyHeaders is enabled and the host header
+// is present. Clients can set these headers freely, so they are only trusted when
+// an admin has confirmed a proxy in front of Mattermost overwrites them.
+func (p *Plugin) forwardedOrigin(r *http.Request) *url.URL {
+	if r == nil || !p.getConfig().TrustProxyHeaders {
+		return nil
 	}
-	if b == nil {
-		return nil, fmt.Errorf("not found")
+	host := firstHeaderValue(r.Header.Get("X-Forwarded-Host"))
+	if host == "" {
+		return nil
 	}
-	var mt mobileToken
-	if err := json.Unmarshal(b, &mt); err != nil {
-		return nil, err
+	proto := strings.ToLower(firstHeaderValue(r.Header.Get("X-Forwarded-Proto")))
+	if proto != "http" && proto != "https" {
+		proto = "https"
 	}
-	if mt.UserID == "" || mt.ChannelID == "" {
-		return nil, fmt.Errorf("invalid")
+	u, err := url.Parse(proto + "://" + host)
+	if err != nil || u.Host != host || u.Path != "" || u.User != nil {
+		return nil
 	}
-	if time.Now().Unix() >= mt.ExpiresAt {
-		_ = p.API.KVDelete(kvMobileTokenPrefix + token)
+	return u
+}
+
+// firstHeaderValue returns the first entry of a comma-separated header, which is
+// the one the outermost proxy added.
+func firstHeaderValue(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
+
+
+// hostKey returns "host:port" with the scheme's default port filled in, so
+// "https://example.com" and "https://example.com:443" compare equal. IPv6
+// literals are compared without their brackets.
+func hostKey(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch strings.ToLower(u.Scheme) {
+		case "https", "wss":
+			port = "443"
+		case "http", "ws":
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+func (p *Plugin) getMobileToken(token string) (*mobileToken, error) {
+	mt, _, err := p.readMobileToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if now := time.Now().Unix(); now >= mt.ExpiresAt {
+		// Kept for a while so the owner can still renew it; see handleMobileTokenRefresh.
+		if now >= mt.ExpiresAt+mobileTokenRefreshGraceSeconds {
+			_ = p.API.KVDelete(kvMobileTokenPrefix + token)
+		}
 		return nil, fmt.Errorf("expired")
 	}
-	return &mt, nil
+	return mt, nil
 }
 
 func (p *Plugin) getSiteURL() string {
@@ -973,8 +2643,25 @@ func (p *Plugin) getSiteURL() string {
 	return strings.TrimSpace(*cfg.ServiceSettings.SiteURL)
 }
 
+// externalSiteURL is the SiteURL, or when none is configured, the origin forwarded
+// by a trusted proxy for r.
+func (p *Plugin) externalSiteURL(r *http.Request) string {
+	if su := p.getSiteURL(); su != "" {
+		return su
+	}
+	if fwd := p.forwardedOrigin(r); fwd != nil {
+		return fwd.String()
+	}
+	return ""
+}
+
 func (p *Plugin) getBasePathFromSiteURL() string {
-	su := p.getSiteURL()
+	return basePathFromSiteURL(p.getSiteURL())
+}
+
+// basePathFromSiteURL returns the SiteURL's subpath without a trailing slash, still
+// percent-encoded so it can be embedded in URLs as is.
+func basePathFromSiteURL(su string) string {
 	if su == "" {
 		return ""
 	}
@@ -982,11 +2669,21 @@ func (p *Plugin) getBasePathFromSiteURL() string {
 	if err != nil {
 		return ""
 	}
-	path := strings.TrimRight(u.Path, "/")
-	if path == "/" {
-		return ""
+	return strings.TrimRight(u.EscapedPath(), "/")
+}
+
+// absoluteURL prefixes path with the SiteURL's scheme and host. u.Host keeps an
+// explicit port and IPv6 brackets (e.g. "[::1]:8065"). Without a usable SiteURL
+// the path is returned relative.
+func absoluteURL(siteURL, path string) string {
+	if siteURL == "" {
+		return path
+	}
+	u, err := url.Parse(siteURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return path
 	}
-	return path
+	return u.Scheme + "://" + u.Host + path
 }
 
 func (p *Plugin) buildMobileRecordURL(token, channelID, rootID string) string {
@@ -998,48 +2695,40 @@ func (p *Plugin) buildMobileRecordURL(token, channelID, rootID string) string {
 	if rootID != "" {
 		path += "&root_id=" + url.QueryEscape(rootID)
 	}
-	su := p.getSiteURL()
-	if su == "" {
-		return path
-	}
-	u, err := url.Parse(su)
-	if err != nil || u.Scheme == "" || u.Host == "" {
-		return path
-	}
-	return fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, path)
+	return absoluteURL(p.getSiteURL(), path)
 }
 
 func (p *Plugin) buildPostPermalink(postID string) string {
 	basePath := p.getBasePathFromSiteURL()
 	path := fmt.Sprintf("%s/pl/%s", basePath, postID)
-	su := p.getSiteURL()
-	if su == "" {
-		return path
-	}
-	u, err := url.Parse(su)
-	if err != nil || u.Scheme == "" || u.Host == "" {
-		return path
-	}
-	return fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, path)
+	return absoluteURL(p.getSiteURL(), path)
+}
+
+// buildRequestPermalink is buildPostPermalink for request handlers, which can fall
+// back to the proxy's forwarded origin when SiteURL is unset.
+func (p *Plugin) buildRequestPermalink(r *http.Request, postID string) string {
+	return absoluteURL(p.externalSiteURL(r), p.getBasePathFromSiteURL()+"/pl/"+postID)
+}
+
+func (p *Plugin) buildFileDownloadURL(fileID string) string {
+	return absoluteURL(p.getSiteURL(), p.getBasePathFromSiteURL()+"/api/v4/files/"+url.PathEscape(fileID)+"?download=1")
 }
 
 func extForContentType(ct string) string {
-	ct = strings.ToLower(strings.TrimSpace(ct))
-	if ct == "" {
-		return ".bin"
-	}
-	base := strings.TrimSpace(strings.Split(ct, ";")[0])
+	base, _ := parseAudioContentType(ct)
 	switch base {
 	case "audio/webm":
 		return ".webm"
 	case "audio/ogg", "application/ogg":
 		return ".ogg"
-	case "audio/mp4", "video/mp4":
+	case "audio/mp4", "audio/x-m4a", "video/mp4":
 		return ".m4a"
 	case "audio/mpeg":
 		return ".mp3"
-	case "audio/wav", "audio/x-wav":
+	case "audio/wav", "audio/x-wav", "audio/wave":
 		return ".wav"
+	case "audio/flac":
+		return ".flac"
 	default:
 		return ".bin"
 	}
@@ -1065,18 +2754,97 @@ func mimeForFilename(fn string) string {
 	}
 }
 
+// recordPageOptions carries admin-configurable page behavior; it is injected into
+// the page script as JSON.
+type recordPageOptions struct {
+	SilenceAutoStopSeconds int      `json:"silenceAutoStopSeconds"`
+	SilenceThreshold       float64  `json:"silenceThreshold"`
+	RecordingGain          float64  `json:"recordingGain"`
+	CaptionMaxChars        int      `json:"captionMaxChars"`
+	RequirePlayback        bool     `json:"requirePlayback"`
+	UploadRetries          int      `json:"uploadRetries"`
+	PreferredMimes         []string `json:"preferredMimes,omitempty"`
+	StartCountdownSeconds  int      `json:"startCountdownSeconds"`
+	RecordingBeep          bool     `json:"recordingBeep"`
+	PrewarmMic             bool     `json:"prewarmMic"`
+	FallbackEnabled        bool     `json:"fallbackEnabled"`
+	TokenStatusURL         string   `json:"tokenStatusUrl"`
+	RefreshURL             string   `json:"refreshUrl"`
+	LinkExpiresInSeconds   int64    `json:"linkExpiresIn"`
+	SizeWarningBytes       int64    `json:"sizeWarningBytes"`
+	Dictate                bool     `json:"dictate"`
+	ProgressURL            string   `json:"progressUrl"`
+	FallbackAccept         string   `json:"fallbackAccept"`
+	Notice                 string   `json:"-"` // rendered as HTML, not passed to the script
+	SentMessage            string   `json:"-"` // rendered as HTML, not passed to the script
+	Theme                  string   `json:"-"` // pageThemeDark or pageThemeLight
+}
+
+// renderNotice turns RecordingPageNotice into the page's banner. The text is
+// HTML-escaped, so it can't inject markup or script; line breaks are kept.
+func renderNotice(text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ""
+	}
+	if r := []rune(text); len(r) > maxRecordingPageNoticeRunes {
+		text = string(r[:maxRecordingPageNoticeRunes]) + "…"
+	}
+	escaped := strings.ReplaceAll(html.EscapeString(text), "\n", "<br/>")
+	return `<div class="notice" role="note">` + escaped + `</div>`
+}
+
+// The default lines under "Voice message sent!"; the SentScreenMessage help text
+// in plugin.json quotes both.
+const (
+	sentScreenTranscribing = "Your message will be transcribed shortly. You can close this tab now."
+	sentScreenDefault      = "You can close this tab now."
+)
+
+// sentScreenMessage returns the line under "Voice message sent!" on the
+// recording page: SentScreenMessage when set, and otherwise a default that says
+// whether the message is about to be transcribed.
+func (p *Plugin) sentScreenMessage(cfg *Configuration, mt *mobileToken) string {
+	if text := strings.TrimSpace(cfg.SentScreenMessage); text != "" {
+		if r := []rune(text); len(r) > maxSentScreenMessageRunes {
+			text = string(r[:maxSentScreenMessageRunes]) + "…"
+		}
+		return text
+	}
+	// A dictation is posted as text, so there is nothing left to transcribe.
+	if !mt.Dictate && p.shouldAutoTranscribe(cfg, mt.UserID) {
+		return sentScreenTranscribing
+	}
+	return sentScreenDefault
+}
+
 // renderMobileRecordHTML returns the full HTML for the mobile recording page.
-func renderMobileRecordHTML(channelDisplay, channelID, rootID, uploadURL string, maxSeconds int) string {
-	maxMin := maxSeconds / 60
-	maxSec := maxSeconds % 60
+func renderMobileRecordHTML(channelDisplay, channelID, rootID, uploadURL string, maxSeconds int, opts recordPageOptions) string {
+	// 0 means no limit: the page shows that and never stops on its own.
+	limit := "no limit"
+	if maxSeconds > 0 {
+		limit = fmt.Sprintf("%02d:%02d", maxSeconds/60, maxSeconds%60)
+	}
+
+	// json.Marshal escapes <, > and &, so the result is safe inside <script>.
+	optsJSON, err := json.Marshal(opts)
+	if err != nil {
+		optsJSON = []byte("{}")
+	}
 
 	threadLine := ""
 	if rootID != "" {
 		threadLine = `<span class="badge badge--thread">Thread reply</span>`
 	}
+	notice := renderNotice(opts.Notice)
+
+	theme := pageThemeDark
+	if opts.Theme == pageThemeLight {
+		theme = pageThemeLight
+	}
 
 	return fmt.Sprintf(`<!doctype html>
-<html lang="en">
+<html lang="en" data-theme="%s">
 <head>
 <meta charset="utf-8"/>
 <meta name="viewport" content="width=device-width,initial-scale=1,viewport-fit=cover"/>
@@ -1089,7 +2857,19 @@ func renderMobileRecordHTML(channelDisplay, channelID, rootID, uploadURL string,
   --accent:#3b82f6;--accent-glow:rgba(59,130,246,.25);
   --red:#ef4444;--red-glow:rgba(239,68,68,.2);
   --green:#22c55e;--green-glow:rgba(34,197,94,.15);
+  --err-text:#fca5a5;--warn-text:#fde68a;
+  --shadow:rgba(0,0,0,.4);--overlay:rgba(12,16,23,.85);
   --radius:16px;
+  color-scheme:dark;
+}
+:root[data-theme=light]{
+  --bg:#f4f6f9;--surface:#ffffff;--surface2:#eef2f7;
+  --border:#d6dde8;--text:#1b2331;--muted:#5b6b80;
+  --red:#dc2626;--red-glow:rgba(220,38,38,.1);
+  --green:#16a34a;--green-glow:rgba(22,163,74,.12);
+  --err-text:#b91c1c;--warn-text:#92400e;
+  --shadow:rgba(15,23,42,.12);--overlay:rgba(255,255,255,.9);
+  color-scheme:light;
 }
 html,body{height:100%%}
 body{
@@ -1103,7 +2883,7 @@ body{
 .card{
   background:var(--surface);border:1px solid var(--border);
   border-radius:var(--radius);overflow:hidden;
-  box-shadow:0 8px 32px rgba(0,0,0,.4);
+  box-shadow:0 8px 32px var(--shadow);
 }
 .card-header{
   padding:16px 20px;border-bottom:1px solid var(--border);
@@ -1123,11 +2903,24 @@ body{
   display:flex;align-items:center;gap:8px;flex-wrap:wrap;
 }
 .meta b{color:var(--text)}
+.notice{
+  padding:12px 20px;font-size:13px;line-height:1.5;color:var(--text);
+  background:rgba(245,158,11,.1);border-bottom:1px solid rgba(245,158,11,.3);
+  overflow-wrap:anywhere;
+}
 
 .rec-area{padding:32px 20px;display:flex;flex-direction:column;align-items:center;gap:20px}
+.caption-wrap{width:100%%;max-width:420px}
+.caption-wrap textarea{
+  width:100%%;padding:10px 12px;border-radius:12px;resize:vertical;
+  border:1px solid var(--border);background:var(--surface2);color:var(--text);
+  font:inherit;font-size:14px;line-height:1.4;
+}
+.caption-wrap textarea:focus{outline:none;border-color:var(--accent)}
 
 .timer{font-size:48px;font-weight:200;font-variant-numeric:tabular-nums;letter-spacing:2px;transition:color .3s}
 .timer--rec{color:var(--red)}
+.timer--paused{color:var(--muted)}
 .timer-limit{font-size:12px;color:var(--muted);margin-top:-12px}
 
 .rec-btn-wrap{position:relative;display:flex;align-items:center;justify-content:center}
@@ -1150,6 +2943,7 @@ body{
   -webkit-tap-highlight-color:transparent;
 }
 .rec-btn:active{transform:scale(.92)}
+.rec-btn:disabled{opacity:.35;box-shadow:none;cursor:not-allowed}
 .rec-btn--idle{background:var(--accent);box-shadow:0 4px 20px var(--accent-glow)}
 .rec-btn--idle:hover{box-shadow:0 4px 30px rgba(59,130,246,.4)}
 .rec-btn--recording{background:var(--red);box-shadow:0 4px 20px var(--red-glow)}
@@ -1188,7 +2982,8 @@ body{
   transition:all .3s;
 }
 .status-bar.ok{border-color:rgba(34,197,94,.5);color:var(--green);background:var(--green-glow)}
-.status-bar.err{border-color:rgba(239,68,68,.5);color:#fca5a5;background:var(--red-glow)}
+.status-bar.err{border-color:rgba(239,68,68,.5);color:var(--err-text);background:var(--red-glow)}
+.status-bar.warn{border-color:rgba(234,179,8,.5);color:var(--warn-text);background:rgba(234,179,8,.1)}
 
 .divider{height:1px;background:var(--border);margin:0 20px}
 .fallback{padding:16px 20px;display:flex;flex-direction:column;gap:8px}
@@ -1203,6 +2998,14 @@ body{
 .sent-icon svg{width:32px;height:32px;color:var(--green)}
 .sent-text{font-size:16px;font-weight:500}
 .sent-sub{font-size:13px;color:var(--muted)}
+
+.countdown{
+  position:fixed;inset:0;z-index:10;display:none;
+  flex-direction:column;align-items:center;justify-content:center;gap:12px;
+  background:var(--overlay);cursor:pointer;-webkit-tap-highlight-color:transparent;
+}
+.countdown-num{font-size:96px;font-weight:200;font-variant-numeric:tabular-nums;color:var(--accent)}
+.countdown-hint{font-size:13px;color:var(--muted)}
 </style>
 </head>
 <body>
@@ -1214,12 +3017,13 @@ body{
     <span class="badge">mobile</span>
     %s
   </div>
-  <div class="meta">Channel: <b>%s</b> &middot; Limit: <b>%02d:%02d</b></div>
+  <div class="meta">Channel: <b>%s</b> &middot; Limit: <b>%s</b></div>
+  %s
 
   <div id="mainArea">
     <div class="rec-area">
       <div class="timer" id="timer">00:00</div>
-      <div class="timer-limit" id="timerLimit">/ %02d:%02d</div>
+      <div class="timer-limit" id="timerLimit">/ %s</div>
 
       <div class="level-bars" id="levelBars"></div>
 
@@ -1230,6 +3034,10 @@ body{
         </button>
       </div>
 
+      <div class="caption-wrap" id="captionWrap" style="display:none">
+        <textarea id="caption" rows="2" placeholder="Add a note (optional)" aria-label="Note sent with the voice message"></textarea>
+      </div>
+
       <div class="actions" id="actionsRow">
         <!-- Buttons injected by JS based on state -->
       </div>
@@ -1247,8 +3055,8 @@ body{
     <div class="status-bar" id="status">Tap the microphone button to start recording.</div>
     <div style="height:12px"></div>
 
-    <div class="divider"></div>
-    <div class="fallback">
+    <div class="divider" id="fallbackDivider"></div>
+    <div class="fallback" id="fallback">
       <button class="btn" id="btnNative">
         <svg viewBox="0 0 24 24" width="18" height="18" fill="none" stroke="currentColor" stroke-width="2"><path d="M21 15v4a2 2 0 01-2 2H5a2 2 0 01-2-2v-4"/><polyline points="17 8 12 3 7 8"/><line x1="12" y1="3" x2="12" y2="15"/></svg>
         Use system recorder
@@ -1263,19 +3071,39 @@ body{
       <svg viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2.5" stroke-linecap="round" stroke-linejoin="round"><polyline points="20 6 9 17 4 12"/></svg>
     </div>
     <div class="sent-text">Voice message sent!</div>
-    <div class="sent-sub">You can close this tab now.</div>
+    <div class="sent-sub">%s</div>
     <a id="sentLink" class="btn btn--primary" style="display:none" href="#">Open message</a>
   </div>
 </div>
 </div>
 
+<div class="countdown" id="countdown">
+  <div class="countdown-num" id="countdownNum"></div>
+  <div class="countdown-hint">Get ready… tap to cancel</div>
+</div>
+
 <script>
 (function(){
   var uploadUrl = %q;
   var maxSeconds = %d;
+  var opts = %s;
   var state = 'idle';
-  var stream = null, rec = null, chunks = [], blob = null;
+  var stream = null, rec = null, chunks = [], blob = null, audioCtx = null;
   var startedAt = 0, tmr = null, analyser = null, dataArr = null;
+  // Time spent paused is left out of the elapsed count: pausedMs sums finished
+  // pauses and pausedAt marks the start of the current one.
+  var pausedMs = 0, pausedAt = 0;
+  var heardSpeech = false, silenceStart = 0;
+  // Quality metrics from the analyser loop, sent with the upload as voice_quality.
+  var QUIET_LEVEL = 0.02, qFrames = 0, qLevelSum = 0, qClipped = 0, qSilent = 0, timeArr = null;
+  var listened = false;
+  var cdTimer = null, cdLeft = 0;
+  var recordedSeconds = 0;
+  // Size of the recorded chunks so far, for the soft size warning.
+  var recordedBytes = 0, sizeWarned = false;
+  var queued = false;
+  var pickedFile = '';
+  var linkExpired = false, linkDeadline = 0, linkTimer = null;
 
   var elTimer = document.getElementById('timer');
   var elTimerLimit = document.getElementById('timerLimit');
@@ -1292,7 +3120,14 @@ body{
   var elSentScreen = document.getElementById('sentScreen');
   var elSentLink = document.getElementById('sentLink');
   var btnNative = document.getElementById('btnNative');
+  // Some WebViews ship without MediaRecorder; the page then relies on the
+  // system-recorder fallback, or says recording isn't possible here.
+  var recorderSupported = !!(window.MediaRecorder && navigator.mediaDevices && navigator.mediaDevices.getUserMedia);
   var fileInput = document.getElementById('fileInput');
+  var elCountdown = document.getElementById('countdown');
+  var elCaptionWrap = document.getElementById('captionWrap');
+  var elCaption = document.getElementById('caption');
+  var elCountdownNum = document.getElementById('countdownNum');
 
   // Create level bars
   var NUM_BARS = 24;
@@ -1312,10 +3147,30 @@ body{
     elStatus.innerHTML=msg;
   }
 
+  // setStatusText shows text from the server, which must not be read as HTML.
+  function setStatusText(msg,kind){
+    setStatus('',kind);
+    elStatus.textContent=msg;
+  }
+
   function renderActions(){
     elActions.innerHTML='';
     if(state==='idle') return;
-    if(state==='recording'){
+    if(state==='countdown'){
+      var bc=document.createElement('button');bc.className='btn btn--danger';bc.textContent='Cancel';
+      bc.onclick=cancelCountdown;
+      elActions.appendChild(bc);
+    }
+    if(state==='recording'||state==='paused'){
+      if(state==='paused'){
+        var bres=document.createElement('button');bres.className='btn btn--primary';bres.textContent='Resume';
+        bres.onclick=resumeRecording;
+        elActions.appendChild(bres);
+      }else if(rec&&typeof rec.pause==='function'){
+        var bp=document.createElement('button');bp.className='btn';bp.textContent='Pause';
+        bp.onclick=pauseRecording;
+        elActions.appendChild(bp);
+      }
       var bs=document.createElement('button');bs.className='btn btn--danger';bs.textContent='Stop';
       bs.onclick=function(){stopRecording(false)};
       elActions.appendChild(bs);
@@ -1327,6 +3182,8 @@ body{
       bd.onclick=function(){resetAll()};
       var bsnd=document.createElement('button');bsnd.className='btn btn--send';bsnd.textContent='Send';
       bsnd.onclick=send;
+      bsnd.disabled=(opts.requirePlayback&&!listened)||queued;
+      if(queued)bsnd.textContent='Queued';
       elActions.appendChild(br);
       elActions.appendChild(bd);
       elActions.appendChild(bsnd);
@@ -1338,16 +3195,25 @@ body{
     elPreviewWrap.style.display='none';
     elProgress.style.display='none';
     elLevelBars.style.display='none';
+    elCountdown.style.display='none';
+    elCaptionWrap.style.display='none';
 
     if(state==='idle'){
       recBtn.className='rec-btn rec-btn--idle';
       recBtn.innerHTML='<svg viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M12 2a3 3 0 0 0-3 3v7a3 3 0 0 0 6 0V5a3 3 0 0 0-3-3Z"/><path d="M19 10v2a7 7 0 0 1-14 0v-2"/><line x1="12" y1="19" x2="12" y2="22"/></svg>';
-      recBtn.disabled=false;
+      recBtn.disabled=!recorderSupported||linkExpired;
+      btnNative.disabled=linkExpired;
       elPulse.classList.remove('active');
       elTimer.className='timer';
       elTimer.textContent='00:00';
-      elTimerLimit.style.display='';
-      setStatus('Tap the microphone button to start recording.',null);
+      elTimerLimit.style.display=maxSeconds>0?'':'none';
+      if(linkExpired)setStatus('This link has expired, please request a new one.','err');
+      else if(!recorderSupported)explainNoRecorder();
+      else setStatus('Tap the microphone button to start recording.',null);
+    }
+    if(state==='countdown'){
+      elCountdown.style.display='flex';
+      setStatus('Recording starts in a moment. Tap again to cancel.',null);
     }
     if(state==='recording'){
       recBtn.className='rec-btn rec-btn--recording';
@@ -1358,6 +3224,16 @@ body{
       elLevelBars.style.display='flex';
       setStatus('Recording… Tap stop or wait for limit.',null);
     }
+    if(state==='paused'){
+      recBtn.className='rec-btn rec-btn--recording';
+      recBtn.innerHTML='<div class="stop-icon"></div>';
+      recBtn.disabled=false;
+      elPulse.classList.remove('active');
+      elTimer.className='timer timer--paused';
+      elLevelBars.style.display='flex';
+      for(var k=0;k<barEls.length;k++){barEls[k].style.height='4px';barEls[k].className='level-bar'}
+      setStatus('Paused. Tap Resume to continue or stop to finish.',null);
+    }
     if(state==='ready'){
       recBtn.className='rec-btn rec-btn--idle';
       recBtn.innerHTML='<svg viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M12 2a3 3 0 0 0-3 3v7a3 3 0 0 0 6 0V5a3 3 0 0 0-3-3Z"/><path d="M19 10v2a7 7 0 0 1-14 0v-2"/><line x1="12" y1="19" x2="12" y2="22"/></svg>';
@@ -1368,12 +3244,15 @@ body{
         elPreview.src=URL.createObjectURL(blob);
         elPreviewWrap.style.display='block';
       }
-      setStatus('Recording ready. Listen and tap Send.','ok');
+      if(!opts.dictate){elCaption.disabled=queued;elCaptionWrap.style.display='block'}
+      if(opts.requirePlayback&&!listened)setStatus('Play your recording to the end to enable Send.',null);
+      else setStatus('Recording ready. Listen and tap Send.','ok');
     }
     if(state==='uploading'){
       recBtn.disabled=true;
+      if(!opts.dictate&&elCaption.value.trim()){elCaption.disabled=true;elCaptionWrap.style.display='block'}
       elProgress.style.display='block';
-      setStatus('Uploading…',null);
+      setStatus(opts.dictate?'Uploading and transcribing…':'Uploading…',null);
     }
     if(state==='sent'){
       elMainArea.style.display='none';
@@ -1383,7 +3262,7 @@ body{
   }
 
   function pickMime(){
-    var c=['audio/webm;codecs=opus','audio/ogg;codecs=opus','audio/webm','audio/ogg','audio/mp4'];
+    var c=(opts.preferredMimes||[]).concat(['audio/webm;codecs=opus','audio/ogg;codecs=opus','audio/webm','audio/ogg','audio/mp4']);
     for(var i=0;i<c.length;i++){
       try{if(window.MediaRecorder&&MediaRecorder.isTypeSupported(c[i]))return c[i]}catch(e){}
     }
@@ -1395,90 +3274,402 @@ body{
     if(p.length<2)return '';return p.pop().split(';').shift()||'';
   }
 
+  function elapsedMs(){
+    return (pausedAt||Date.now())-startedAt-pausedMs;
+  }
+
   function updateTimer(){
-    var s=Math.max(0,Math.floor((Date.now()-startedAt)/1000));
+    var s=Math.max(0,Math.floor(elapsedMs()/1000));
     elTimer.textContent=fmtTime(s);
-    if(s>=maxSeconds)stopRecording(true);
+    if(maxSeconds>0&&s>=maxSeconds)stopRecording(true);
   }
 
   function updateLevels(){
     if(!analyser||state!=='recording')return;
     analyser.getByteFrequencyData(dataArr);
     var step=Math.floor(dataArr.length/NUM_BARS);
+    var level=0;
     for(var i=0;i<NUM_BARS;i++){
       var sum=0;for(var j=0;j<step;j++)sum+=dataArr[i*step+j];
       var avg=sum/step/255;
+      level+=avg/NUM_BARS;
       var h=Math.max(4,avg*36);
       barEls[i].style.height=h+'px';
       barEls[i].className=avg>.08?'level-bar active':'level-bar';
     }
+    trackQuality(level);
+    if(opts.silenceAutoStopSeconds>0&&checkSilence(level))return;
     if(state==='recording')requestAnimationFrame(updateLevels);
   }
 
+  // trackQuality counts each analyser frame's level, whether it was below the
+  // silence threshold, and whether any sample hit full scale (clipping).
+  function trackQuality(level){
+    qFrames++;qLevelSum+=level;
+    if(level<opts.silenceThreshold)qSilent++;
+    analyser.getByteTimeDomainData(timeArr);
+    for(var k=0;k<timeArr.length;k++){if(timeArr[k]===0||timeArr[k]===255){qClipped++;break}}
+  }
+
+  function qualityParams(){
+    if(!qFrames||pickedFile)return '';
+    return '&avg_level='+(qLevelSum/qFrames).toFixed(3)+'&clipping='+qClipped+'&silence_ratio='+(qSilent/qFrames).toFixed(2);
+  }
+
+  // Silence only counts once speech has been heard, so the user can take a
+  // moment before speaking; the window is long enough to ride out pauses.
+  function checkSilence(level){
+    var now=Date.now();
+    if(level>=opts.silenceThreshold){heardSpeech=true;silenceStart=0;return false}
+    if(!heardSpeech)return false;
+    if(!silenceStart){silenceStart=now;return false}
+    if(now-silenceStart<opts.silenceAutoStopSeconds*1000)return false;
+    stopRecording(false);
+    return true;
+  }
+
+  // startCountdown gives the user a moment to get ready before the microphone
+  // opens. Tapping the record button or the overlay cancels it.
+  function startCountdown(){
+    cdLeft=opts.startCountdownSeconds;
+    elCountdownNum.textContent=cdLeft;
+    setState('countdown');
+    cdTimer=setInterval(function(){
+      cdLeft--;
+      if(cdLeft>0){elCountdownNum.textContent=cdLeft;return}
+      clearInterval(cdTimer);cdTimer=null;
+      elCountdown.style.display='none';
+      startRecording();
+    },1000);
+  }
+
+  // The link stops working when its token expires, so the page stops offering to
+  // record then. A recording already made can still be sent: Send renews the link.
+  // Timers don't run while the phone sleeps, so the deadline is also checked
+  // whenever the page becomes visible again.
+  function armLinkExpiry(seconds){
+    clearTimeout(linkTimer);linkTimer=null;
+    linkExpired=false;
+    if(!(seconds>0))return;
+    linkDeadline=Date.now()+seconds*1000;
+    linkTimer=setTimeout(expireLink,seconds*1000);
+  }
+  function checkLinkExpiry(){
+    if(linkTimer&&!linkExpired&&Date.now()>=linkDeadline)expireLink();
+  }
+  function expireLink(){
+    clearTimeout(linkTimer);linkTimer=null;
+    linkExpired=true;
+    if(state==='countdown'){clearInterval(cdTimer);cdTimer=null}
+    if(state==='idle'||state==='countdown')setState('idle');
+  }
+
+  function cancelCountdown(){
+    if(!cdTimer)return;
+    clearInterval(cdTimer);cdTimer=null;
+    setState('idle');
+    setStatus('Countdown cancelled. Tap the microphone button to start again.',null);
+  }
+
+  // Recording tones (RecordingBeep) play on their own output-only context. The
+  // recorder takes the microphone stream directly, and tones play only before it
+  // starts and after it stops, so they don't end up in the recording.
+  var beepCtx=null,starting=false,BEEP_MS=250;
+  function primeBeep(){
+    if(!opts.recordingBeep||beepCtx)return;
+    try{beepCtx=new(window.AudioContext||window.webkitAudioContext)()}catch(e){}
+  }
+  function beep(freq,done){
+    if(!opts.recordingBeep||!beepCtx){if(done)done();return}
+    try{
+      if(beepCtx.state==='suspended')beepCtx.resume();
+      var t=beepCtx.currentTime,osc=beepCtx.createOscillator(),g=beepCtx.createGain();
+      osc.frequency.value=freq;
+      g.gain.setValueAtTime(0.0001,t);
+      g.gain.exponentialRampToValueAtTime(0.3,t+0.02);
+      g.gain.exponentialRampToValueAtTime(0.0001,t+BEEP_MS/1000);
+      osc.connect(g);g.connect(beepCtx.destination);
+      osc.start(t);osc.stop(t+BEEP_MS/1000);
+    }catch(e){}
+    // Wait out the tone and its echo before the recorder opens.
+    if(done)setTimeout(done,BEEP_MS+150);
+  }
+
+  function explainNoRecorder(){
+    if(opts.fallbackEnabled)setStatus('This browser can\'t record here. Tap <b>Use system recorder</b> below to record with your device\'s recorder app.','warn');
+    else setStatus('Recording isn\'t supported in this app\'s browser. Open this link in Safari, Chrome or another full browser.','err');
+  }
+
+  // checkMicPermission (PrewarmMic) looks up the microphone permission on load so
+  // a blocked microphone is explained before the user taps record. Browsers
+  // without the Permissions API, or that don't know "microphone", are skipped.
+  function checkMicPermission(){
+    if(!recorderSupported||!navigator.permissions||!navigator.permissions.query)return;
+    try{
+      navigator.permissions.query({name:'microphone'}).then(function(st){
+        showMicPermission(st.state);
+        st.onchange=function(){showMicPermission(st.state)};
+      }).catch(function(){});
+    }catch(e){}
+  }
+  function showMicPermission(perm){
+    if(state!=='idle'||linkExpired)return;
+    if(perm==='denied')setStatus('Microphone access is blocked for this page. Allow it in your browser or app settings, then reload.','err');
+    else if(perm==='prompt')setStatus('Tap the microphone button to start. Your browser will ask for microphone access.',null);
+    else setStatus('Tap the microphone button to start recording.',null);
+  }
+
   function startRecording(){
-    blob=null;chunks=[];
-    navigator.mediaDevices.getUserMedia({audio:true}).then(function(s){
+    recordedSeconds=0;pickedFile='';recordedBytes=0;sizeWarned=false;pausedMs=0;pausedAt=0;
+    blob=null;chunks=[];heardSpeech=false;silenceStart=0;listened=false;
+    qFrames=0;qLevelSum=0;qClipped=0;qSilent=0;
+    starting=true;
+    navigator.mediaDevices.getUserMedia({audio:{autoGainControl:true}}).then(function(s){
       stream=s;
-      var actx=new(window.AudioContext||window.webkitAudioContext)();
+      var actx=audioCtx=new(window.AudioContext||window.webkitAudioContext)();
+      // A context created after the permission prompt may start suspended (iOS
+      // outside a tap). The gain path only works once it runs, so it is resumed
+      // first, giving up after a short wait.
+      var wantGain=opts.recordingGain>1&&!!actx.createMediaStreamDestination;
+      if(!wantGain||actx.state==='running'||!actx.resume){beginRecording(s,actx);return}
+      var begun=false;
+      function begin(){if(!begun){begun=true;beginRecording(s,actx)}}
+      actx.resume().then(begin,begin);
+      setTimeout(begin,1000);
+    }).catch(function(e){
+      starting=false;
+      cleanup();setStatus('Microphone error: '+(e.message||e),'err');setState('idle');
+    });
+  }
+
+  // beginRecording builds the audio graph for the level meter and starts the
+  // recorder on the microphone stream s.
+  function beginRecording(s,actx){
+    if(stream!==s||audioCtx!==actx){starting=false;return}
+    try{
       var src=actx.createMediaStreamSource(s);
       analyser=actx.createAnalyser();analyser.fftSize=256;
-      src.connect(analyser);
+      // RecordingGain boosts quiet microphones: the recorder then takes the
+      // amplified signal from the audio graph. A context that still isn't
+      // running would record silence, so the raw stream is kept.
+      var recStream=s;
+      if(opts.recordingGain>1&&actx.state==='running'&&actx.createMediaStreamDestination){
+        var gain=actx.createGain();gain.gain.value=opts.recordingGain;
+        var dest=actx.createMediaStreamDestination();
+        src.connect(gain);gain.connect(analyser);gain.connect(dest);
+        recStream=dest.stream;
+      }else src.connect(analyser);
       dataArr=new Uint8Array(analyser.frequencyBinCount);
+      timeArr=new Uint8Array(analyser.fftSize);
 
       var mime=pickMime();
-      rec=new MediaRecorder(s,mime?{mimeType:mime}:undefined);
-      rec.ondataavailable=function(ev){if(ev.data&&ev.data.size>0)chunks.push(ev.data)};
+      rec=new MediaRecorder(recStream,mime?{mimeType:mime}:undefined);
+      rec.ondataavailable=function(ev){
+        if(!ev.data||ev.data.size<=0)return;
+        chunks.push(ev.data);recordedBytes+=ev.data.size;
+        if(opts.sizeWarningBytes>0&&!sizeWarned&&recordedBytes>=opts.sizeWarningBytes&&state==='recording'){
+          sizeWarned=true;setStatus('Recording is getting large, consider stopping.','warn');
+        }
+      };
       rec.onstop=function(){
         try{
           blob=new Blob(chunks,{type:rec.mimeType||(chunks[0]&&chunks[0].type)||'application/octet-stream'});
           cleanup();setState('ready');
+          if(qFrames&&qLevelSum/qFrames<QUIET_LEVEL)setStatus('Your microphone seems quiet. Make sure nothing covers it, or move closer and record again.','warn');
         }catch(e){cleanup();setStatus('Failed to build audio: '+e.message,'err');setState('idle')}
       };
-      rec.start(250);
-      startedAt=Date.now();updateTimer();
-      tmr=setInterval(updateTimer,250);
-      setState('recording');
-      requestAnimationFrame(updateLevels);
-    }).catch(function(e){
+      beep(880,function(){
+        starting=false;
+        if(!rec)return;
+        rec.start(250);
+        startedAt=Date.now();updateTimer();
+        tmr=setInterval(updateTimer,250);
+        setState('recording');
+        requestAnimationFrame(updateLevels);
+      });
+    }catch(e){
+      starting=false;
       cleanup();setStatus('Microphone error: '+(e.message||e),'err');setState('idle');
-    });
+    }
+  }
+
+  // pauseRecording holds the recorder and the timer; the level meter stops with
+  // the 'recording' state, so silence and quality are only measured while live.
+  function pauseRecording(){
+    if(state!=='recording'||!rec||rec.state!=='recording')return;
+    try{rec.pause()}catch(e){return}
+    pausedAt=Date.now();
+    if(tmr){clearInterval(tmr);tmr=null}
+    setState('paused');
+  }
+
+  // resumeRecording restarts the silence window too, so a long pause can't end
+  // the recording the moment it resumes.
+  function resumeRecording(){
+    if(state!=='paused'||!rec)return;
+    try{rec.resume()}catch(e){return}
+    pausedMs+=Date.now()-pausedAt;pausedAt=0;
+    silenceStart=0;
+    tmr=setInterval(updateTimer,250);
+    setState('recording');
+    requestAnimationFrame(updateLevels);
   }
 
   function stopRecording(auto){
     if(!rec)return;
+    recordedSeconds=Math.round(elapsedMs()/100)/10;
     try{rec.stop()}catch(e){}
     if(stream)try{stream.getTracks().forEach(function(t){t.stop()})}catch(e){}
     if(tmr){clearInterval(tmr);tmr=null}
+    beep(660);
     if(auto)setStatus('Recording limit reached.', null);
   }
 
   function cleanup(){
     if(stream)try{stream.getTracks().forEach(function(t){t.stop()})}catch(e){}
-    stream=null;rec=null;analyser=null;dataArr=null;
+    stream=null;rec=null;analyser=null;dataArr=null;timeArr=null;
+    if(audioCtx){try{audioCtx.close()}catch(e){}audioCtx=null}
     if(tmr){clearInterval(tmr);tmr=null}
   }
 
   function resetAll(){
-    cleanup();chunks=[];blob=null;setState('idle');
+    cleanup();chunks=[];blob=null;queued=false;setState('idle');
   }
 
   function send(){
     if(!blob){setStatus('No recording.','err');return}
+    if(navigator.onLine===false){queueSend();return}
     setState('uploading');
+    upload(1);
+  }
+
+  // queueSend holds the recording while offline; the 'online' handler sends it.
+  function queueSend(){
+    queued=true;
+    setState('ready');
+    setStatus('You are offline. Will send when back online.',null);
+  }
+
+  // sendQueued checks the link is still valid before the deferred upload, since
+  // the token may have expired while the device was offline.
+  function sendQueued(){
+    if(!queued||!blob)return;
+    queued=false;
+    setStatus('Back online. Checking link…',null);
+    fetch(opts.tokenStatusUrl,{credentials:'include',headers:{'X-Requested-With':'XMLHttpRequest'}}).then(function(res){
+      return res.json();
+    }).then(function(d){
+      if(!d||!d.valid){
+        setState('uploading');
+        renewLink(function(){upload(1)});
+        return;
+      }
+      setState('uploading');
+      upload(1);
+    }).catch(function(){queueSend()});
+  }
+
+  // renewLink swaps an expired link for a fresh one with the same channel and
+  // options, so the finished recording can still be sent. It needs the user's
+  // Mattermost session in this browser; on success it calls next.
+  function renewLink(next){
+    setStatus('Recording link expired. Renewing…',null);
+    var h={'X-Requested-With':'XMLHttpRequest'};
+    var csrf=getCookie('MMCSRF');if(csrf)h['X-CSRF-Token']=csrf;
+    fetch(opts.refreshUrl,{method:'POST',credentials:'include',headers:h}).then(function(res){
+      if(!res.ok)return{status:res.status};
+      return res.json().then(function(d){return{status:res.status,d:d}});
+    }).then(function(r){
+      if(!r.d||!r.d.upload_url){
+        setState('ready');
+        if(r.status===401)setStatus('This recording link has expired. Sign in to Mattermost in this browser and tap Send to renew it, or run /voice again.','err');
+        else setStatus('This recording link has expired. Run /voice again in Mattermost to get a new link.','err');
+        return;
+      }
+      uploadUrl=r.d.upload_url;opts.tokenStatusUrl=r.d.token_status_url;opts.refreshUrl=r.d.refresh_url;opts.progressUrl=r.d.progress_url;
+      armLinkExpiry(r.d.expires_in);
+      next();
+    }).catch(function(e){
+      setState('ready');setStatus('Network error: '+(e.message||e),'err');
+    });
+  }
+
+  var stageProgress={received:'50%%',uploaded:'70%%',post_created:'85%%',transcribing:'85%%'};
+
+  // openProgress subscribes to the server's processing stages for one upload and
+  // calls ready with the upload ID once the stream is open. It gives up after a
+  // short wait so a stream that can't connect never holds up sending.
+  function openProgress(ready){
+    if(!opts.progressUrl||!window.EventSource||!window.crypto||!crypto.getRandomValues){ready('',null);return}
+    var b=new Uint8Array(16);crypto.getRandomValues(b);
+    var id='';
+    for(var i=0;i<b.length;i++)id+=('0'+b[i].toString(16)).slice(-2);
+    var es=new EventSource(opts.progressUrl+'&id='+id,{withCredentials:true});
+    var started=false;
+    function start(){if(!started){started=true;ready(id,es)}}
+    es.onopen=start;
+    es.onerror=function(){es.close();start()};
+    es.addEventListener('stage',function(ev){
+      if(ev.data==='done'||ev.data==='failed'){es.close();return}
+      if(stageProgress[ev.data])elProgressFill.style.width=stageProgress[ev.data];
+      if(ev.data==='transcribing'&&opts.dictate)setStatus('Transcribing…',null);
+    });
+    setTimeout(start,1500);
+  }
+
+  // upload retries network errors and 5xx responses with exponential backoff;
+  // 4xx responses are final since retrying won't change the outcome.
+  function upload(attempt,renewed){
+    elProgressFill.style.width='10%%';
+    openProgress(function(id,es){uploadWithProgress(attempt,renewed,id,es)});
+  }
+
+  function uploadWithProgress(attempt,renewed,uploadId,es){
     elProgressFill.style.width='30%%';
 
     var csrf=getCookie('MMCSRF');
     var h={'Content-Type':blob.type||'application/octet-stream','X-Requested-With':'XMLHttpRequest'};
     if(csrf)h['X-CSRF-Token']=csrf;
-
-    fetch(uploadUrl,{method:'POST',body:blob,credentials:'include',headers:h}).then(function(res){
+    if(listened)h['X-Voice-Playback-Confirmed']='1';
+
+    var u=uploadUrl+(recordedSeconds>0?'&duration='+recordedSeconds:'')+(uploadId?'&upload_id='+uploadId:'');
+    if(pickedFile)u+='&source=file&filename='+encodeURIComponent(pickedFile);
+    u+=qualityParams();
+    // The caption goes in a header, percent-encoded, so it stays out of URL logs.
+    if(!opts.dictate&&elCaption.value.trim())h['X-Voice-Caption']=encodeURIComponent(elCaption.value.trim());
+    fetch(u,{method:'POST',body:blob,credentials:'include',headers:h}).then(function(res){
+      if(es)es.close();
       elProgressFill.style.width='90%%';
-      return res.text().then(function(txt){return{ok:res.ok,status:res.status,txt:txt}});
+      return res.text().then(function(txt){return{ok:res.ok,status:res.status,txt:txt,uploadError:res.headers.get('X-Voice-Upload-Error')}});
     }).then(function(r){
       elProgressFill.style.width='100%%';
       if(!r.ok){
-        setStatus('Upload error: '+r.status,'err');
-        setState('ready');return;
+        var storageDown=r.uploadError==='storage_unavailable';
+        if(r.status>=500&&r.status!==507&&!storageDown&&attempt<=opts.uploadRetries){retryUpload(attempt,renewed);return}
+        // An expired link is renewed once per send, then the upload is repeated.
+        if(r.status===401&&!renewed){renewLink(function(){upload(attempt,true)});return}
+        setState('ready');
+        if(r.status===401)setStatus('Please sign in to Mattermost in this browser, then tap Send again.','err');
+        else if(r.status===503)setStatus('Server is busy. Please wait a moment and tap Send again.','err');
+        else if(r.status===428)setStatus('Please listen to your recording before sending.','err');
+        else if(r.status===409)setStatus('This recording is already being sent. Please wait a moment.','warn');
+        else if(r.status===413)setStatus('Recording is too large to send. Please record a shorter message.','err');
+        else if(r.status===410&&r.txt.indexOf('already been sent')>=0)setStatus('This recording has already been sent.','ok');
+        else if(r.status===410)setStatus('This channel is no longer available.','err');
+        else if(r.status===429)setStatus('This channel has reached its voice message limit. Please try again later.','err');
+        // A 403 carries the reason: another plugin, the authorization webhook or
+        // the channel's own rules.
+        else if(r.status===403){
+          var reason=r.txt.trim();
+          if(reason.length>200)reason=reason.slice(0,200)+'…';
+          setStatusText(reason||'This voice message can\'t be posted in this channel.','err');
+        }
+        else if(r.status===415)setStatus('This file type is not accepted. Please choose a different recording.','err');
+        else if(r.status===422)setStatus('Recording appears empty — please try again.','err');
+        else if(r.status===507)setStatus('The server\'s file storage is full. Please let an administrator know.','err');
+        else if(storageDown)setStatus('The server can\'t store files right now. Please let an administrator know.','err');
+        else setStatus('Upload error: '+r.status,'err');
+        return;
       }
       var data=null;try{data=JSON.parse(r.txt)}catch(e){}
       if(data&&data.permalink){
@@ -1486,31 +3677,68 @@ body{
       }
       setState('sent');
     }).catch(function(e){
-      setStatus('Network error: '+(e.message||e),'err');setState('ready');
+      if(es)es.close();
+      if(navigator.onLine===false){queueSend();return}
+      if(attempt<=opts.uploadRetries){retryUpload(attempt,renewed);return}
+      setState('ready');setStatus('Network error: '+(e.message||e),'err');
     });
   }
 
+  function retryUpload(attempt,renewed){
+    var delay=Math.min(8000,1000*Math.pow(2,attempt-1));
+    setStatus('Retrying… (attempt '+(attempt+1)+' of '+(opts.uploadRetries+1)+')',null);
+    setTimeout(function(){upload(attempt+1,renewed)},delay);
+  }
+
   recBtn.addEventListener('click',function(){
-    if(state==='recording'){stopRecording(false);return}
-    if(state==='idle')startRecording();
+    if(state==='recording'||state==='paused'){stopRecording(false);return}
+    if(state==='countdown'){cancelCountdown();return}
+    checkLinkExpiry();
+    if(state!=='idle'||starting||linkExpired)return;
+    primeBeep();
+    if(opts.startCountdownSeconds>0)startCountdown();
+    else startRecording();
   });
-
+  elCountdown.addEventListener('click',cancelCountdown);
+  window.addEventListener('online',sendQueued);
+  document.addEventListener('visibilitychange',function(){if(!document.hidden)checkLinkExpiry()});
+
+  elCaption.maxLength=opts.captionMaxChars;
+  if(opts.fallbackAccept)fileInput.accept=opts.fallbackAccept;
+  if(!opts.fallbackEnabled){
+    document.getElementById('fallback').style.display='none';
+    document.getElementById('fallbackDivider').style.display='none';
+  }else if(!recorderSupported){
+    btnNative.classList.add('btn--primary');
+  }
   btnNative.addEventListener('click',function(){try{fileInput.click()}catch(e){}});
   fileInput.addEventListener('change',function(){
-    var f=fileInput.files&&fileInput.files[0];if(!f)return;
-    blob=f;chunks=[];cleanup();setState('ready');
+    var f=fileInput.files&&fileInput.files[0];if(!f||linkExpired)return;
+    blob=f;chunks=[];listened=false;recordedSeconds=0;queued=false;pickedFile=f.name||'recording';cleanup();setState('ready');
+  });
+
+  elPreview.addEventListener('ended',function(){
+    if(listened)return;
+    listened=true;
+    if(state==='ready'&&!queued){renderActions();setStatus('Recording ready. Listen and tap Send.','ok')}
   });
 
+  armLinkExpiry(opts.linkExpiresIn);
   setState('idle');
+  if(opts.prewarmMic)checkMicPermission();
 })();
 </script>
 </body>
 </html>`,
+		theme,
 		threadLine,
-		channelDisplay,
-		maxMin, maxSec,
-		maxMin, maxSec,
+		html.EscapeString(channelDisplay),
+		limit,
+		notice,
+		limit,
+		html.EscapeString(opts.SentMessage),
 		uploadURL,
 		maxSeconds,
+		optsJSON,
 	)
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetMobileTokenEphemeralPostIDRace(t *testing.T) {
+	const token = "token1"
+	key := kvMobileTokenPrefix + token
+	stored, _ := json.Marshal(&mobileToken{UserID: "user1", ChannelID: "channel1", ExpiresAt: 100})
+
+	t.Run("consumed between read and write", func(t *testing.T) {
+		api := newFakeKVAPI()
+		api.kv[key] = stored
+		api.beforeSet = func(k string) {
+			// An upload claims the token after it was read.
+			api.mu.Lock()
+			delete(api.kv, k)
+			api.mu.Unlock()
+		}
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		require.NoError(t, p.setMobileTokenEphemeralPostID(token, "post1"))
+		_, ok := api.kv[key]
+		assert.False(t, ok, "a consumed token isn't recreated")
+	})
+
+	t.Run("changed between read and write", func(t *testing.T) {
+		api := newFakeKVAPI()
+		api.kv[key] = stored
+		var once sync.Once
+		api.beforeSet = func(k string) {
+			// A refresh extends the token after it was read.
+			once.Do(func() {
+				renewed, _ := json.Marshal(&mobileToken{UserID: "user1", ChannelID: "channel1", ExpiresAt: 200})
+				api.mu.Lock()
+				api.kv[k] = renewed
+				api.mu.Unlock()
+			})
+		}
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		require.NoError(t, p.setMobileTokenEphemeralPostID(token, "post1"))
+		var mt mobileToken
+		require.NoError(t, json.Unmarshal(api.kv[key], &mt))
+		assert.Equal(t, "post1", mt.EphemeralPostID)
+		assert.Equal(t, int64(200), mt.ExpiresAt, "the concurrent change is kept")
+	})
+}
+
+func TestSiteURLBuilders(t *testing.T) {
+	for _, tc := range []struct {
+		siteURL   string
+		record    string
+		permalink string
+	}{
+		{
+			siteURL:   "https://[::1]:8065/chat",
+			record:    "https://[::1]:8065/chat/plugins/" + pluginID + "/mobile/record?token=t%2B1&channel_id=c1",
+			permalink: "https://[::1]:8065/chat/pl/post1",
+		},
+		{
+			siteURL:   "http://localhost:8065",
+			record:    "http://localhost:8065/plugins/" + pluginID + "/mobile/record?token=t%2B1&channel_id=c1",
+			permalink: "http://localhost:8065/pl/post1",
+		},
+		{
+			siteURL:   "https://chat.example.com:8443/team%20chat/",
+			record:    "https://chat.example.com:8443/team%20chat/plugins/" + pluginID + "/mobile/record?token=t%2B1&channel_id=c1",
+			permalink: "https://chat.example.com:8443/team%20chat/pl/post1",
+		},
+		{
+			siteURL:   "",
+			record:    "/plugins/" + pluginID + "/mobile/record?token=t%2B1&channel_id=c1",
+			permalink: "/pl/post1",
+		},
+	} {
+		t.Run(tc.siteURL, func(t *testing.T) {
+			api := newFakeKVAPI()
+			api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewPointer(tc.siteURL)}})
+			p := &Plugin{}
+			p.SetAPI(api)
+
+			assert.Equal(t, tc.record, p.buildMobileRecordURL("t+1", "c1", ""))
+			assert.Equal(t, tc.permalink, p.buildPostPermalink("post1"))
+		})
+	}
+}
+
+func TestSuccessMessage(t *testing.T) {
+	cfg := &Configuration{}
+	assert.Equal(t, "✅ Voice message sent.\nhttps://chat/pl/p1", cfg.successMessage("✅ Voice message sent.", "https://chat/pl/p1"))
+	assert.Equal(t, defaultSuccessMessageSeconds*time.Second, cfg.getSuccessMessageLifetime())
+
+	cfg = &Configuration{SuccessMessageTemplate: "Sent! {permalink} ({permalink})", SuccessMessageSeconds: "0"}
+	assert.Equal(t, "Sent! https://chat/pl/p1 (https://chat/pl/p1)", cfg.successMessage("ignored", "https://chat/pl/p1"))
+	assert.Zero(t, cfg.getSuccessMessageLifetime(), "0 keeps the message")
+}
+
+func TestReplaceEphemeralPost(t *testing.T) {
+	api := newFakeKVAPI()
+	api.On("UpdateEphemeralPost", "user1", mock.MatchedBy(func(post *model.Post) bool {
+		return post.Id == "eph1" && post.ChannelId == "channel1" && post.Message == "sent"
+	})).Return(&model.Post{})
+	deleted := make(chan string, 1)
+	api.On("DeleteEphemeralPost", "user1", mock.Anything).Run(func(args mock.Arguments) {
+		deleted <- args.String(1)
+	}).Return()
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	p.replaceEphemeralPost("user1", "channel1", "eph1", "sent", 0)
+	api.AssertNumberOfCalls(t, "UpdateEphemeralPost", 1)
+	time.Sleep(20 * time.Millisecond)
+	api.AssertNotCalled(t, "DeleteEphemeralPost", mock.Anything, mock.Anything)
+
+	p.replaceEphemeralPost("user1", "channel1", "eph1", "sent", 10*time.Millisecond)
+	select {
+	case id := <-deleted:
+		assert.Equal(t, "eph1", id)
+	case <-time.After(time.Second):
+		t.Fatal("the message wasn't deleted after its lifetime")
+	}
+}
+
+func TestSentScreenMessage(t *testing.T) {
+	api := newFakeKVAPI()
+	api.On("GetPreferenceForUser", "user1", preferenceCategory, prefAutoTranscribeOwn).Return(model.Preference{}, model.NewAppError("GetPreferenceForUser", "not_found", nil, "", 404))
+	p := &Plugin{}
+	p.SetAPI(api)
+	transcribing := &Configuration{EnableTranscription: true, AutoTranscribe: true, TranscriptionAPIKey: "key"}
+	user := &mobileToken{UserID: "user1"}
+
+	assert.Equal(t, sentScreenDefault, p.sentScreenMessage(&Configuration{}, user))
+	assert.Equal(t, sentScreenTranscribing, p.sentScreenMessage(transcribing, user))
+	assert.Equal(t, sentScreenDefault, p.sentScreenMessage(transcribing, &mobileToken{UserID: "user1", Dictate: true}))
+	assert.Equal(t, "Thanks!", p.sentScreenMessage(&Configuration{SentScreenMessage: "  Thanks! "}, user))
+
+	// The setting's help text describes the defaults word for word.
+	raw, err := os.ReadFile("../plugin.json")
+	require.NoError(t, err)
+	var manifest struct {
+		SettingsSchema struct {
+			Settings []struct {
+				Key      string `json:"key"`
+				HelpText string `json:"help_text"`
+			} `json:"settings"`
+		} `json:"settings_schema"`
+	}
+	require.NoError(t, json.Unmarshal(raw, &manifest))
+	var help string
+	for _, s := range manifest.SettingsSchema.Settings {
+		if s.Key == "SentScreenMessage" {
+			help = s.HelpText
+		}
+	}
+	assert.Contains(t, help, `"`+sentScreenTranscribing+`"`)
+	assert.Contains(t, help, `"`+sentScreenDefault+`"`)
+}
+
+func TestHandleFeatures(t *testing.T) {
+	p := &Plugin{}
+	p.SetAPI(newFakeKVAPI())
+	features := func() map[string]bool {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/features", nil)
+		r.Header.Set("Mattermost-User-Id", "user1")
+		w := httptest.NewRecorder()
+		p.handleFeatures(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		var f map[string]bool
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&f))
+		return f
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	p.configuration = &Configuration{}
+	f := features()
+	assert.False(t, f["waveform"], "without ffmpeg only WAV has a waveform")
+	assert.False(t, f["dictation"])
+	assert.False(t, f["translation"])
+	assert.True(t, f["silent"])
+
+	fakeFFmpeg(t)
+	p.configuration = &Configuration{EnableTranscription: true, TranscriptionAPIKey: "key", AutoTranslateToEnglish: true, ArchiveChannelID: "archive", ConvertToMP3ForArchive: true}
+	f = features()
+	assert.True(t, f["waveform"])
+	assert.True(t, f["dictation"])
+	assert.True(t, f["transcriptEdit"])
+	assert.True(t, f["translation"])
+	assert.True(t, f["archiveMP3"])
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// preferenceCategory holds this plugin's user preferences, following the "pp_"
+	// convention for plugin-owned categories.
+	preferenceCategory = "pp_com.scientia.voice-message"
+
+	prefAutoTranscribeOwn = "auto_transcribe_own"
+)
+
+// voicePreferences are the per-user settings served by /api/v1/preferences.
+type voicePreferences struct {
+	AutoTranscribeOwn bool `json:"auto_transcribe_own"`
+}
+
+// userWantsAutoTranscribe reports whether the user opted in to transcripts of their
+// own recordings. A missing preference reads as false.
+func (p *Plugin) userWantsAutoTranscribe(userID string) bool {
+	pref, appErr := p.API.GetPreferenceForUser(userID, preferenceCategory, prefAutoTranscribeOwn)
+	return appErr == nil && pref.Value == "true"
+}
+
+// shouldAutoTranscribe decides whether a new voice message by userID is
+// transcribed automatically: AutoTranscribe for everyone, or the author's own
+// opt-in, as long as transcription is configured.
+func (p *Plugin) shouldAutoTranscribe(cfg *Configuration, userID string) bool {
+	if !cfg.transcriptionAvailable() {
+		return false
+	}
+	return cfg.AutoTranscribe || p.userWantsAutoTranscribe(userID)
+}
+
+// handlePreferences serves GET and PUT /api/v1/preferences for the calling user.
+func (p *Plugin) handlePreferences(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var prefs voicePreferences
+		if err := json.NewDecoder(io.LimitReader(r.Body, 4*1024)).Decode(&prefs); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		value := "false"
+		if prefs.AutoTranscribeOwn {
+			value = "true"
+		}
+		appErr := p.API.UpdatePreferencesForUser(userID, []model.Preference{{
+			UserId:   userID,
+			Category: preferenceCategory,
+			Name:     prefAutoTranscribeOwn,
+			Value:    value,
+		}})
+		if appErr != nil {
+			p.API.LogError("Failed to save voice preferences", "user_id", userID, "err", appErr.Error())
+			http.Error(w, "Failed to save preferences", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(voicePreferences{AutoTranscribeOwn: p.userWantsAutoTranscribe(userID)})
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upload stages reported on the progress stream, in order. stageDone and
+// stageFailed end the stream.
+const (
+	stageReceived     = "received"
+	stageUploaded     = "uploaded"
+	stagePostCreated  = "post_created"
+	stageTranscribing = "transcribing"
+	stageDone         = "done"
+	stageFailed       = "failed"
+
+	// progressStreamTTL bounds how long a stream stays open and how long stages
+	// published without a listener are kept.
+	progressStreamTTL = 2 * time.Minute
+
+	// maxProgressStreams bounds the progress streams open on a node, and
+	// maxProgressStreamsPerUser those of one user.
+	maxProgressStreams        = 500
+	maxProgressStreamsPerUser = 4
+
+	minUploadIDLen = 16
+	maxUploadIDLen = 64
+)
+
+var (
+	errProgressNotYours = errors.New("upload belongs to another user")
+	errProgressBusy     = errors.New("too many progress streams open")
+)
+
+// sseFlushPadding is written after each event when the response writer can't be
+// flushed. Plugin responses are relayed over RPC without a Flush call, so the
+// event has to fill net/http's 4 KiB write buffer to reach the client promptly.
+var sseFlushPadding = ":" + strings.Repeat(" ", 4096) + "\n\n"
+
+// progressStream buffers the stages of one upload until its listener reads them.
+type progressStream struct {
+	events  chan string
+	created time.Time
+	// owner is the user whose upload the stream reports.
+	owner     string
+	listening bool
+}
+
+// uploadProgressHub tracks progress streams by client-chosen upload ID, each bound
+// to the user who first opened or uploaded under it. Streams are per node, so in a
+// cluster the page only sees stages when its stream request and upload land on
+// the same server. The zero value is ready to use.
+type uploadProgressHub struct {
+	mu      sync.Mutex
+	streams map[string]*progressStream
+	// open counts the streams with a listener, in total and per user.
+	open       int
+	openByUser map[string]int
+}
+
+// streamLocked returns owner's stream for id, creating it so that stages
+// published before the listener connects aren't lost, or nil when id belongs to
+// another user. h.mu must be held.
+func (h *uploadProgressHub) streamLocked(id, owner string) *progressStream {
+	if h.streams == nil {
+		h.streams = map[string]*progressStream{}
+	}
+	s, ok := h.streams[id]
+	if !ok {
+		s = &progressStream{events: make(chan string, 8), created: time.Now(), owner: owner}
+		h.streams[id] = s
+	}
+	if s.owner != owner {
+		return nil
+	}
+	return s
+}
+
+// bind ties an upload by owner to the progress stream id and returns the ID to
+// publish its stages under, or "" when id is empty or another user's.
+func (h *uploadProgressHub) bind(id, owner string) string {
+	if id == "" || owner == "" {
+		return ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.streamLocked(id, owner) == nil {
+		return ""
+	}
+	return id
+}
+
+// listen opens owner's stream for id. Each stream has one listener, and a user
+// and the node only a bounded number of them open at once.
+func (h *uploadProgressHub) listen(id, owner string) (*progressStream, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.open >= maxProgressStreams || h.openByUser[owner] >= maxProgressStreamsPerUser {
+		return nil, errProgressBusy
+	}
+	s := h.streamLocked(id, owner)
+	if s == nil || s.listening {
+		return nil, errProgressNotYours
+	}
+	if h.openByUser == nil {
+		h.openByUser = map[string]int{}
+	}
+	s.listening = true
+	h.open++
+	h.openByUser[owner]++
+	return s, nil
+}
+
+// close ends a listen and forgets the stream.
+func (h *uploadProgressHub) close(id string, s *progressStream) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.streams[id] == s {
+		delete(h.streams, id)
+	}
+	h.open--
+	if h.openByUser[s.owner]--; h.openByUser[s.owner] <= 0 {
+		delete(h.openByUser, s.owner)
+	}
+}
+
+// publish reports a stage for an ID returned by bind. It never blocks an upload;
+// stages beyond the buffer, or for a stream already closed, are dropped.
+func (h *uploadProgressHub) publish(id, stage string) {
+	if id == "" {
+		return
+	}
+	h.mu.Lock()
+	s := h.streams[id]
+	h.mu.Unlock()
+	if s == nil {
+		return
+	}
+	select {
+	case s.events <- stage:
+	default:
+	}
+}
+
+// finish reports the final stage of an upload.
+func (h *uploadProgressHub) finish(id string, succeeded bool) {
+	if succeeded {
+		h.publish(id, stageDone)
+	} else {
+		h.publish(id, stageFailed)
+	}
+}
+
+// prune drops streams older than progressStreamTTL, e.g. uploads whose page never
+// opened the stream.
+func (h *uploadProgressHub) prune() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, s := range h.streams {
+		if !s.listening && time.Since(s.created) > progressStreamTTL {
+			delete(h.streams, id)
+		}
+	}
+}
+
+// validUploadID accepts the random IDs pages generate: 16–64 URL-safe characters.
+// Anything else is ignored so that upload_id can't be used to guess other streams.
+func validUploadID(id string) bool {
+	if len(id) < minUploadIDLen || len(id) > maxUploadIDLen {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// uploadIDFromRequest returns the upload_id query parameter, or "" when absent or invalid.
+func uploadIDFromRequest(r *http.Request) string {
+	if id := r.URL.Query().Get("upload_id"); validUploadID(id) {
+		return id
+	}
+	return ""
+}
+
+// handleUploadProgress serves GET /api/v1/upload/progress?id=...[&token=...] as a
+// server-sent event stream. The page opens it with a random ID before sending and
+// passes the same ID as upload_id; each processing stage arrives as a "stage"
+// event until "done" or "failed". The stream needs a Mattermost session or the
+// recording page's token, and only reports the same user's uploads.
+func (p *Plugin) handleUploadProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if !validUploadID(id) {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-Id")
+	if token := strings.TrimSpace(r.URL.Query().Get("token")); userID == "" && token != "" {
+		if mt, err := p.getMobileToken(token); err == nil {
+			userID = mt.UserID
+		}
+	}
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	s, err := p.progress.listen(id, userID)
+	if errors.Is(err, errProgressBusy) {
+		http.Error(w, "Too many progress streams open", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusForbidden)
+		return
+	}
+	defer p.progress.close(id, s)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher, canFlush := w.(http.Flusher)
+	send := func(format string, args ...any) {
+		_, _ = fmt.Fprintf(w, format, args...)
+		if canFlush {
+			flusher.Flush()
+		} else {
+			_, _ = w.Write([]byte(sseFlushPadding))
+		}
+	}
+	send("retry: 3000\n\n")
+
+	timeout := time.NewTimer(progressStreamTTL)
+	defer timeout.Stop()
+	for {
+		select {
+		case stage := <-s.events:
+			send("event: stage\ndata: %s\n\n", stage)
+			if stage == stageDone || stage == stageFailed {
+				return
+			}
+		case <-timeout.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
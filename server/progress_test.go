@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadProgressBoundToUser(t *testing.T) {
+	var h uploadProgressHub
+	const id = "0123456789abcdef"
+
+	s, err := h.listen(id, "alice")
+	require.NoError(t, err)
+	assert.Empty(t, h.bind(id, "mallory"), "another user's upload can't report on the stream")
+	_, err = h.listen(id, "mallory")
+	assert.ErrorIs(t, err, errProgressNotYours)
+	_, err = h.listen(id, "alice")
+	assert.ErrorIs(t, err, errProgressNotYours, "one listener per stream")
+
+	uploadID := h.bind(id, "alice")
+	require.Equal(t, id, uploadID)
+	h.publish(uploadID, stageReceived)
+	assert.Equal(t, stageReceived, <-s.events)
+
+	h.close(id, s)
+	h.publish(uploadID, stageDone)
+	assert.Empty(t, h.streams)
+	assert.Zero(t, h.open)
+}
+
+func TestUploadProgressStreamLimit(t *testing.T) {
+	var h uploadProgressHub
+	ids := []string{"aaaaaaaaaaaaaaaa", "bbbbbbbbbbbbbbbb", "cccccccccccccccc", "dddddddddddddddd", "eeeeeeeeeeeeeeee"}
+	var opened []*progressStream
+	for _, id := range ids[:maxProgressStreamsPerUser] {
+		s, err := h.listen(id, "alice")
+		require.NoError(t, err)
+		opened = append(opened, s)
+	}
+	_, err := h.listen(ids[maxProgressStreamsPerUser], "alice")
+	assert.ErrorIs(t, err, errProgressBusy)
+	_, err = h.listen(ids[maxProgressStreamsPerUser], "bob")
+	assert.NoError(t, err, "the limit is per user")
+
+	h.close(ids[0], opened[0])
+	_, err = h.listen(ids[0], "alice")
+	assert.NoError(t, err)
+}
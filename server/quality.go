@@ -0,0 +1,32 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"strconv"
+)
+
+// maxQualityClipping bounds the clipped-frame count accepted from a client.
+const maxQualityClipping = 1_000_000
+
+// parseRecordingQuality reads the client's recording quality metrics from the
+// upload query: avg_level (0–1 mean input level), clipping (analyser frames with
+// a full-scale sample) and silence_ratio (0–1 share of frames below the silence
+// threshold). It returns the voice_quality prop, or nil when the client sent none.
+// Values are client-reported and only clamped, not trusted for anything else.
+func parseRecordingQuality(q url.Values) map[string]any {
+	quality := map[string]any{}
+	if v, err := strconv.ParseFloat(q.Get("avg_level"), 64); err == nil && !math.IsNaN(v) {
+		quality["avg_level"] = math.Round(math.Min(1, math.Max(0, v))*1000) / 1000
+	}
+	if v, err := strconv.Atoi(q.Get("clipping")); err == nil {
+		quality["clipping"] = min(max(v, 0), maxQualityClipping)
+	}
+	if v, err := strconv.ParseFloat(q.Get("silence_ratio"), 64); err == nil && !math.IsNaN(v) {
+		quality["silence_ratio"] = math.Round(math.Min(1, math.Max(0, v))*100) / 100
+	}
+	if len(quality) == 0 {
+		return nil
+	}
+	return quality
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	kvDeferredTranscriptionPrefix = "vm_deferred_transcription_"
+
+	// deferredDrainBatch caps how many deferred jobs one maintenance pass starts.
+	deferredDrainBatch = 20
+	kvListPageSize     = 200
+)
+
+// quietHours is a daily window, in a given time zone, during which
+// auto-transcription is deferred. The window may wrap past midnight.
+type quietHours struct {
+	start, end int // minutes since midnight
+	loc        *time.Location
+}
+
+// parseQuietHours reads "HH:MM-HH:MM [Time/Zone]", e.g. "22:00-06:00 Europe/Berlin".
+// The zone defaults to UTC. An empty string means no quiet hours (nil, nil).
+func parseQuietHours(s string) (*quietHours, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if len(fields) > 2 {
+		return nil, fmt.Errorf("expected \"HH:MM-HH:MM [Time/Zone]\"")
+	}
+
+	startStr, endStr, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return nil, fmt.Errorf("expected a start-end range such as 22:00-06:00")
+	}
+	qh := &quietHours{loc: time.UTC}
+	var err error
+	if qh.start, err = parseClock(startStr); err != nil {
+		return nil, err
+	}
+	if qh.end, err = parseClock(endStr); err != nil {
+		return nil, err
+	}
+	if qh.start == qh.end {
+		return nil, fmt.Errorf("start and end are the same")
+	}
+	if len(fields) == 2 {
+		if qh.loc, err = time.LoadLocation(fields[1]); err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", fields[1])
+		}
+	}
+	return qh, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (q *quietHours) contains(t time.Time) bool {
+	lt := t.In(q.loc)
+	m := lt.Hour()*60 + lt.Minute()
+	if q.start < q.end {
+		return m >= q.start && m < q.end
+	}
+	return m >= q.start || m < q.end
+}
+
+// getQuietHours returns the configured quiet hours, or nil when unset or invalid.
+func (c *Configuration) getQuietHours() *quietHours {
+	if c == nil {
+		return nil
+	}
+	qh, err := parseQuietHours(c.AutoTranscribeQuietHours)
+	if err != nil {
+		return nil
+	}
+	return qh
+}
+
+// deferredTranscription is a queued auto-transcription job. The audio is read
+// back from the file store when the job runs, so only references are stored.
+type deferredTranscription struct {
+	PostID    string `json:"post_id"`
+	ChannelID string `json:"channel_id"`
+	FileID    string `json:"file_id"`
+	MimeType  string `json:"mime_type"`
+	QueuedAt  int64  `json:"queued_at"`
+}
+
+func (p *Plugin) deferTranscription(postID, channelID, fileID, mimeType string) {
+	job := deferredTranscription{
+		PostID:    postID,
+		ChannelID: channelID,
+		FileID:    fileID,
+		MimeType:  mimeType,
+		QueuedAt:  time.Now().Unix(),
+	}
+	payload, _ := json.Marshal(job)
+	if appErr := p.API.KVSet(kvDeferredTranscriptionPrefix+postID, payload); appErr != nil {
+		p.API.LogError("Failed to defer auto-transcription", "post_id", postID, "err", appErr.Error())
+		return
+	}
+	p.metrics.autoTranscribeDeferred.Add(1)
+	p.API.LogDebug("Auto-transcription deferred until quiet hours end", "post_id", postID)
+}
+
+// drainDeferredTranscriptions runs queued jobs once quiet hours are over. Each job
+// is claimed with a compare-and-delete so only one server in a cluster runs it, and
+// put back when no transcription slot is free.
+func (p *Plugin) drainDeferredTranscriptions() {
+	cfg := p.getConfig()
+	if qh := cfg.getQuietHours(); qh != nil && qh.contains(time.Now()) {
+		return
+	}
+
+	keys, err := p.listKeys(kvDeferredTranscriptionPrefix, deferredDrainBatch)
+	if err != nil {
+		p.API.LogWarn("Failed to list deferred transcriptions", "err", err.Error())
+		return
+	}
+	for _, key := range keys {
+		raw, appErr := p.API.KVGet(key)
+		if appErr != nil || raw == nil {
+			continue
+		}
+		if claimed, appErr := p.API.KVCompareAndDelete(key, raw); appErr != nil || !claimed {
+			continue
+		}
+
+		var job deferredTranscription
+		if err := json.Unmarshal(raw, &job); err != nil {
+			continue
+		}
+		if !cfg.EnableTranscription {
+			continue
+		}
+		if !cfg.AutoTranscribe {
+			// Only jobs of authors who opted in still apply.
+			post, appErr := p.API.GetPost(job.PostID)
+			if appErr != nil || !p.userWantsAutoTranscribe(post.UserId) {
+				continue
+			}
+		}
+		data, appErr := p.API.GetFile(job.FileID)
+		if appErr != nil {
+			p.API.LogWarn("Dropping deferred transcription: audio unavailable", "post_id", job.PostID, "err", appErr.Error())
+			continue
+		}
+		if !p.runAutoTranscribe(job.PostID, job.ChannelID, job.FileID, data, job.MimeType) {
+			_ = p.API.KVSet(key, raw)
+			return
+		}
+	}
+}
+
+// listKeys returns up to limit plugin KV keys with the given prefix.
+func (p *Plugin) listKeys(prefix string, limit int) ([]string, error) {
+	return p.listKeysAfter(prefix, "", limit)
+}
+
+// listKeysAfter is listKeys for the keys sorting after the given one, so a
+// caller can page through more keys than one batch holds.
+func (p *Plugin) listKeysAfter(prefix, after string, limit int) ([]string, error) {
+	var out []string
+	for page := 0; ; page++ {
+		keys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			return nil, appErr
+		}
+		for _, k := range keys {
+			if strings.HasPrefix(k, prefix) && k > after {
+				out = append(out, k)
+				if len(out) >= limit {
+					return out, nil
+				}
+			}
+		}
+		if len(keys) < kvListPageSize {
+			return out, nil
+		}
+	}
+}
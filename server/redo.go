@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	kvLastTargetPrefix = "vm_last_target_"
+
+	// redoWindowSeconds is how long "/voice redo" remembers where a user last recorded.
+	redoWindowSeconds = 30 * 60
+)
+
+// recordingTarget is where and how a recording link was issued, kept so that
+// "/voice redo" can issue a fresh link for the same place.
+type recordingTarget struct {
+	ChannelID string   `json:"channel_id"`
+	RootID    string   `json:"root_id,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Dictate   bool     `json:"dictate,omitempty"`
+	Silent    bool     `json:"silent,omitempty"`
+}
+
+// rememberRecordingTarget stores the user's latest target for redoWindowSeconds.
+func (p *Plugin) rememberRecordingTarget(userID string, target recordingTarget) {
+	b, err := json.Marshal(target)
+	if err != nil {
+		return
+	}
+	if appErr := p.API.KVSetWithExpiry(kvLastTargetPrefix+userID, b, redoWindowSeconds); appErr != nil {
+		p.API.LogWarn("Failed to remember recording target", "user_id", userID, "err", appErr.Error())
+	}
+}
+
+// lastRecordingTarget returns the user's latest target, or nil once it has expired.
+func (p *Plugin) lastRecordingTarget(userID string) *recordingTarget {
+	b, appErr := p.API.KVGet(kvLastTargetPrefix + userID)
+	if appErr != nil || len(b) == 0 {
+		return nil
+	}
+	var target recordingTarget
+	if err := json.Unmarshal(b, &target); err != nil || target.ChannelID == "" {
+		return nil
+	}
+	return &target
+}
+
+// redoTarget resolves "/voice redo": the user's last target if it is recent and
+// they can still post there, otherwise an ephemeral explanation.
+func (p *Plugin) redoTarget(args *model.CommandArgs) (*recordingTarget, *model.CommandResponse) {
+	target := p.lastRecordingTarget(args.UserId)
+	if target == nil {
+		return nil, &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "⚠️ There's no recent recording to redo. Run /voice in the channel or thread you want to record in.",
+			ChannelId:    args.ChannelId,
+		}
+	}
+	ch, appErr := p.API.GetChannel(target.ChannelID)
+	if appErr != nil || ch.DeleteAt != 0 {
+		return nil, &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "⚠️ The channel of your last recording is no longer available.",
+			ChannelId:    args.ChannelId,
+		}
+	}
+	if _, appErr := p.API.GetChannelMember(target.ChannelID, args.UserId); appErr != nil {
+		return nil, &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "⚠️ You're no longer a member of the channel of your last recording.",
+			ChannelId:    args.ChannelId,
+		}
+	}
+	return target, nil
+}
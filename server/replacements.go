@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxTranscriptReplacements caps the rules applied to each transcript.
+const maxTranscriptReplacements = 100
+
+// transcriptReplacement is one TranscriptReplacements rule.
+type transcriptReplacement struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// getTranscriptReplacements parses TranscriptReplacements: one
+// "pattern => replacement" rule per line, where pattern is a Go regular expression
+// and the replacement may use $1-style group references. Blank lines and lines
+// starting with # are skipped. Rules that don't parse are returned as invalid.
+func (c *Configuration) getTranscriptReplacements() ([]transcriptReplacement, []string) {
+	if c == nil {
+		return nil, nil
+	}
+	var rules []transcriptReplacement
+	var invalid []string
+	for _, line := range strings.Split(c.TranscriptReplacements, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, replacement, ok := strings.Cut(line, "=>")
+		pattern = strings.TrimSpace(pattern)
+		if !ok || pattern == "" {
+			invalid = append(invalid, line)
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			invalid = append(invalid, line)
+			continue
+		}
+		if len(rules) == maxTranscriptReplacements {
+			invalid = append(invalid, fmt.Sprintf("%s (over %d rules)", line, maxTranscriptReplacements))
+			continue
+		}
+		rules = append(rules, transcriptReplacement{pattern: re, replacement: strings.TrimSpace(replacement)})
+	}
+	return rules, invalid
+}
+
+// applyTranscriptReplacements runs the TranscriptReplacements rules, in order, over
+// the transcript text, its English translation and its timed segments and words.
+func applyTranscriptReplacements(cfg *Configuration, result *transcriptionResult) {
+	rules, _ := cfg.getTranscriptReplacements()
+	if len(rules) == 0 {
+		return
+	}
+	replace := func(s string) string {
+		for _, rule := range rules {
+			s = rule.pattern.ReplaceAllString(s, rule.replacement)
+		}
+		return s
+	}
+	result.Text = replace(result.Text)
+	result.English = replace(result.English)
+	for i := range result.Segments {
+		result.Segments[i].Text = replace(result.Segments[i].Text)
+	}
+	for i := range result.Words {
+		result.Words[i].Text = replace(result.Words[i].Text)
+	}
+}
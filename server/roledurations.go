@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// getRoleMaxDurations parses RoleMaxDurationSeconds: "role=seconds" entries
+// separated by commas or new lines, e.g. "system_user=120, presenter=1200". 0
+// means no limit for that role. Entries that don't parse are returned as invalid.
+func (c *Configuration) getRoleMaxDurations() (map[string]int, []string) {
+	if c == nil {
+		return nil, nil
+	}
+	limits := map[string]int{}
+	var invalid []string
+	for _, entry := range strings.FieldsFunc(c.RoleMaxDurationSeconds, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		role, value, ok := strings.Cut(entry, "=")
+		role = strings.ToLower(strings.TrimSpace(role))
+		seconds, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || role == "" || strings.ContainsAny(role, " \t") || err != nil || seconds < 0 {
+			invalid = append(invalid, entry)
+			continue
+		}
+		limits[role] = seconds
+	}
+	return limits, invalid
+}
+
+// maxDurationForRoles returns the most generous RoleMaxDurationSeconds limit
+// among the given space-separated roles, where 0 (no limit) beats any other, or
+// the global MaxRecordingDurationSeconds when no role is listed.
+func (c *Configuration) maxDurationForRoles(roles string) int {
+	limits, _ := c.getRoleMaxDurations()
+	best, matched := 0, false
+	for _, role := range strings.Fields(strings.ToLower(roles)) {
+		seconds, ok := limits[role]
+		if !ok {
+			continue
+		}
+		if seconds == 0 {
+			return 0
+		}
+		if !matched || seconds > best {
+			best, matched = seconds, true
+		}
+	}
+	if !matched {
+		return c.getMaxDurationSeconds()
+	}
+	return best
+}
+
+// maxDurationFor returns the recording limit in seconds for a user, from their
+// system roles; 0 means no limit. Users that can't be looked up get the global
+// limit.
+func (p *Plugin) maxDurationFor(cfg *Configuration, userID string) int {
+	if strings.TrimSpace(cfg.RoleMaxDurationSeconds) == "" {
+		return cfg.getMaxDurationSeconds()
+	}
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return cfg.getMaxDurationSeconds()
+	}
+	return cfg.maxDurationForRoles(user.Roles)
+}
+
+// maxDuration returns the recording limit stored on the token when it was
+// issued, or the global limit for tokens issued before limits were stored.
+func (mt *mobileToken) maxDuration(cfg *Configuration) int {
+	if mt.MaxDurationSeconds != nil {
+		return *mt.MaxDurationSeconds
+	}
+	return cfg.getMaxDurationSeconds()
+}
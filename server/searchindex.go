@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	searchIndexTimeout  = 10 * time.Second
+	searchIndexAttempts = 3
+
+	// maxSearchIndexDeliveries bounds concurrent deliveries; transcripts finished
+	// while all slots are busy are not indexed.
+	maxSearchIndexDeliveries = 4
+)
+
+// searchIndexDocument is the payload sent to SearchIndexWebhookURL: one document
+// per transcript, flat and keyed by post ID so indexers can upsert it.
+type searchIndexDocument struct {
+	ID              string  `json:"id"`
+	PostID          string  `json:"post_id"`
+	ChannelID       string  `json:"channel_id"`
+	ChannelName     string  `json:"channel_name,omitempty"`
+	TeamID          string  `json:"team_id,omitempty"`
+	UserID          string  `json:"user_id"`
+	Permalink       string  `json:"permalink"`
+	Transcript      string  `json:"transcript"`
+	Language        string  `json:"language,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	CreateAt        int64   `json:"create_at"`
+	TranscribedAt   int64   `json:"transcribed_at"`
+}
+
+// indexTranscript sends a freshly stored voice message transcript to
+// SearchIndexWebhookURL in the background. Delivery failures are logged and never
+// affect the stored transcript. Withheld transcripts are not sent.
+func (p *Plugin) indexTranscript(cfg *Configuration, post *model.Post, language string) {
+	webhookURL := strings.TrimSpace(cfg.SearchIndexWebhookURL)
+	transcript, _ := post.GetProp("voice_transcript").(string)
+	if webhookURL == "" || strings.TrimSpace(transcript) == "" {
+		return
+	}
+
+	doc := searchIndexDocument{
+		ID:            post.Id,
+		PostID:        post.Id,
+		ChannelID:     post.ChannelId,
+		UserID:        post.UserId,
+		Permalink:     p.buildPostPermalink(post.Id),
+		Transcript:    transcript,
+		Language:      language,
+		CreateAt:      post.CreateAt,
+		TranscribedAt: model.GetMillis(),
+	}
+	if d, _ := post.GetProp("voice_duration").(string); d != "" {
+		doc.DurationSeconds, _ = strconv.ParseFloat(d, 64)
+	}
+	if ch, appErr := p.API.GetChannel(post.ChannelId); appErr == nil {
+		doc.ChannelName = ch.Name
+		doc.TeamID = ch.TeamId
+	}
+
+	select {
+	case p.searchIndexSem <- struct{}{}:
+	default:
+		p.API.LogWarn("Search indexing skipped: too many deliveries in flight", "post_id", post.Id)
+		return
+	}
+	go func() {
+		defer func() { <-p.searchIndexSem }()
+		if err := deliverSearchIndexDocument(webhookURL, doc); err != nil {
+			p.API.LogWarn("Search index webhook failed", "post_id", doc.PostID, "err", err.Error())
+		}
+	}()
+}
+
+// deliverSearchIndexDocument posts the document, retrying network errors, 429s
+// and 5xx responses with a growing delay.
+func deliverSearchIndexDocument(webhookURL string, doc searchIndexDocument) error {
+	payload, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: searchIndexTimeout}
+
+	var lastErr error
+	for attempt := 1; attempt <= searchIndexAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * 2 * time.Second)
+		}
+		resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			lastErr = fmt.Errorf("network: %w", err)
+			continue
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("status %d, body: %s", resp.StatusCode, truncate(string(body), 300))
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			break
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// kvSigningSecretKey holds the random key signed audio URLs are signed with.
+	// It is created once and shared by all cluster nodes.
+	kvSigningSecretKey = "vm_signing_secret"
+
+	// signedAudioTTL is how long a signed audio URL handed to a provider stays valid.
+	signedAudioTTL = 10 * time.Minute
+)
+
+// urlAudioProviders lists the providers that accept the audio as a URL in place of
+// the file bytes. DeepInfra's inference endpoint fetches a URL sent in its audio
+// field; OpenAI's transcription endpoint only takes uploaded files.
+var urlAudioProviders = map[string]bool{
+	"deepinfra": true,
+}
+
+// signingSecret returns the shared signing key, creating it on first use. The
+// create is set-if-absent, so concurrent nodes end up with the same key.
+func (p *Plugin) signingSecret() ([]byte, error) {
+	p.signingLock.Lock()
+	defer p.signingLock.Unlock()
+	if p.signingKey != nil {
+		return p.signingKey, nil
+	}
+
+	key, appErr := p.API.KVGet(kvSigningSecretKey)
+	if appErr != nil {
+		return nil, fmt.Errorf("KVGet: %s", appErr.Error())
+	}
+	if len(key) == 0 {
+		fresh := make([]byte, 32)
+		if _, err := rand.Read(fresh); err != nil {
+			return nil, err
+		}
+		if _, appErr := p.API.KVSetWithOptions(kvSigningSecretKey, fresh, model.PluginKVSetOptions{Atomic: true, OldValue: nil}); appErr != nil {
+			return nil, fmt.Errorf("KVSet: %s", appErr.Error())
+		}
+		if key, appErr = p.API.KVGet(kvSigningSecretKey); appErr != nil || len(key) == 0 {
+			return nil, fmt.Errorf("signing key unavailable")
+		}
+	}
+	p.signingKey = key
+	return key, nil
+}
+
+func audioSignature(secret []byte, fileID string, expires int64) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s.%d", fileID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedAudioURL returns an absolute URL that serves a file without a session
+// until ttl has passed. It needs SiteURL, since the URL is fetched from outside.
+func (p *Plugin) signedAudioURL(fileID string, ttl time.Duration) (string, error) {
+	if p.getSiteURL() == "" {
+		return "", fmt.Errorf("SiteURL is not set")
+	}
+	secret, err := p.signingSecret()
+	if err != nil {
+		return "", err
+	}
+	expires := time.Now().Add(ttl).Unix()
+	q := url.Values{}
+	q.Set("file_id", fileID)
+	q.Set("exp", strconv.FormatInt(expires, 10))
+	q.Set("sig", audioSignature(secret, fileID, expires))
+	path := fmt.Sprintf("%s/plugins/%s/api/v1/audio/signed?%s", p.getBasePathFromSiteURL(), pluginID, q.Encode())
+	return absoluteURL(p.getSiteURL(), path), nil
+}
+
+// withAudioURL returns target with a signed URL for fileID when TranscribeViaURL
+// is on and the provider can fetch audio itself; otherwise target unchanged, and
+// the bytes are uploaded as usual.
+func (p *Plugin) withAudioURL(cfg *Configuration, target *transcriptionTarget, fileID string) *transcriptionTarget {
+	if !cfg.TranscribeViaURL || fileID == "" || !urlAudioProviders[target.Provider] {
+		return target
+	}
+	u, err := p.signedAudioURL(fileID, signedAudioTTL)
+	if err != nil {
+		p.API.LogWarn("Sending audio bytes: can't sign an audio URL", "file_id", fileID, "err", err.Error())
+		return target
+	}
+	withURL := *target
+	withURL.AudioURL = u
+	return &withURL
+}
+
+// handleSignedAudio serves GET /api/v1/audio/signed?file_id=...&exp=...&sig=...,
+// the audio behind a signed URL, without a session. Only audio files are served.
+func (p *Plugin) handleSignedAudio(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	fileID := q.Get("file_id")
+	expires, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if fileID == "" || err != nil {
+		http.Error(w, "Invalid link", http.StatusBadRequest)
+		return
+	}
+	secret, err := p.signingSecret()
+	if err != nil {
+		http.Error(w, "Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if !hmac.Equal([]byte(q.Get("sig")), []byte(audioSignature(secret, fileID, expires))) {
+		http.Error(w, "Invalid link", http.StatusForbidden)
+		return
+	}
+	if time.Now().Unix() >= expires {
+		http.Error(w, "Link expired", http.StatusGone)
+		return
+	}
+
+	info, appErr := p.API.GetFileInfo(fileID)
+	if appErr != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if extForContentType(info.MimeType) == ".bin" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	data, appErr := p.API.GetFile(fileID)
+	if appErr != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", info.MimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = w.Write(data)
+}
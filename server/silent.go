@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// voiceSilentProp marks a post made with "/voice silent" or in a SilentChannels channel.
+const voiceSilentProp = "voice_silent"
+
+// mentionBreaker is inserted after "@" in silent posts. Mattermost splits words on
+// it, so "@name" no longer parses as a mention but still reads the same.
+const mentionBreaker = "\u2060" // word joiner
+
+// isSilentChannel reports whether voice messages in the channel are silent by
+// default (SilentChannels, a comma-separated list of channel IDs).
+func (c *Configuration) isSilentChannel(channelID string) bool {
+	if c == nil || channelID == "" {
+		return false
+	}
+	for _, id := range strings.Split(c.SilentChannels, ",") {
+		if strings.TrimSpace(id) == channelID {
+			return true
+		}
+	}
+	return false
+}
+
+// muteMentions keeps @-mentions in text from notifying anyone.
+//
+// Mattermost has no per-post flag that suppresses notifications (from_webhook and
+// mentionHighlightDisabled don't), so a silent post stays silent by carrying no
+// mentions at all. Members whose preferences notify on every message, and DM or
+// group-message participants, are still notified as for any post.
+func muteMentions(text string) string {
+	return strings.ReplaceAll(text, "@", "@"+mentionBreaker)
+}
+
+func isSilentPost(post *model.Post) bool {
+	silent, _ := post.GetProp(voiceSilentProp).(bool)
+	return silent
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	maxVoiceTags   = 5
+	maxVoiceTagLen = 32
+
+	defaultListPerPage = 60
+	maxListPerPage     = 200
+)
+
+// normalizeTags turns user input into canonical tags: lowercase, without a leading
+// '#', limited to letters, digits, '-' and '_', deduplicated. Each argument may hold
+// several comma-separated tags.
+func normalizeTags(raw []string) ([]string, error) {
+	var tags []string
+	seen := map[string]bool{}
+	for _, arg := range raw {
+		for _, t := range strings.Split(arg, ",") {
+			t = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(t), "#"))
+			if t == "" || seen[t] {
+				continue
+			}
+			if utf8.RuneCountInString(t) > maxVoiceTagLen {
+				return nil, fmt.Errorf("tag %q is longer than %d characters", t, maxVoiceTagLen)
+			}
+			for _, r := range t {
+				if !isTagRune(r) {
+					return nil, fmt.Errorf("tag %q may only contain letters, digits, '-' and '_'", t)
+				}
+			}
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+	if len(tags) > maxVoiceTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxVoiceTags)
+	}
+	return tags, nil
+}
+
+func isTagRune(r rune) bool {
+	return r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// postTags reads the voice_tags prop, which comes back as []any after a round trip
+// through the database.
+func postTags(post *model.Post) []string {
+	switch v := post.GetProp("voice_tags").(type) {
+	case []string:
+		return v
+	case []any:
+		tags := make([]string, 0, len(v))
+		for _, t := range v {
+			if s, ok := t.(string); ok {
+				tags = append(tags, s)
+			}
+		}
+		return tags
+	}
+	return nil
+}
+
+func postHasTag(post *model.Post, tag string) bool {
+	for _, t := range postTags(post) {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// voiceMessageSummary is one entry in the voice message listing.
+type voiceMessageSummary struct {
+	PostID     string   `json:"post_id"`
+	UserID     string   `json:"user_id"`
+	RootID     string   `json:"root_id,omitempty"`
+	CreateAt   int64    `json:"create_at"`
+	Duration   string   `json:"duration"`
+	Tags       []string `json:"tags,omitempty"`
+	Transcript string   `json:"transcript,omitempty"`
+}
+
+// handleListVoiceMessages serves GET /api/v1/voice-messages?channel_id=...&tag=...
+// It pages through the channel's posts and returns the voice messages, optionally
+// only those carrying the given tag. page and per_page select the window of channel
+// posts scanned, so a page may hold fewer matches than per_page.
+func (p *Plugin) handleListVoiceMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	channelID := q.Get("channel_id")
+	if channelID == "" {
+		http.Error(w, "channel_id required", http.StatusBadRequest)
+		return
+	}
+	if _, appErr := p.API.GetChannelMember(channelID, userID); appErr != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	tag := ""
+	if raw := q.Get("tag"); raw != "" {
+		tags, err := normalizeTags([]string{raw})
+		if err != nil || len(tags) != 1 {
+			http.Error(w, "Invalid tag", http.StatusBadRequest)
+			return
+		}
+		tag = tags[0]
+	}
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page < 0 {
+		page = 0
+	}
+	perPage, _ := strconv.Atoi(q.Get("per_page"))
+	if perPage <= 0 || perPage > maxListPerPage {
+		perPage = defaultListPerPage
+	}
+
+	list, appErr := p.API.GetPostsForChannel(channelID, page, perPage)
+	if appErr != nil {
+		p.API.LogError("GetPostsForChannel failed", "err", appErr.Error())
+		http.Error(w, "Failed to list posts", http.StatusInternalServerError)
+		return
+	}
+
+	items := []voiceMessageSummary{}
+	for _, id := range list.Order {
+		post := list.Posts[id]
+		if post == nil || post.Type != "custom_voice_message" || post.DeleteAt != 0 {
+			continue
+		}
+		if tag != "" && !postHasTag(post, tag) {
+			continue
+		}
+		duration, _ := post.GetProp("voice_duration").(string)
+		transcript, _ := post.GetProp("voice_transcript").(string)
+		items = append(items, voiceMessageSummary{
+			PostID:     post.Id,
+			UserID:     post.UserId,
+			RootID:     post.RootId,
+			CreateAt:   post.CreateAt,
+			Duration:   duration,
+			Tags:       postTags(post),
+			Transcript: transcript,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"voice_messages": items,
+		"page":           page,
+		"per_page":       perPage,
+		"has_more":       len(list.Order) == perPage,
+	})
+}
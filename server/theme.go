@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	pageThemeDark  = "dark"
+	pageThemeLight = "light"
+)
+
+// darkThemeTypes lists Mattermost's built-in dark themes, for theme preferences
+// without a readable center channel color.
+var darkThemeTypes = map[string]bool{
+	"onyx":   true,
+	"indigo": true,
+}
+
+// recordPageTheme picks the recording page palette: a theme=light|dark query
+// parameter first, then the user's Mattermost theme for the channel's team (or
+// for all teams), and dark when neither says.
+func (p *Plugin) recordPageTheme(r *http.Request, userID, teamID string) string {
+	switch strings.ToLower(strings.TrimSpace(r.URL.Query().Get("theme"))) {
+	case pageThemeLight:
+		return pageThemeLight
+	case pageThemeDark:
+		return pageThemeDark
+	}
+
+	names := []string{""}
+	if teamID != "" {
+		names = []string{teamID, ""}
+	}
+	for _, name := range names {
+		pref, appErr := p.API.GetPreferenceForUser(userID, model.PreferenceCategoryTheme, name)
+		if appErr != nil {
+			continue
+		}
+		if dark, ok := themeIsDark(pref.Value); ok {
+			if dark {
+				return pageThemeDark
+			}
+			return pageThemeLight
+		}
+	}
+	return pageThemeDark
+}
+
+// themeIsDark reads a Mattermost theme preference value. The center channel
+// background decides; the built-in theme name is the fallback.
+func themeIsDark(value string) (dark, ok bool) {
+	var theme struct {
+		Type            string `json:"type"`
+		CenterChannelBg string `json:"centerChannelBg"`
+	}
+	if err := json.Unmarshal([]byte(value), &theme); err != nil {
+		return false, false
+	}
+	if hex := strings.TrimPrefix(strings.TrimSpace(theme.CenterChannelBg), "#"); len(hex) == 6 {
+		if rgb, err := strconv.ParseUint(hex, 16, 32); err == nil {
+			r, g, b := float64(rgb>>16), float64(rgb>>8&0xff), float64(rgb&0xff)
+			return 0.299*r+0.587*g+0.114*b < 128, true
+		}
+	}
+	if t := strings.ToLower(strings.TrimSpace(theme.Type)); t != "" {
+		return darkThemeTypes[t], true
+	}
+	return false, false
+}
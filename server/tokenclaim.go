@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// kvMobileTokenClaimPrefix marks a recording token whose upload is in progress.
+	kvMobileTokenClaimPrefix = "vm_token_claim_"
+
+	// mobileTokenClaimSeconds bounds how long a claim outlives a server that died
+	// mid-upload; it covers a slow upload followed by dictation.
+	mobileTokenClaimSeconds = 10 * 60
+
+	// tokenInUseMessage answers a second upload with a token that is being used.
+	tokenInUseMessage = "This recording is already being sent"
+
+	// tokenUsedMessage answers an upload whose token another upload consumed.
+	tokenUsedMessage = "This recording has already been sent"
+)
+
+// claimMobileToken atomically marks a token as in use so that only one upload can
+// consume it; a double-tapped Send or a retried request finds it claimed and gets
+// false.
+func (p *Plugin) claimMobileToken(token string) (bool, error) {
+	claimed, appErr := p.API.KVSetWithOptions(kvMobileTokenClaimPrefix+token, []byte{1}, model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: mobileTokenClaimSeconds,
+	})
+	if appErr != nil {
+		return false, fmt.Errorf("KVSetWithOptions: %s", appErr.Error())
+	}
+	return claimed, nil
+}
+
+// releaseMobileToken drops a claim, so a failed upload can be retried with the
+// same token.
+func (p *Plugin) releaseMobileToken(token string) {
+	if appErr := p.API.KVDelete(kvMobileTokenClaimPrefix + token); appErr != nil {
+		p.API.LogWarn("Failed to release recording token claim", "err", appErr.Error())
+	}
+}
+
+// mobileTokenClaimed reports whether an upload with the token is in progress.
+func (p *Plugin) mobileTokenClaimed(token string) bool {
+	b, appErr := p.API.KVGet(kvMobileTokenClaimPrefix + token)
+	return appErr == nil && b != nil
+}
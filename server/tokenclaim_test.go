@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// testWAV returns a second of 8 kHz mono 16-bit audio, loud enough to pass the
+// empty-recording check.
+func testWAV() []byte {
+	samples := make([]byte, 8000*2)
+	for i := 0; i < len(samples); i += 2 {
+		binary.LittleEndian.PutUint16(samples[i:], uint16(int16(8000*((i/2)%2*2-1))))
+	}
+	return buildWAV(&wavInfo{format: wavFormatPCM, channels: 1, sampleRate: 8000, bitsPerSample: 16}, samples)
+}
+
+// TestMobileUploadTokenRace runs two uploads with the same token that both read
+// it before either claims it; the second only gets its claim after the first has
+// posted and released. Exactly one post may be created.
+func TestMobileUploadTokenRace(t *testing.T) {
+	const token = "racetoken"
+	api := newFakeKVAPI()
+	mt, _ := json.Marshal(mobileToken{UserID: "user1", ChannelID: "channel1", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	api.kv[kvMobileTokenPrefix+token] = mt
+
+	var posts atomic.Int32
+	api.On("GetChannel", "channel1").Return(&model.Channel{Id: "channel1"}, nil)
+	api.On("GetChannelMember", "channel1", "user1").Return(&model.ChannelMember{}, nil)
+	api.On("HasPermissionToChannel", "user1", "channel1", model.PermissionCreatePost).Return(true)
+	api.On("UploadFile", mock.Anything, "channel1", mock.Anything).Return(&model.FileInfo{Id: "file1"}, nil)
+	api.On("CreatePost", mock.Anything).Return(func(post *model.Post) (*model.Post, *model.AppError) {
+		posts.Add(1)
+		post.Id = model.NewId()
+		return post, nil
+	})
+	api.On("GetConfig").Return(&model.Config{})
+
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	var first atomic.Bool
+	released := make(chan struct{})
+	var releaseOnce sync.Once
+	api.beforeSet = func(key string) {
+		if key != kvMobileTokenClaimPrefix+token {
+			return
+		}
+		arrived.Done()
+		arrived.Wait()
+		if !first.CompareAndSwap(false, true) {
+			<-released
+		}
+	}
+	api.afterDelete = func(key string) {
+		if key == kvMobileTokenClaimPrefix+token {
+			releaseOnce.Do(func() { close(released) })
+		}
+	}
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.configuration = &Configuration{AllowedRoles: "all"}
+
+	codes := make([]int, 2)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/mobile/upload?token="+token, strings.NewReader(string(testWAV())))
+			r.Header.Set("Content-Type", "audio/wav")
+			w := httptest.NewRecorder()
+			p.handleMobileUpload(w, r)
+			codes[i] = w.Code
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), posts.Load())
+	assert.ElementsMatch(t, []int{http.StatusCreated, http.StatusGone}, codes)
+}
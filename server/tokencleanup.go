@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// kvLastTokenCleanupKey holds when expired mobile tokens were last swept, in
+	// Unix milliseconds; it keeps cluster nodes from sweeping at the same time.
+	kvLastTokenCleanupKey = "vm_last_token_cleanup"
+	// kvTokenCleanupCursorKey holds the last mobile token key the previous sweep
+	// looked at; the next sweep carries on after it.
+	kvTokenCleanupCursorKey = "vm_token_cleanup_cursor"
+
+	defaultMobileTokenCleanupMinutes = 60
+
+	// mobileTokenCleanupBatch bounds the tokens looked at in one sweep, so a
+	// sweep doesn't hold up the other maintenance jobs. Successive sweeps work
+	// through the keys in order and start over once they reach the end.
+	mobileTokenCleanupBatch = 1000
+)
+
+// getMobileTokenCleanupInterval returns how often expired mobile tokens are
+// swept from the KV store; 0 disables the sweep.
+func (c *Configuration) getMobileTokenCleanupInterval() time.Duration {
+	if c == nil {
+		return defaultMobileTokenCleanupMinutes * time.Minute
+	}
+	return time.Duration(intFromCfg(c.MobileTokenCleanupMinutes, defaultMobileTokenCleanupMinutes)) * time.Minute
+}
+
+// cleanupExpiredMobileTokens deletes mobile tokens that are past their expiry and
+// the refresh grace period, along with any that don't parse. getMobileToken only
+// removes tokens someone tries to use, so abandoned ones would otherwise stay
+// forever. It runs at most once per interval across the cluster.
+func (p *Plugin) cleanupExpiredMobileTokens() {
+	interval := p.getConfig().getMobileTokenCleanupInterval()
+	if interval <= 0 {
+		return
+	}
+	now := time.Now()
+	old, appErr := p.API.KVGet(kvLastTokenCleanupKey)
+	if appErr != nil {
+		return
+	}
+	if last, _ := strconv.ParseInt(string(old), 10, 64); now.Sub(time.UnixMilli(last)) < interval {
+		return
+	}
+	claimed, appErr := p.API.KVSetWithOptions(kvLastTokenCleanupKey, []byte(strconv.FormatInt(now.UnixMilli(), 10)), model.PluginKVSetOptions{
+		Atomic:   true,
+		OldValue: old,
+	})
+	if appErr != nil || !claimed {
+		return
+	}
+
+	cursor, _ := p.API.KVGet(kvTokenCleanupCursorKey)
+	keys, err := p.listKeysAfter(kvMobileTokenPrefix, string(cursor), mobileTokenCleanupBatch)
+	if err != nil {
+		p.API.LogWarn("Failed to list mobile tokens for cleanup", "err", err.Error())
+		return
+	}
+	// A short batch reached the last token, so the next sweep starts over.
+	if len(keys) < mobileTokenCleanupBatch {
+		appErr = p.API.KVDelete(kvTokenCleanupCursorKey)
+	} else {
+		appErr = p.API.KVSet(kvTokenCleanupCursorKey, []byte(keys[len(keys)-1]))
+	}
+	if appErr != nil {
+		p.API.LogWarn("Failed to save mobile token cleanup cursor", "err", appErr.Error())
+	}
+	removed := 0
+	for _, key := range keys {
+		raw, appErr := p.API.KVGet(key)
+		if appErr != nil || raw == nil {
+			continue
+		}
+		var mt mobileToken
+		if err := json.Unmarshal(raw, &mt); err == nil && now.Unix() < mt.ExpiresAt+mobileTokenRefreshGraceSeconds {
+			continue
+		}
+		// Compare-and-delete, so a token updated meanwhile is kept.
+		if deleted, appErr := p.API.KVCompareAndDelete(key, raw); appErr == nil && deleted {
+			removed++
+		}
+	}
+	if removed > 0 {
+		p.API.LogDebug("Removed expired mobile tokens", "count", removed)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCleanupExpiredMobileTokensPagesPastLiveTokens fills the first batch of
+// keys with live tokens; expired tokens sorting after them must still be
+// removed by a later sweep.
+func TestCleanupExpiredMobileTokensPagesPastLiveTokens(t *testing.T) {
+	api := newFakeKVAPI()
+	live, _ := json.Marshal(mobileToken{UserID: "user1", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	expired, _ := json.Marshal(mobileToken{UserID: "user1", ExpiresAt: time.Now().Add(-24 * time.Hour).Unix()})
+	for i := 0; i < mobileTokenCleanupBatch; i++ {
+		api.kv[fmt.Sprintf("%sa%04d", kvMobileTokenPrefix, i)] = live
+	}
+	for i := 0; i < 10; i++ {
+		api.kv[fmt.Sprintf("%sb%04d", kvMobileTokenPrefix, i)] = expired
+	}
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	countTokens := func() int {
+		keys, err := p.listKeys(kvMobileTokenPrefix, 2*mobileTokenCleanupBatch)
+		assert.NoError(t, err)
+		return len(keys)
+	}
+
+	p.cleanupExpiredMobileTokens()
+	assert.Equal(t, mobileTokenCleanupBatch+10, countTokens(), "the first sweep only sees live tokens")
+	assert.NotEmpty(t, api.kv[kvTokenCleanupCursorKey])
+
+	delete(api.kv, kvLastTokenCleanupKey)
+	p.cleanupExpiredMobileTokens()
+	assert.Equal(t, mobileTokenCleanupBatch, countTokens(), "the second sweep removes the expired tokens")
+	assert.NotContains(t, api.kv, kvTokenCleanupCursorKey, "reaching the end starts the next sweep over")
+}
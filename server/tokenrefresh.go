@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// mobileTokenRefreshGraceSeconds is how long after expiry a token can still be
+// exchanged for a fresh one, so a finished recording can be sent without
+// re-recording.
+const mobileTokenRefreshGraceSeconds = 2 * 60 * 60
+
+// readMobileToken loads a token and its stored bytes without checking its expiry.
+func (p *Plugin) readMobileToken(token string) (*mobileToken, []byte, error) {
+	b, appErr := p.API.KVGet(kvMobileTokenPrefix + token)
+	if appErr != nil {
+		return nil, nil, fmt.Errorf("KVGet: %s", appErr.Error())
+	}
+	if b == nil {
+		return nil, nil, fmt.Errorf("not found")
+	}
+	var mt mobileToken
+	if err := json.Unmarshal(b, &mt); err != nil {
+		return nil, nil, err
+	}
+	if mt.UserID == "" || mt.ChannelID == "" {
+		return nil, nil, fmt.Errorf("invalid")
+	}
+	return &mt, b, nil
+}
+
+// mobileTokenURLs returns the token-bound URLs the recording page uses.
+func (p *Plugin) mobileTokenURLs(token string) (upload, status, refresh, progress string) {
+	api := fmt.Sprintf("%s/plugins/%s/api/v1/", p.getBasePathFromSiteURL(), pluginID)
+	q := "?token=" + url.QueryEscape(token)
+	return api + "mobile/upload" + q, api + "mobile/token-status" + q, api + "mobile/token/refresh" + q, api + "upload/progress" + q
+}
+
+// handleMobileTokenRefresh serves POST /api/v1/mobile/token/refresh?token=...: it
+// swaps a token, expired up to mobileTokenRefreshGraceSeconds ago, for a new one
+// with the same channel, thread and options. Only the token's owner can refresh,
+// and only with a Mattermost session, so a leaked link can't be renewed.
+func (p *Plugin) handleMobileTokenRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Mattermost session required", http.StatusUnauthorized)
+		return
+	}
+	token := strings.TrimSpace(r.URL.Query().Get("token"))
+	if token == "" {
+		http.Error(w, "missing token", http.StatusBadRequest)
+		return
+	}
+
+	mt, raw, err := p.readMobileToken(token)
+	if err != nil || time.Now().Unix() >= mt.ExpiresAt+mobileTokenRefreshGraceSeconds {
+		http.Error(w, "This recording link can no longer be renewed", http.StatusGone)
+		return
+	}
+	if mt.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !p.isUserAllowed(userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if ch, appErr := p.API.GetChannel(mt.ChannelID); appErr != nil || ch.DeleteAt != 0 {
+		_ = p.API.KVDelete(kvMobileTokenPrefix + token)
+		http.Error(w, "This channel is no longer available", http.StatusGone)
+		return
+	}
+	if _, appErr := p.API.GetChannelMember(mt.ChannelID, userID); appErr != nil {
+		http.Error(w, "not a channel member", http.StatusForbidden)
+		return
+	}
+
+	// An upload with this token is running; it will either consume the token or
+	// give it back.
+	if p.mobileTokenClaimed(token) {
+		http.Error(w, tokenInUseMessage, http.StatusConflict)
+		return
+	}
+
+	target := recordingTarget{ChannelID: mt.ChannelID, RootID: mt.RootID, Tags: mt.Tags, Dictate: mt.Dictate, Silent: mt.Silent}
+	newToken, err := p.issueMobileToken(userID, target)
+	if err != nil {
+		p.API.LogError("failed to refresh mobile token", "err", err.Error())
+		http.Error(w, "Failed to renew link", http.StatusInternalServerError)
+		return
+	}
+	// Retire the old token so only one of the two can be used. If an upload
+	// consumed it in the meantime, the new one isn't needed.
+	if claimed, appErr := p.API.KVCompareAndDelete(kvMobileTokenPrefix+token, raw); appErr != nil || !claimed {
+		_ = p.API.KVDelete(kvMobileTokenPrefix + newToken)
+		http.Error(w, "This recording link was already used", http.StatusGone)
+		return
+	}
+	_ = p.setMobileTokenEphemeralPostID(newToken, mt.EphemeralPostID)
+
+	fresh, err := p.getMobileToken(newToken)
+	if err != nil {
+		http.Error(w, "Failed to renew link", http.StatusInternalServerError)
+		return
+	}
+	uploadURL, statusURL, refreshURL, progressURL := p.mobileTokenURLs(newToken)
+	p.rememberCurrentLink(userID, newToken, fresh.ExpiresAt)
+	p.API.LogInfo("Mobile recording link renewed", "user_id", userID, "channel_id", mt.ChannelID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"upload_url":       uploadURL,
+		"token_status_url": statusURL,
+		"refresh_url":      refreshURL,
+		"progress_url":     progressURL,
+		"expires_at":       fresh.ExpiresAt,
+		"expires_in":       max(0, fresh.ExpiresAt-time.Now().Unix()),
+	})
+}
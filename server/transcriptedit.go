@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// maxEditedTranscriptBytes bounds the body of a transcript edit.
+const maxEditedTranscriptBytes = 256 << 10
+
+type transcriptEdit struct {
+	Transcript string `json:"transcript"`
+}
+
+// handleTranscript serves /api/v1/transcript?post_id=... for voice message
+// transcripts. PUT with a JSON body {"transcript": "..."} stores a human
+// correction, by the post author or a channel admin; timings, confidence and the
+// formatted copy describe the machine output, so they are replaced or dropped.
+// DELETE removes the transcript, by the post author or a system admin, so the
+// message can be transcribed afresh, e.g. after a model change.
+func (p *Plugin) handleTranscript(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	postID := r.URL.Query().Get("post_id")
+	if postID == "" {
+		http.Error(w, "post_id required", http.StatusBadRequest)
+		return
+	}
+
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if _, appErr := p.API.GetChannelMember(post.ChannelId, userID); appErr != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		if post.UserId != userID && !p.isSystemAdmin(userID) {
+			http.Error(w, "Only the author or a system admin can clear the transcript", http.StatusForbidden)
+			return
+		}
+	} else if post.UserId != userID && !p.API.HasPermissionToChannel(userID, post.ChannelId, model.PermissionManageChannelRoles) {
+		http.Error(w, "Only the author or a channel admin can edit the transcript", http.StatusForbidden)
+		return
+	}
+	if post.Type != "custom_voice_message" {
+		http.Error(w, "Not a voice message", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		p.clearTranscript(w, post, userID)
+		return
+	}
+
+	var edit transcriptEdit
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxEditedTranscriptBytes)).Decode(&edit); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	transcript := strings.TrimSpace(edit.Transcript)
+	if transcript == "" {
+		http.Error(w, "transcript required", http.StatusBadRequest)
+		return
+	}
+
+	cfg := p.getConfig()
+	updated, err := p.updatePostFresh(postID, func(post *model.Post) {
+		applyEditedTranscript(cfg, post, transcript, userID)
+	})
+	if err != nil {
+		p.API.LogError("UpdatePost failed after transcript edit", "post_id", postID, "err", err.Error())
+		http.Error(w, "Failed to save transcript", http.StatusInternalServerError)
+		return
+	}
+	p.archiveTranscript(updated)
+	p.indexTranscript(cfg, updated, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"transcript": transcript,
+		"formatted":  updated.GetProp("voice_transcript_formatted"),
+		"edited_by":  userID,
+		"edited_at":  updated.GetProp("voice_transcript_edited_at"),
+	})
+}
+
+// clearTranscript removes a voice message transcript. The audio must still be
+// there, since a message whose audio was deleted can't be transcribed again.
+func (p *Plugin) clearTranscript(w http.ResponseWriter, post *model.Post, userID string) {
+	if deleted, _ := post.GetProp("voice_audio_deleted").(bool); deleted {
+		http.Error(w, "The audio of this voice message was deleted; the transcript is all that's left", http.StatusConflict)
+		return
+	}
+	cfg := p.getConfig()
+	if _, err := p.updatePostFresh(post.Id, func(post *model.Post) {
+		removeTranscript(cfg, post)
+	}); err != nil {
+		p.API.LogError("UpdatePost failed after clearing transcript", "post_id", post.Id, "err", err.Error())
+		http.Error(w, "Failed to clear transcript", http.StatusInternalServerError)
+		return
+	}
+	p.API.LogInfo("Voice message transcript cleared", "post_id", post.Id, "user_id", userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"cleared": true})
+}
+
+// transcriptProps are the post props that together make up a stored transcript.
+var transcriptProps = []string{
+	"voice_transcript",
+	"voice_transcript_formatted",
+	"voice_transcript_confidence",
+	"voice_transcript_segments",
+	"voice_transcript_en",
+	"voice_transcript_lang",
+	"voice_transcript_language_warning",
+	"voice_transcript_deferred",
+	"voice_transcript_edited_by",
+	"voice_transcript_edited_at",
+}
+
+// removeTranscript deletes the stored transcript and any copy of it written into
+// the message.
+func removeTranscript(cfg *Configuration, post *model.Post) {
+	removeTranscriptFromMessage(cfg, post)
+	for _, key := range transcriptProps {
+		post.DelProp(key)
+	}
+}
+
+// removeTranscriptFromMessage undoes applyTranscriptToMessage for the stored
+// transcript: appended text is taken off, and a message replaced by the transcript
+// is emptied down to its mention and caption, if any. Messages the author changed
+// since are left alone.
+func removeTranscriptFromMessage(cfg *Configuration, post *model.Post) {
+	// Rebuild what applyTranscriptToMessage wrote, and take it off again.
+	previous := &model.Post{Props: post.GetProps()}
+	applyTranscriptToMessage(cfg, previous, displayTranscript(post))
+	if previous.Message == "" {
+		return
+	}
+	switch cfg.getTranscriptInMessage() {
+	case transcriptInMessageAppend:
+		if strings.HasSuffix(post.Message, previous.Message) {
+			post.Message = strings.TrimSpace(strings.TrimSuffix(post.Message, previous.Message))
+		}
+	case transcriptInMessageReplace:
+		if post.Message == previous.Message {
+			post.Message = voicePostMessage(cfg, voiceCaption(post), isSilentPost(post))
+		}
+	}
+}
+
+// applyEditedTranscript stores a corrected transcript and marks it as edited. A
+// transcript previously written into the message is swapped for the new one.
+func applyEditedTranscript(cfg *Configuration, post *model.Post, transcript, editorID string) {
+	// The translation and detected language describe the audio, not the corrected
+	// text, so they stay.
+	kept := map[string]any{}
+	for _, key := range []string{"voice_transcript_en", "voice_transcript_lang"} {
+		if v := post.GetProp(key); v != nil {
+			kept[key] = v
+		}
+	}
+	removeTranscriptFromMessage(cfg, post)
+	for _, key := range transcriptProps {
+		post.DelProp(key)
+	}
+	for key, v := range kept {
+		post.AddProp(key, v)
+	}
+	post.AddProp("voice_transcript", transcript)
+	if cfg.FormatTranscript {
+		if formatted := formatTranscript(&transcriptionResult{Text: transcript}); formatted != "" {
+			post.AddProp("voice_transcript_formatted", formatted)
+		}
+	}
+	post.AddProp("voice_transcript_edited_by", editorID)
+	post.AddProp("voice_transcript_edited_at", model.GetMillis())
+	applyTranscriptToMessage(cfg, post, displayTranscript(post))
+}
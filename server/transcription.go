@@ -0,0 +1,649 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	maxOverrideModelLen    = 200
+	maxOverrideLanguageLen = 16
+	maxOverridePromptLen   = 1000
+
+	// chunkedTranscriptionBudget bounds the total time spent on one chunked transcription.
+	chunkedTranscriptionBudget = 10 * time.Minute
+
+	// Without TranscriptionTimeoutSeconds, a provider call may take
+	// minTranscriptionTimeout plus one second per audioSecondsPerTimeoutSecond of audio.
+	minTranscriptionTimeout      = 30 * time.Second
+	audioSecondsPerTimeoutSecond = 5
+	maxTranscriptionTimeout      = 15 * time.Minute
+
+	// Retries after the first provider call, and the delay before the first retry;
+	// each later retry waits twice as long, up to maxTranscriptionBackoff.
+	defaultTranscriptionMaxRetries = 1
+	maxTranscriptionMaxRetries     = 10
+	defaultTranscriptionBackoffMs  = 2000
+	maxTranscriptionBackoff        = 30 * time.Second
+
+	// assumedAudioBytesPerSecond estimates the length of audio that can't be probed.
+	// It's a low Opus bitrate, so the estimate errs towards a longer timeout.
+	assumedAudioBytesPerSecond = 4000
+)
+
+// getTranscriptionTimeout returns how long one provider call may take for audio of
+// the given length: TranscriptionTimeoutSeconds when set, else a timeout that
+// grows with the audio so short clips still fail fast.
+func (c *Configuration) getTranscriptionTimeout(audioSeconds float64) time.Duration {
+	if v := intFromCfg(c.TranscriptionTimeoutSeconds, 0); v > 0 {
+		return min(time.Duration(v)*time.Second, maxTranscriptionTimeout)
+	}
+	scaled := minTranscriptionTimeout + time.Duration(audioSeconds/audioSecondsPerTimeoutSecond*float64(time.Second))
+	return min(scaled, maxTranscriptionTimeout)
+}
+
+// getTranscriptionMaxRetries returns how often a failed provider call is retried.
+func (c *Configuration) getTranscriptionMaxRetries() int {
+	return min(intFromCfg(c.TranscriptionMaxRetries, defaultTranscriptionMaxRetries), maxTranscriptionMaxRetries)
+}
+
+// transcriptionBackoff returns the wait before the given retry (1-based):
+// TranscriptionBackoffBaseMs, doubled for each retry after the first, up to
+// maxTranscriptionBackoff. The base is capped before it's converted, so no
+// setting can overflow the delay.
+func (c *Configuration) transcriptionBackoff(retry int) time.Duration {
+	baseMs := intFromCfg(c.TranscriptionBackoffBaseMs, defaultTranscriptionBackoffMs)
+	delay := time.Duration(min(baseMs, int(maxTranscriptionBackoff.Milliseconds()))) * time.Millisecond
+	for i := 1; i < retry && delay < maxTranscriptionBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxTranscriptionBackoff)
+}
+
+// transcriptionAudioSeconds is the audio length the timeout is based on: probed
+// from the container, or estimated from the size.
+func transcriptionAudioSeconds(audioData []byte) float64 {
+	if seconds, ok := probeAudioDuration(audioData); ok {
+		return seconds
+	}
+	return float64(len(audioData)) / assumedAudioBytesPerSecond
+}
+
+// transcriptionTarget is a fully resolved transcription backend: the global
+// configuration with any per-channel override applied.
+type transcriptionTarget struct {
+	Provider string
+	URL      string
+	APIKeys  []string
+	Model    string
+	Language string
+	Prompt   string
+	// ResponseFormat is sent to OpenAI-compatible endpoints; verbose_json also
+	// reports the detected language. Empty means the default of responseFormat.
+	ResponseFormat string
+	// AudioURL, when set, is sent in place of the audio bytes for the provider
+	// to fetch. See withAudioURL.
+	AudioURL string
+	// AudioSeconds is the length of the audio behind AudioURL, for the request
+	// timeout when the bytes aren't loaded.
+	AudioSeconds float64
+	// WordTimestamps asks OpenAI-compatible endpoints for word-level timings.
+	WordTimestamps bool
+	// Translate asks for an English translation instead of a transcript. See
+	// englishTarget.
+	Translate bool
+	// Trace, when set, records each provider request for a debug response. Such a
+	// dry run isn't counted in the usage report.
+	Trace *transcriptionTrace
+}
+
+// responseFormat is the response_format sent to OpenAI-compatible endpoints.
+// Without a fixed language it defaults to verbose_json, so the language Whisper
+// detected comes back, except for OpenAI's gpt-* transcription models, which only
+// return json.
+func (t *transcriptionTarget) responseFormat() string {
+	switch {
+	case t.ResponseFormat != "":
+		return t.ResponseFormat
+	case t.Language == "" && !t.Translate && !strings.HasPrefix(t.Model, "gpt-"):
+		return "verbose_json"
+	default:
+		return "json"
+	}
+}
+
+// channelTranscriptionOverride is stored in KV per channel. Empty fields inherit
+// the global setting.
+type channelTranscriptionOverride struct {
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+	Language string `json:"language,omitempty"`
+	Prompt   string `json:"prompt,omitempty"`
+}
+
+func (o *channelTranscriptionOverride) isEmpty() bool {
+	return o.Provider == "" && o.Model == "" && o.Language == "" && o.Prompt == ""
+}
+
+// validate trims the fields and rejects unknown providers or oversized values.
+func (o *channelTranscriptionOverride) validate() string {
+	o.Provider = strings.TrimSpace(o.Provider)
+	o.Model = strings.TrimSpace(o.Model)
+	o.Language = strings.TrimSpace(o.Language)
+	o.Prompt = strings.TrimSpace(o.Prompt)
+
+	if o.Provider != "" {
+		if _, ok := responseParsers[o.Provider]; !ok {
+			return "Unknown provider"
+		}
+	}
+	if len(o.Model) > maxOverrideModelLen {
+		return "Model name too long"
+	}
+	if len(o.Language) > maxOverrideLanguageLen {
+		return "Language code too long"
+	}
+	if len(o.Prompt) > maxOverridePromptLen {
+		return "Prompt too long"
+	}
+	return ""
+}
+
+// openAIModelPrefixes are the model families OpenAI's transcription endpoint serves
+// (whisper-1, gpt-4o-transcribe, gpt-4o-mini-transcribe).
+var openAIModelPrefixes = []string{"whisper-", "gpt-"}
+
+// transcriptionModelWarning explains why the configured model doesn't fit the
+// provider, or returns "" when it looks right. Custom endpoints accept any model.
+func (c *Configuration) transcriptionModelWarning() string {
+	provider := strings.TrimSpace(c.TranscriptionProvider)
+	configured := strings.TrimSpace(c.TranscriptionModel)
+	switch provider {
+	case "openai":
+		m := c.getTranscriptionModel()
+		if strings.Contains(m, "/") {
+			return fmt.Sprintf("TranscriptionModel %q is a namespaced (DeepInfra/Hugging Face) model name; OpenAI expects a model such as whisper-1 or gpt-4o-transcribe", m)
+		}
+		for _, prefix := range openAIModelPrefixes {
+			if strings.HasPrefix(m, prefix) {
+				return ""
+			}
+		}
+		return fmt.Sprintf("TranscriptionModel %q is not a known OpenAI transcription model (e.g. whisper-1, gpt-4o-transcribe)", m)
+	case "custom":
+		return ""
+	default:
+		if configured != "" && configured != defaultTranscriptionModel {
+			return fmt.Sprintf("TranscriptionModel %q is ignored by the deepinfra provider, whose endpoint always uses %s", configured, defaultTranscriptionModel)
+		}
+		return ""
+	}
+}
+
+// providerOrDefault returns the provider key, with deepinfra for an unset one as
+// transcriptionURLFor does.
+func providerOrDefault(provider string) string {
+	if provider = strings.TrimSpace(provider); provider == "" {
+		return "deepinfra"
+	}
+	return provider
+}
+
+// resolveTranscriptionTarget builds the backend for a channel, consulting the
+// channel's KV override before falling back to the global configuration.
+func (p *Plugin) resolveTranscriptionTarget(cfg *Configuration, channelID string) *transcriptionTarget {
+	target := &transcriptionTarget{
+		Provider: strings.TrimSpace(cfg.TranscriptionProvider),
+		URL:      cfg.getTranscriptionURL(),
+		APIKeys:  cfg.getTranscriptionAPIKeys(),
+		Model:    cfg.getTranscriptionModel(),
+		Language: strings.TrimSpace(cfg.TranscriptionLanguage),
+	}
+	if cfg.TranscriptWordTimestamps {
+		target.WordTimestamps = true
+		target.ResponseFormat = "verbose_json"
+	}
+	if len(cfg.getAllowedTranscriptionLanguages()) > 0 || cfg.AutoTranslateToEnglish {
+		// verbose_json reports the detected language.
+		target.ResponseFormat = "verbose_json"
+	}
+	if channelID == "" {
+		return target
+	}
+
+	override, err := p.getChannelTranscriptionOverride(channelID)
+	if err != nil {
+		p.API.LogWarn("Ignoring channel transcription override", "channel_id", channelID, "err", err.Error())
+		return target
+	}
+	if override == nil {
+		return target
+	}
+	if override.Provider != "" && override.Provider != providerOrDefault(target.Provider) {
+		// The global keys belong to the global provider; another provider gets its
+		// own from ProviderCredentials, or the override isn't used at all.
+		creds, ok := cfg.credentialsFor(override.Provider)
+		if !ok {
+			p.API.LogWarn("Ignoring channel transcription override: no ProviderCredentials for its provider", "channel_id", channelID, "provider", override.Provider)
+			return target
+		}
+		target.Provider = override.Provider
+		target.URL = cfg.transcriptionURLFor(override.Provider)
+		target.APIKeys = creds.keys
+		target.Model = creds.model
+	}
+	if override.Model != "" {
+		target.Model = override.Model
+	}
+	if override.Language != "" {
+		target.Language = override.Language
+	}
+	target.Prompt = override.Prompt
+	return target
+}
+
+// transcriptionAudio is the audio handed to transcribe. A target with an
+// AudioURL lets the provider fetch the file itself, so the bytes are only read
+// from the file store when transcribe needs them.
+type transcriptionAudio struct {
+	data []byte
+	load func() ([]byte, error)
+	// seconds is the reported length, used while the bytes aren't loaded.
+	seconds float64
+}
+
+// heldAudio is audio that is already in memory.
+func heldAudio(data []byte) *transcriptionAudio {
+	return &transcriptionAudio{data: data}
+}
+
+// storedAudio is a file store file that is read on first use. seconds is its
+// reported length, or 0 when unknown.
+func (p *Plugin) storedAudio(fileID string, seconds float64) *transcriptionAudio {
+	return &transcriptionAudio{
+		seconds: seconds,
+		load: func() ([]byte, error) {
+			data, appErr := p.API.GetFile(fileID)
+			if appErr != nil {
+				return nil, fmt.Errorf("input: failed to read audio file: %w", appErr)
+			}
+			return data, nil
+		},
+	}
+}
+
+// bytes returns the audio, reading it the first time.
+func (a *transcriptionAudio) bytes() ([]byte, error) {
+	if a.data == nil && a.load != nil {
+		data, err := a.load()
+		if err != nil {
+			return nil, err
+		}
+		a.data = data
+	}
+	return a.data, nil
+}
+
+// urlFetchFailed reports whether a transcription by URL failed the way a provider
+// that couldn't fetch the signed URL fails: with a client error other than auth or
+// a rate limit. A bad key, a rate limit, an outage or a network error would fail
+// the same way with the bytes, so those are not retried with them.
+func urlFetchFailed(err error) bool {
+	status := providerStatus(err)
+	return status >= 400 && status < 500 && status != http.StatusUnauthorized && status != http.StatusForbidden && status != http.StatusTooManyRequests
+}
+
+// transcribe runs a transcription and records its outcome in the metrics and,
+// unless it is a debug trace, the usage counters. When EnableChunkedTranscription
+// is on, WAV audio longer than one chunk is split and transcribed piece by piece,
+// so clips beyond a single provider call still work. A target with an AudioURL
+// is sent the URL instead of the bytes, without reading the file, unless the
+// audio may need chunking; the bytes are sent only if the provider couldn't
+// fetch the URL. With AutoTranslateToEnglish,
+// audio detected as another language than English is translated as well, unless
+// its transcript is withheld; that second call counts as usage too. The text is
+// run through TranscriptReplacements and segments are merged down to
+// MaxStoredSegments before the result is returned for storage.
+func (p *Plugin) transcribe(audio *transcriptionAudio, mimeType string, target *transcriptionTarget) (*transcriptionResult, error) {
+	var result *transcriptionResult
+	var err error
+	cfg := p.getConfig()
+	byteTarget := target
+	if target.AudioURL != "" {
+		copied := *target
+		copied.AudioURL = ""
+		byteTarget = &copied
+	}
+	// Only WAV is ever chunked, and only the bytes tell whether it needs to be.
+	mayChunk := cfg.EnableChunkedTranscription && extForContentType(mimeType) == ".wav"
+	if target.AudioURL != "" && !mayChunk {
+		urlTarget := *target
+		urlTarget.AudioSeconds = audio.seconds
+		result, err = p.callWhisperAPI(audio.data, mimeType, &urlTarget)
+		if err != nil && urlFetchFailed(err) {
+			p.API.LogWarn("Transcription by URL failed; sending audio bytes", "err", err.Error())
+			result, err = p.transcribeBytes(cfg, audio, mimeType, byteTarget)
+		}
+	} else {
+		result, err = p.transcribeBytes(cfg, audio, mimeType, byteTarget)
+	}
+
+	if err != nil {
+		p.metrics.transcriptionFailures.Add(1)
+		return nil, err
+	}
+	p.metrics.transcriptions.Add(1)
+	// An unknown language is often English from a provider that doesn't report it.
+	translated := false
+	if lang := normalizeLanguage(result.Language); cfg.AutoTranslateToEnglish && lang != "" && lang != "en" && !cfg.withholdsTranscript(result.Language) {
+		if data, err := audio.bytes(); err != nil {
+			p.API.LogWarn("English translation skipped", "err", err.Error())
+		} else {
+			result.English, translated = p.translateToEnglish(cfg, data, mimeType, byteTarget)
+		}
+	}
+	applyTranscriptReplacements(cfg, result)
+	result.Segments = downsampleSegments(result.Segments, cfg.getMaxStoredSegments())
+	result.Words = downsampleSegments(result.Words, cfg.getMaxStoredSegments())
+	seconds, measured := probeAudioDuration(audio.data)
+	if !measured && audio.seconds > 0 {
+		seconds, measured = audio.seconds, true
+	}
+	if target.Trace == nil {
+		p.recordTranscriptionUsage(seconds, measured)
+		if translated {
+			p.recordTranscriptionUsage(seconds, measured)
+		}
+	}
+	return result, nil
+}
+
+// transcribeBytes uploads the audio bytes, in chunks when they need to be.
+func (p *Plugin) transcribeBytes(cfg *Configuration, audio *transcriptionAudio, mimeType string, target *transcriptionTarget) (*transcriptionResult, error) {
+	data, err := audio.bytes()
+	if err != nil {
+		return nil, err
+	}
+	if chunks := p.transcriptionChunks(cfg, data); len(chunks) > 1 {
+		return p.transcribeChunks(chunks, cfg.getTranscriptionChunkSeconds(), target)
+	}
+	return p.callWhisperAPI(data, mimeType, target)
+}
+
+// englishTarget turns a transcription target into one for Whisper's translate
+// task, which outputs English whatever the spoken language. OpenAI serves it on a
+// separate endpoint; DeepInfra and custom servers take a task=translate field.
+// The translate task has no source language, timings or prompt in any of them.
+func englishTarget(target *transcriptionTarget) *transcriptionTarget {
+	copied := *target
+	copied.Translate = true
+	copied.AudioURL = ""
+	copied.Language = ""
+	copied.Prompt = ""
+	copied.WordTimestamps = false
+	if copied.ResponseFormat == "verbose_json" {
+		copied.ResponseFormat = ""
+	}
+	if copied.Provider == "openai" {
+		copied.URL = strings.Replace(copied.URL, "/audio/transcriptions", "/audio/translations", 1)
+	}
+	return &copied
+}
+
+// translateToEnglish returns the English translation of the audio and whether the
+// provider answered, or "" and false when it fails; the native transcript is kept
+// either way.
+func (p *Plugin) translateToEnglish(cfg *Configuration, audioData []byte, mimeType string, target *transcriptionTarget) (string, bool) {
+	target = englishTarget(target)
+	var result *transcriptionResult
+	var err error
+	if chunks := p.transcriptionChunks(cfg, audioData); len(chunks) > 1 {
+		result, err = p.transcribeChunks(chunks, cfg.getTranscriptionChunkSeconds(), target)
+	} else {
+		result, err = p.callWhisperAPI(audioData, mimeType, target)
+	}
+	if err != nil {
+		p.API.LogWarn("English translation failed", "provider", target.Provider, "err", err.Error())
+		return "", false
+	}
+	return strings.TrimSpace(result.Text), true
+}
+
+// transcriptionChunks returns the WAV chunks to send separately, or nil when the
+// audio should go in a single request.
+func (p *Plugin) transcriptionChunks(cfg *Configuration, audioData []byte) [][]byte {
+	if !cfg.EnableChunkedTranscription {
+		return nil
+	}
+	info, err := parseWAV(audioData)
+	if err != nil || info.durationSeconds() <= float64(cfg.getTranscriptionChunkSeconds()) {
+		return nil
+	}
+	return splitWAV(audioData, cfg.getTranscriptionChunkSeconds())
+}
+
+// transcribeChunks transcribes WAV chunks in order and stitches the results,
+// shifting segment timestamps by each chunk's offset. Any failed chunk fails the
+// whole transcription, as does running past chunkedTranscriptionBudget.
+func (p *Plugin) transcribeChunks(chunks [][]byte, chunkSeconds int, target *transcriptionTarget) (*transcriptionResult, error) {
+	deadline := time.Now().Add(chunkedTranscriptionBudget)
+	merged := &transcriptionResult{}
+	texts := make([]string, 0, len(chunks))
+	var confidenceSum float64
+	var confidenceCount int
+
+	for i, chunk := range chunks {
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("network: chunked transcription timed out after %d of %d chunks", i, len(chunks))
+		}
+		p.API.LogDebug("Transcribing chunk", "chunk", i+1, "of", len(chunks), "bytes", len(chunk))
+
+		res, err := p.callWhisperAPI(chunk, "audio/wav", target)
+		if err != nil {
+			return nil, fmt.Errorf("%w (chunk %d of %d)", err, i+1, len(chunks))
+		}
+
+		offset := float64(i * chunkSeconds)
+		for _, seg := range res.Segments {
+			seg.Start += offset
+			seg.End += offset
+			merged.Segments = append(merged.Segments, seg)
+		}
+		for _, word := range res.Words {
+			word.Start += offset
+			word.End += offset
+			merged.Words = append(merged.Words, word)
+		}
+		if res.Text != "" {
+			texts = append(texts, res.Text)
+		}
+		if merged.Language == "" {
+			merged.Language = res.Language
+		}
+		if res.Confidence != nil {
+			confidenceSum += *res.Confidence
+			confidenceCount++
+		}
+	}
+
+	merged.Text = strings.Join(texts, " ")
+	if confidenceCount > 0 {
+		c := confidenceSum / float64(confidenceCount)
+		merged.Confidence = &c
+	}
+	return merged, nil
+}
+
+// acquireChannelTranscription takes one of a channel's auto-transcription slots so
+// a single busy channel can't hold every global transcribeSem slot. limit 0
+// disables the per-channel cap.
+func (p *Plugin) acquireChannelTranscription(channelID string, limit int) bool {
+	p.channelTranscribeLock.Lock()
+	defer p.channelTranscribeLock.Unlock()
+	if limit > 0 && p.channelTranscribing[channelID] >= limit {
+		return false
+	}
+	if p.channelTranscribing == nil {
+		p.channelTranscribing = map[string]int{}
+	}
+	p.channelTranscribing[channelID]++
+	return true
+}
+
+func (p *Plugin) releaseChannelTranscription(channelID string) {
+	p.channelTranscribeLock.Lock()
+	defer p.channelTranscribeLock.Unlock()
+	if p.channelTranscribing[channelID] <= 1 {
+		delete(p.channelTranscribing, channelID)
+		return
+	}
+	p.channelTranscribing[channelID]--
+}
+
+// transcriptionFlight is a manual transcription in progress for one post. The
+// first request for the post runs it; later ones wait on done and answer with its
+// outcome. result, post and err are set before done is closed.
+type transcriptionFlight struct {
+	done   chan struct{}
+	result *transcriptionResult
+	post   *model.Post
+	err    error
+}
+
+// joinTranscription returns the post's in-flight transcription, or starts one
+// when there is none; leader reports whether the caller must run it and then call
+// finishTranscription.
+func (p *Plugin) joinTranscription(postID string) (flight *transcriptionFlight, leader bool) {
+	p.transcriptionFlightLock.Lock()
+	defer p.transcriptionFlightLock.Unlock()
+	if f, ok := p.transcriptionFlights[postID]; ok {
+		return f, false
+	}
+	if p.transcriptionFlights == nil {
+		p.transcriptionFlights = map[string]*transcriptionFlight{}
+	}
+	f := &transcriptionFlight{done: make(chan struct{})}
+	p.transcriptionFlights[postID] = f
+	return f, true
+}
+
+// finishTranscription releases the requests waiting on flight. A leader that
+// returns without a result or error leaves them a generic failure.
+func (p *Plugin) finishTranscription(postID string, flight *transcriptionFlight) {
+	if flight.result == nil && flight.err == nil {
+		flight.err = fmt.Errorf("transcription did not complete")
+	}
+	p.transcriptionFlightLock.Lock()
+	delete(p.transcriptionFlights, postID)
+	p.transcriptionFlightLock.Unlock()
+	close(flight.done)
+}
+
+func (p *Plugin) getChannelTranscriptionOverride(channelID string) (*channelTranscriptionOverride, error) {
+	b, appErr := p.API.KVGet(kvChannelTranscriptionPrefix + channelID)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if b == nil {
+		return nil, nil
+	}
+	var o channelTranscriptionOverride
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// handleChannelTranscription serves /api/v1/channel/transcription?channel_id=...
+// GET returns the channel's override (any member); PUT and DELETE set or clear it
+// and require channel admin rights.
+func (p *Plugin) handleChannelTranscription(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channelID := r.URL.Query().Get("channel_id")
+	if channelID == "" {
+		http.Error(w, "channel_id required", http.StatusBadRequest)
+		return
+	}
+	if _, appErr := p.API.GetChannelMember(channelID, userID); appErr != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodDelete:
+		if !p.API.HasPermissionToChannel(userID, channelID, model.PermissionManageChannelRoles) {
+			http.Error(w, "Only channel admins can change transcription settings", http.StatusForbidden)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := kvChannelTranscriptionPrefix + channelID
+	switch r.Method {
+	case http.MethodPut:
+		var o channelTranscriptionOverride
+		if err := json.NewDecoder(io.LimitReader(r.Body, 16*1024)).Decode(&o); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if msg := o.validate(); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		if cfg := p.getConfig(); o.Provider != "" && o.Provider != providerOrDefault(cfg.TranscriptionProvider) {
+			if _, ok := cfg.credentialsFor(o.Provider); !ok {
+				http.Error(w, "No API key is configured for this provider; an administrator must add it to Provider Credentials", http.StatusBadRequest)
+				return
+			}
+		}
+		if o.isEmpty() {
+			if appErr := p.API.KVDelete(key); appErr != nil {
+				http.Error(w, "Failed to save override", http.StatusInternalServerError)
+				return
+			}
+			break
+		}
+		payload, _ := json.Marshal(o)
+		if appErr := p.API.KVSet(key, payload); appErr != nil {
+			p.API.LogError("KVSet failed for channel transcription override", "err", appErr.Error())
+			http.Error(w, "Failed to save override", http.StatusInternalServerError)
+			return
+		}
+		p.API.LogInfo("Channel transcription override updated", "channel_id", channelID, "user_id", userID)
+	case http.MethodDelete:
+		if appErr := p.API.KVDelete(key); appErr != nil {
+			http.Error(w, "Failed to clear override", http.StatusInternalServerError)
+			return
+		}
+		p.API.LogInfo("Channel transcription override cleared", "channel_id", channelID, "user_id", userID)
+	}
+
+	override, err := p.getChannelTranscriptionOverride(channelID)
+	if err != nil {
+		http.Error(w, "Failed to read override", http.StatusInternalServerError)
+		return
+	}
+	if override == nil {
+		override = &channelTranscriptionOverride{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"channel_id": channelID,
+		"override":   override,
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveTranscriptionTargetProviderOverride(t *testing.T) {
+	api := newFakeKVAPI()
+	override, _ := json.Marshal(channelTranscriptionOverride{Provider: "openai", Language: "de"})
+	api.kv[kvChannelTranscriptionPrefix+"channel1"] = override
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	global := &Configuration{TranscriptionProvider: "deepinfra", TranscriptionAPIKey: "deepinfra-secret"}
+	target := p.resolveTranscriptionTarget(global, "channel1")
+	assert.Equal(t, "deepinfra", target.Provider, "an override without credentials is refused")
+	assert.Equal(t, []string{"deepinfra-secret"}, target.APIKeys)
+	assert.Empty(t, target.Language)
+
+	withCreds := *global
+	withCreds.ProviderCredentials = "openai=openai-secret-1,openai-secret-2\ncustom=custom-key custom-model"
+	target = p.resolveTranscriptionTarget(&withCreds, "channel1")
+	assert.Equal(t, "openai", target.Provider)
+	assert.Equal(t, []string{"openai-secret-1", "openai-secret-2"}, target.APIKeys)
+	assert.Equal(t, "whisper-1", target.Model)
+	assert.Equal(t, "de", target.Language)
+}
+
+func TestGetProviderCredentials(t *testing.T) {
+	cfg := &Configuration{ProviderCredentials: "openai=k1 gpt-4o-transcribe\nbogus=k2\ncustom=\n\ndeepinfra = k3"}
+	creds, invalid := cfg.getProviderCredentials()
+	assert.Equal(t, providerCredentials{keys: []string{"k1"}, model: "gpt-4o-transcribe"}, creds["openai"])
+	assert.Equal(t, providerCredentials{keys: []string{"k3"}}, creds["deepinfra"])
+	assert.Equal(t, []string{"line 2", "line 3"}, invalid)
+
+	_, ok := (&Configuration{ProviderCredentials: "custom=k4"}).credentialsFor("custom")
+	assert.False(t, ok, "a custom endpoint needs a model")
+}
+
+func TestTranscribeByURLReadsFileOnlyOnFetchFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		urlStatus   int
+		wantErr     bool
+		wantGetFile bool
+	}{
+		{"fetched", http.StatusOK, false, false},
+		{"provider can't fetch the URL", http.StatusUnprocessableEntity, false, true},
+		{"bad key", http.StatusUnauthorized, true, false},
+		{"rate limited", http.StatusTooManyRequests, true, false},
+		{"outage", http.StatusBadGateway, true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, _, err := r.FormFile("audio"); err != nil && tc.urlStatus != http.StatusOK {
+					http.Error(w, "failed", tc.urlStatus)
+					return
+				}
+				_, _ = w.Write([]byte(`{"text":"hello"}`))
+			}))
+			defer srv.Close()
+
+			api := newFakeKVAPI()
+			api.On("GetFile", "file1").Return(testWAV(), nil).Maybe()
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.configuration = &Configuration{TranscriptionMaxRetries: "0"}
+			target := &transcriptionTarget{Provider: "deepinfra", URL: srv.URL, APIKeys: []string{"k"}, Model: "m", AudioURL: "https://example.com/signed"}
+
+			result, err := p.transcribe(p.storedAudio("file1", 1), "audio/webm", target)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "hello", result.Text)
+			}
+			if tc.wantGetFile {
+				api.AssertCalled(t, "GetFile", "file1")
+			} else {
+				api.AssertNotCalled(t, "GetFile", "file1")
+			}
+		})
+	}
+}
+
+func TestDebugTranscriptionIsDryRun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"text":"fresh transcript"}`))
+	}))
+	defer srv.Close()
+
+	api := newFakeKVAPI()
+	api.On("GetFile", "file1").Return(testWAV(), nil)
+	p := &Plugin{}
+	p.SetAPI(api)
+	cfg := &Configuration{TranscriptionProvider: "custom", TranscriptionServiceURL: srv.URL, TranscriptionAPIKey: "k", TranscriptionModel: "m", TranscriptionMaxRetries: "0"}
+	p.configuration = cfg
+	post := &model.Post{Id: "post1", ChannelId: "channel1", Type: "custom_voice_message", FileIds: []string{"file1"}}
+	post.AddProp("voice_transcript", "edited transcript")
+	post.AddProp("voice_transcript_edited_by", "user1")
+
+	w := httptest.NewRecorder()
+	p.debugTranscription(w, cfg, post, 1, &transcriptionTrace{})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp map[string]any
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, "fresh transcript", resp["transcript"])
+	assert.NotNil(t, resp["debug"])
+
+	api.AssertNotCalled(t, "UpdatePost", mock.Anything)
+	assert.Equal(t, "edited transcript", post.GetProp("voice_transcript"), "the stored transcript and its edit are kept")
+	assert.Equal(t, "user1", post.GetProp("voice_transcript_edited_by"))
+	for key := range api.kv {
+		assert.NotContains(t, key, kvUsagePrefix, "a dry run isn't counted as usage")
+	}
+}
+
+func TestTranscriptionBackoff(t *testing.T) {
+	cfg := &Configuration{TranscriptionBackoffBaseMs: "1000"}
+	assert.Equal(t, time.Second, cfg.transcriptionBackoff(1))
+	assert.Equal(t, 2*time.Second, cfg.transcriptionBackoff(2))
+	assert.Equal(t, 16*time.Second, cfg.transcriptionBackoff(5))
+	assert.Equal(t, maxTranscriptionBackoff, cfg.transcriptionBackoff(6))
+	assert.Equal(t, maxTranscriptionBackoff, cfg.transcriptionBackoff(100))
+
+	// A huge base or retry count must not overflow into a negative delay.
+	for _, base := range []string{"9223372036854775807", "9223372036854", "40000"} {
+		cfg := &Configuration{TranscriptionBackoffBaseMs: base}
+		for _, retry := range []int{1, 2, 11, 64, 1 << 30} {
+			assert.Equal(t, maxTranscriptionBackoff, cfg.transcriptionBackoff(retry), "base %s, retry %d", base, retry)
+		}
+	}
+
+	assert.Zero(t, (&Configuration{TranscriptionBackoffBaseMs: "0"}).transcriptionBackoff(3))
+}
+
+// testOgg returns an Ogg Opus stream whose last page ends at the given length.
+func testOgg(seconds int) []byte {
+	page := func(granule int64, body []byte) []byte {
+		header := make([]byte, 28)
+		copy(header, "OggS")
+		binary.LittleEndian.PutUint64(header[6:], uint64(granule))
+		header[26] = 1
+		header[27] = byte(len(body))
+		return append(header, body...)
+	}
+	head := append([]byte("OpusHead"), 1, 1, 0, 0)
+	return append(page(0, head), page(int64(seconds)*48000, []byte{0})...)
+}
+
+func TestTranscribeAttachmentChecksDuration(t *testing.T) {
+	api := newFakeKVAPI()
+	api.On("GetFileInfo", "file1").Return(&model.FileInfo{Id: "file1", MimeType: "audio/ogg", Size: 100}, nil)
+	api.On("GetFile", "file1").Return(testOgg(700), nil)
+	p := &Plugin{}
+	p.SetAPI(api)
+	cfg := &Configuration{EnableTranscription: true, TranscriptionMaxDurationSeconds: "600"}
+	post := &model.Post{Id: "post1", ChannelId: "channel1", FileIds: []string{"file1"}}
+
+	w := httptest.NewRecorder()
+	p.handleTranscribeAttachment(w, cfg, post, "file1", true, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "700s > 600s limit")
+}
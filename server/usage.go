@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	kvUsagePrefix = "vm_usage_"
+
+	usageDayTTLSeconds   = 40 * 24 * 60 * 60
+	usageMonthTTLSeconds = 400 * 24 * 60 * 60
+	usageUpdateAttempts  = 5
+)
+
+// usageCounter accumulates successful transcriptions for one period. Audio whose
+// length can't be read from the container (MP3, FLAC, fragmented MP4) is counted
+// in Unmeasured and adds no seconds.
+type usageCounter struct {
+	Transcriptions int64   `json:"transcriptions"`
+	Seconds        float64 `json:"seconds"`
+	Unmeasured     int64   `json:"unmeasured,omitempty"`
+}
+
+// usageKeys returns the KV keys of the UTC day and month containing t.
+func usageKeys(t time.Time) (day, month string) {
+	t = t.UTC()
+	return kvUsagePrefix + "day_" + t.Format("20060102"), kvUsagePrefix + "month_" + t.Format("200601")
+}
+
+// getTranscriptionCostPerMinute returns the configured price per audio minute, or 0.
+func (c *Configuration) getTranscriptionCostPerMinute() float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(c.TranscriptionCostPerMinute), 64)
+	if err != nil || v < 0 {
+		return 0
+	}
+	return v
+}
+
+// recordTranscriptionUsage adds one transcription to today's and this month's
+// counters. Updates are compare-and-set so cluster nodes don't lose counts; after
+// repeated conflicts the increment is dropped and logged.
+func (p *Plugin) recordTranscriptionUsage(seconds float64, measured bool) {
+	day, month := usageKeys(time.Now())
+	p.addUsage(day, usageDayTTLSeconds, seconds, measured)
+	p.addUsage(month, usageMonthTTLSeconds, seconds, measured)
+}
+
+func (p *Plugin) addUsage(key string, ttlSeconds int64, seconds float64, measured bool) {
+	for i := 0; i < usageUpdateAttempts; i++ {
+		old, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			break
+		}
+		var c usageCounter
+		if len(old) > 0 {
+			_ = json.Unmarshal(old, &c)
+		}
+		c.Transcriptions++
+		if measured {
+			c.Seconds += seconds
+		} else {
+			c.Unmeasured++
+		}
+		b, err := json.Marshal(c)
+		if err != nil {
+			break
+		}
+		ok, appErr := p.API.KVSetWithOptions(key, b, model.PluginKVSetOptions{Atomic: true, OldValue: old, ExpireInSeconds: ttlSeconds})
+		if appErr == nil && ok {
+			return
+		}
+	}
+	p.API.LogWarn("Failed to record transcription usage", "key", key)
+}
+
+func (p *Plugin) getUsage(key string) usageCounter {
+	var c usageCounter
+	if b, appErr := p.API.KVGet(key); appErr == nil && len(b) > 0 {
+		_ = json.Unmarshal(b, &c)
+	}
+	return c
+}
+
+// executeUsageCommand handles "/voice usage": transcription counts, audio minutes
+// and an estimated cost for today and this month (UTC), for system admins only.
+func (p *Plugin) executeUsageCommand(args *model.CommandArgs) *model.CommandResponse {
+	if !p.isSystemAdmin(args.UserId) {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "⛔ Only system admins can view transcription usage.",
+			ChannelId:    args.ChannelId,
+		}
+	}
+
+	rate := p.getConfig().getTranscriptionCostPerMinute()
+	day, month := usageKeys(time.Now())
+
+	var sb strings.Builder
+	sb.WriteString("#### Voice Message — transcription usage (UTC)\n\n| Period | Transcriptions | Audio minutes | Est. cost |\n|---|---|---|---|\n")
+	for _, row := range []struct {
+		label string
+		c     usageCounter
+	}{{"Today", p.getUsage(day)}, {"This month", p.getUsage(month)}} {
+		minutes := row.c.Seconds / 60
+		cost := "—"
+		if rate > 0 {
+			cost = fmt.Sprintf("%.2f", minutes*rate)
+		}
+		fmt.Fprintf(&sb, "| %s | %d | %.1f | %s |\n", row.label, row.c.Transcriptions, minutes, cost)
+		if row.c.Unmeasured > 0 {
+			fmt.Fprintf(&sb, "| | %d without a measurable duration | | |\n", row.c.Unmeasured)
+		}
+	}
+	snap := p.metrics.snapshot()
+	fmt.Fprintf(&sb, "\nSince the plugin started: %d transcriptions, %d failed.", snap.Transcriptions, snap.TranscriptionFailures)
+	if rate > 0 {
+		fmt.Fprintf(&sb, " Cost estimated at %g per audio minute.", rate)
+	} else {
+		sb.WriteString(" Set Transcription Cost per Minute to see cost estimates.")
+	}
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         sb.String(),
+		ChannelId:    args.ChannelId,
+	}
+}
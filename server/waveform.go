@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// waveformBuckets matches the bar count of the in-chat player.
+	waveformBuckets = 40
+	// maxWaveformDecodeBytes bounds how much audio one request will decode.
+	maxWaveformDecodeBytes = 64 << 20
+	// waveformSampleRate is what other audio is decoded to for its peaks; 40
+	// bars need far less detail than speech does.
+	waveformSampleRate = 8000
+)
+
+// handleWaveform serves GET /api/v1/waveform?post_id=... with peak data for a
+// voice message, computed from the stored audio and cached in the voice_waveform
+// prop. Uncompressed WAV is read directly; other audio is decoded with ffmpeg,
+// and gets 415 when ffmpeg isn't on the server's PATH.
+func (p *Plugin) handleWaveform(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	postID := r.URL.Query().Get("post_id")
+	if postID == "" {
+		http.Error(w, "post_id required", http.StatusBadRequest)
+		return
+	}
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if _, appErr := p.API.GetChannelMember(post.ChannelId, userID); appErr != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if post.Type != "custom_voice_message" || len(post.FileIds) == 0 {
+		http.Error(w, "Not a voice message", http.StatusBadRequest)
+		return
+	}
+
+	if cached := post.GetProp("voice_waveform"); cached != nil {
+		writeWaveform(w, cached, true)
+		return
+	}
+
+	info, appErr := p.API.GetFileInfo(post.FileIds[0])
+	if appErr != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if info.Size > maxWaveformDecodeBytes {
+		http.Error(w, "Audio file too large to analyze", http.StatusRequestEntityTooLarge)
+		return
+	}
+	data, appErr := p.API.GetFile(info.Id)
+	if appErr != nil {
+		p.API.LogError("GetFile failed", "err", appErr.Error())
+		http.Error(w, "Failed to read audio file", http.StatusInternalServerError)
+		return
+	}
+
+	peaks, err := wavPeaks(data, waveformBuckets)
+	if err != nil {
+		peaks, err = p.decodedPeaks(r.Context(), data, info.Name)
+	}
+	if err != nil {
+		p.API.LogDebug("No waveform computed", "post_id", post.Id, "err", err.Error())
+		http.Error(w, "Waveform is not available for this audio", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	p.storeWaveform(post.Id, peaks)
+	writeWaveform(w, peaks, false)
+}
+
+// addUploadWaveform puts peaks on a new voice message so the player has them
+// without a later request, and reports whether it did. Only WAV is read here;
+// other audio is left to decodeUploadWaveform once the post exists.
+func (p *Plugin) addUploadWaveform(post *model.Post, data []byte) bool {
+	if len(data) > maxWaveformDecodeBytes {
+		return false
+	}
+	peaks, err := wavPeaks(data, waveformBuckets)
+	if err != nil {
+		return false
+	}
+	post.Props["voice_waveform"] = peaks
+	return true
+}
+
+// decodeUploadWaveform decodes a new voice message's audio with ffmpeg and
+// stores its peaks, for the WebM, Ogg and MP4 recordings browsers make. It is
+// best-effort and runs in its own goroutine: without ffmpeg, or on a decode
+// failure, the post keeps the player's placeholder bars.
+func (p *Plugin) decodeUploadWaveform(postID string, data []byte, filename string) {
+	if len(data) > maxWaveformDecodeBytes {
+		return
+	}
+	peaks, err := p.decodedPeaks(context.Background(), data, filename)
+	if err != nil {
+		p.API.LogDebug("No waveform computed for upload", "post_id", postID, "err", err.Error())
+		return
+	}
+	p.storeWaveform(postID, peaks)
+}
+
+// decodedPeaks decodes audio to mono 16-bit PCM with ffmpeg and returns its
+// peaks.
+func (p *Plugin) decodedPeaks(ctx context.Context, data []byte, filename string) ([]float64, error) {
+	samples, err := p.runFFmpeg(ctx, data, filename, maxWaveformDecodeBytes,
+		"-vn", "-ac", "1", "-ar", strconv.Itoa(waveformSampleRate),
+		"-codec:a", "pcm_s16le", "-f", "s16le",
+	)
+	if err != nil {
+		return nil, err
+	}
+	info := &wavInfo{format: wavFormatPCM, channels: 1, sampleRate: waveformSampleRate, bitsPerSample: 16}
+	return wavPeaks(buildWAV(info, samples), waveformBuckets)
+}
+
+// storeWaveform caches peaks on the latest copy of the post, since a decode can
+// take long enough for a transcript or edit to land meanwhile. Failures only
+// cost a recomputation later.
+func (p *Plugin) storeWaveform(postID string, peaks []float64) {
+	if _, err := p.updatePostFresh(postID, func(post *model.Post) {
+		post.AddProp("voice_waveform", peaks)
+	}); err != nil {
+		p.API.LogWarn("Failed to cache waveform", "post_id", postID, "err", err.Error())
+	}
+}
+
+func writeWaveform(w http.ResponseWriter, peaks any, cached bool) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"peaks":  peaks,
+		"cached": cached,
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePCMFFmpeg puts an ffmpeg on PATH that records its arguments and prints
+// half a second of silence, then half a second at full scale, as raw 8 kHz
+// 16-bit PCM.
+func fakePCMFFmpeg(t *testing.T) (argsFile string) {
+	dir := t.TempDir()
+	samples := make([]byte, waveformSampleRate*2)
+	for i := len(samples) / 2; i < len(samples); i += 2 {
+		binary.LittleEndian.PutUint16(samples[i:], uint16(int16(32767)))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pcm"), samples, 0o644))
+	argsFile = filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\ncat " + filepath.Join(dir, "pcm") + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+func TestDecodedPeaks(t *testing.T) {
+	argsFile := fakePCMFFmpeg(t)
+	p := &Plugin{mp3Sem: make(chan struct{}, maxMP3Conversions)}
+
+	webm := []byte{0x1A, 0x45, 0xDF, 0xA3, 0, 0, 0, 0}
+	peaks, err := p.decodedPeaks(context.Background(), webm, "voice.webm")
+	require.NoError(t, err)
+	require.Len(t, peaks, waveformBuckets)
+	assert.Zero(t, peaks[0])
+	assert.InDelta(t, 1, peaks[waveformBuckets-1], 0.01)
+	args, err := os.ReadFile(argsFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(args), "-f matroska -i ")
+	assert.Contains(t, string(args), "-ac 1 -ar 8000 -codec:a pcm_s16le -f s16le pipe:1")
+
+	t.Setenv("PATH", t.TempDir())
+	_, err = p.decodedPeaks(context.Background(), webm, "voice.webm")
+	assert.Error(t, err, "without ffmpeg there is no waveform")
+}
+
+// TestHandleWaveformKeepsNewerProps decodes WebM while a transcript lands on the
+// post; caching the peaks must not put back the copy read before the decode.
+func TestHandleWaveformKeepsNewerProps(t *testing.T) {
+	fakePCMFFmpeg(t)
+	api := newFakeKVAPI()
+	p := &Plugin{mp3Sem: make(chan struct{}, maxMP3Conversions)}
+	p.SetAPI(api)
+
+	reads := 0
+	api.On("GetPost", "post1").Return(func(string) (*model.Post, *model.AppError) {
+		reads++
+		post := &model.Post{Id: "post1", ChannelId: "channel1", Type: "custom_voice_message", FileIds: []string{"file1"}, UpdateAt: 1}
+		if reads > 1 {
+			post.UpdateAt = 2
+			post.AddProp("voice_transcript", "hello")
+		}
+		return post, nil
+	})
+	api.On("GetChannelMember", "channel1", "user1").Return(&model.ChannelMember{}, nil)
+	api.On("GetFileInfo", "file1").Return(&model.FileInfo{Id: "file1", Name: "voice.webm", Size: 8}, nil)
+	api.On("GetFile", "file1").Return([]byte{0x1A, 0x45, 0xDF, 0xA3, 0, 0, 0, 0}, nil)
+	var saved *model.Post
+	api.On("UpdatePost", mock.Anything).Return(func(post *model.Post) (*model.Post, *model.AppError) {
+		saved = post
+		return post, nil
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/waveform?post_id=post1", nil)
+	r.Header.Set("Mattermost-User-Id", "user1")
+	w := httptest.NewRecorder()
+	p.handleWaveform(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotNil(t, saved)
+	assert.Equal(t, "hello", saved.GetProp("voice_transcript"))
+	assert.Len(t, saved.GetProp("voice_waveform"), waveformBuckets)
+}
+
+func TestUploadWaveform(t *testing.T) {
+	fakePCMFFmpeg(t)
+	api := newFakeKVAPI()
+	p := &Plugin{mp3Sem: make(chan struct{}, maxMP3Conversions)}
+	p.SetAPI(api)
+
+	post := &model.Post{Props: model.StringInterface{}}
+	assert.True(t, p.addUploadWaveform(post, testWAV()), "WAV is read at upload")
+	assert.Len(t, post.GetProp("voice_waveform"), waveformBuckets)
+
+	webm := []byte{0x1A, 0x45, 0xDF, 0xA3, 0, 0, 0, 0}
+	post = &model.Post{Props: model.StringInterface{}}
+	require.False(t, p.addUploadWaveform(post, webm))
+	assert.Nil(t, post.GetProp("voice_waveform"))
+
+	// Once the post exists, WebM is decoded with ffmpeg and the peaks are stored.
+	api.On("GetPost", "post1").Return(func(string) (*model.Post, *model.AppError) {
+		return &model.Post{Id: "post1", Type: "custom_voice_message"}, nil
+	})
+	var saved *model.Post
+	api.On("UpdatePost", mock.Anything).Return(func(post *model.Post) (*model.Post, *model.AppError) {
+		saved = post
+		return post, nil
+	})
+	p.decodeUploadWaveform("post1", webm, "voice.webm")
+	require.NotNil(t, saved)
+	peaks, _ := saved.GetProp("voice_waveform").([]float64)
+	require.Len(t, peaks, waveformBuckets)
+	assert.InDelta(t, 1, peaks[waveformBuckets-1], 0.01)
+
+	// Without ffmpeg the post is left alone.
+	saved = nil
+	t.Setenv("PATH", t.TempDir())
+	p.decodeUploadWaveform("post1", webm, "voice.webm")
+	assert.Nil(t, saved)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// EBML element IDs used by the WebM duration probe and fixup. IDs keep their
+// length marker bits, as in the Matroska specification.
+const (
+	ebmlIDHeader        = 0x1A45DFA3
+	ebmlIDSegment       = 0x18538067
+	ebmlIDSeekHead      = 0x114D9B74
+	ebmlIDInfo          = 0x1549A966
+	ebmlIDTracks        = 0x1654AE6B
+	ebmlIDCues          = 0x1C53BB6B
+	ebmlIDTags          = 0x1254C367
+	ebmlIDChapters      = 0x1043A770
+	ebmlIDAttachments   = 0x1941A469
+	ebmlIDTimecodeScale = 0x2AD7B1
+	ebmlIDDuration      = 0x4489
+	ebmlIDCluster       = 0x1F43B675
+	ebmlIDTimecode      = 0xE7
+	ebmlIDSimpleBlock   = 0xA3
+	ebmlIDBlockGroup    = 0xA0
+	ebmlIDBlock         = 0xA1
+
+	webmDefaultTimecodeScale = 1000000 // nanoseconds per tick
+)
+
+// ebmlElement locates one element: offset is where its ID starts, dataOffset where
+// its payload starts. size is -1 for the "unknown size" live-streaming marker.
+type ebmlElement struct {
+	id         uint32
+	offset     int
+	idLen      int
+	dataOffset int
+	size       int
+}
+
+func (e ebmlElement) end(limit int) int {
+	if e.size < 0 || e.dataOffset+e.size > limit {
+		return limit
+	}
+	return e.dataOffset + e.size
+}
+
+// readEBMLElement reads the element header at pos.
+func readEBMLElement(data []byte, pos int) (ebmlElement, bool) {
+	if pos >= len(data) {
+		return ebmlElement{}, false
+	}
+	idLen := vintWidth(data[pos])
+	if idLen == 0 || idLen > 4 || pos+idLen > len(data) {
+		return ebmlElement{}, false
+	}
+	var id uint32
+	for _, b := range data[pos : pos+idLen] {
+		id = id<<8 | uint32(b)
+	}
+
+	sp := pos + idLen
+	if sp >= len(data) {
+		return ebmlElement{}, false
+	}
+	sizeLen := vintWidth(data[sp])
+	if sizeLen == 0 || sp+sizeLen > len(data) {
+		return ebmlElement{}, false
+	}
+	size := uint64(data[sp]) & (0xFF >> sizeLen)
+	allOnes := size == 0xFF>>sizeLen
+	for _, b := range data[sp+1 : sp+sizeLen] {
+		size = size<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+
+	el := ebmlElement{id: id, offset: pos, idLen: idLen, dataOffset: sp + sizeLen, size: -1}
+	if !allOnes {
+		if size > math.MaxInt32 {
+			return ebmlElement{}, false
+		}
+		el.size = int(size)
+	}
+	return el, true
+}
+
+// vintWidth returns the length of a variable-size integer from its first byte.
+func vintWidth(b byte) int {
+	for i := 0; i < 8; i++ {
+		if b&(0x80>>i) != 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// encodeEBMLSize8 writes size as an 8-byte vint, wide enough for any element here.
+func encodeEBMLSize8(size int) []byte {
+	out := make([]byte, 8)
+	binary.BigEndian.PutUint64(out, uint64(size))
+	out[0] = 0x01
+	return out
+}
+
+// isTopLevelWebMID reports whether id starts a new Segment child, which also ends
+// an unknown-size Cluster.
+func isTopLevelWebMID(id uint32) bool {
+	switch id {
+	case ebmlIDSeekHead, ebmlIDInfo, ebmlIDTracks, ebmlIDCluster, ebmlIDCues,
+		ebmlIDTags, ebmlIDChapters, ebmlIDAttachments:
+		return true
+	}
+	return false
+}
+
+// webmLayout is what the probe learned about a WebM file.
+type webmLayout struct {
+	segment       ebmlElement
+	info          *ebmlElement
+	hasSeekHead   bool
+	timecodeScale uint64
+	duration      float64 // Info/Duration in ticks; 0 when absent
+	lastBlockTick int64
+	haveBlocks    bool
+}
+
+// durationSeconds prefers the header's Duration and falls back to the timecode of
+// the last block, which is what Chrome's MediaRecorder leaves us to work with.
+func (l *webmLayout) durationSeconds() float64 {
+	ticks := l.duration
+	if ticks <= 0 && l.haveBlocks {
+		ticks = float64(l.lastBlockTick)
+	}
+	return ticks * float64(l.timecodeScale) / 1e9
+}
+
+// parseWebM walks the EBML header and Segment children, reading Info and the block
+// timecodes of every Cluster. Truncated files are read as far as they go.
+func parseWebM(data []byte) (*webmLayout, error) {
+	hdr, ok := readEBMLElement(data, 0)
+	if !ok || hdr.id != ebmlIDHeader || hdr.size < 0 {
+		return nil, fmt.Errorf("not an EBML file")
+	}
+	seg, ok := readEBMLElement(data, hdr.end(len(data)))
+	if !ok || seg.id != ebmlIDSegment {
+		return nil, fmt.Errorf("no Segment element")
+	}
+
+	l := &webmLayout{segment: seg, timecodeScale: webmDefaultTimecodeScale}
+	segEnd := seg.end(len(data))
+	pos := seg.dataOffset
+	for pos < segEnd {
+		el, ok := readEBMLElement(data, pos)
+		if !ok {
+			break
+		}
+		switch el.id {
+		case ebmlIDSeekHead:
+			l.hasSeekHead = true
+		case ebmlIDInfo:
+			info := el
+			l.info = &info
+			l.readInfo(data, el)
+		case ebmlIDCluster:
+			pos = l.scanCluster(data, el, segEnd)
+			continue
+		}
+		if el.size < 0 {
+			break
+		}
+		pos = el.dataOffset + el.size
+	}
+	return l, nil
+}
+
+func (l *webmLayout) readInfo(data []byte, info ebmlElement) {
+	end := info.end(len(data))
+	for pos := info.dataOffset; pos < end; {
+		el, ok := readEBMLElement(data, pos)
+		if !ok || el.size < 0 || el.dataOffset+el.size > end {
+			return
+		}
+		payload := data[el.dataOffset : el.dataOffset+el.size]
+		switch el.id {
+		case ebmlIDTimecodeScale:
+			var v uint64
+			for _, b := range payload {
+				v = v<<8 | uint64(b)
+			}
+			if v > 0 {
+				l.timecodeScale = v
+			}
+		case ebmlIDDuration:
+			switch len(payload) {
+			case 4:
+				l.duration = float64(math.Float32frombits(binary.BigEndian.Uint32(payload)))
+			case 8:
+				l.duration = math.Float64frombits(binary.BigEndian.Uint64(payload))
+			}
+		}
+		pos = el.dataOffset + el.size
+	}
+}
+
+// scanCluster records the latest block timecode in a Cluster and returns the
+// position after it. Unknown-size clusters end at the next top-level element.
+func (l *webmLayout) scanCluster(data []byte, cluster ebmlElement, limit int) int {
+	end := cluster.end(limit)
+	var clusterTick int64
+	pos := cluster.dataOffset
+	for pos < end {
+		el, ok := readEBMLElement(data, pos)
+		if !ok {
+			return end
+		}
+		if cluster.size < 0 && isTopLevelWebMID(el.id) {
+			return pos
+		}
+		if el.size < 0 || el.dataOffset > end {
+			return end
+		}
+		elEnd := el.end(end)
+		switch el.id {
+		case ebmlIDTimecode:
+			var v int64
+			for _, b := range data[el.dataOffset:elEnd] {
+				v = v<<8 | int64(b)
+			}
+			clusterTick = v
+		case ebmlIDSimpleBlock:
+			l.noteBlock(data[el.dataOffset:elEnd], clusterTick)
+		case ebmlIDBlockGroup:
+			for bp := el.dataOffset; bp < elEnd; {
+				child, ok := readEBMLElement(data, bp)
+				if !ok || child.size < 0 || child.dataOffset > elEnd {
+					break
+				}
+				if child.id == ebmlIDBlock {
+					l.noteBlock(data[child.dataOffset:child.end(elEnd)], clusterTick)
+				}
+				bp = child.end(elEnd)
+			}
+		}
+		pos = elEnd
+	}
+	return end
+}
+
+// noteBlock reads a (Simple)Block's relative timecode: a track-number vint
+// followed by a signed 16-bit offset from the cluster timecode.
+func (l *webmLayout) noteBlock(block []byte, clusterTick int64) {
+	if len(block) == 0 {
+		return
+	}
+	n := vintWidth(block[0])
+	if n == 0 || len(block) < n+2 {
+		return
+	}
+	tick := clusterTick + int64(int16(binary.BigEndian.Uint16(block[n:])))
+	if !l.haveBlocks || tick > l.lastBlockTick {
+		l.lastBlockTick = tick
+		l.haveBlocks = true
+	}
+}
+
+// fixupWebMDuration adds the Segment Info Duration that Chrome's MediaRecorder
+// leaves out, so players can seek and the probe reports the right length. The
+// duration comes from the last block timecode, or fallbackSeconds (the measured
+// recording time) when no blocks could be read. Files that already have a duration,
+// or carry a SeekHead whose offsets the insertion would invalidate, are left alone.
+func fixupWebMDuration(data []byte, fallbackSeconds float64) ([]byte, bool) {
+	l, err := parseWebM(data)
+	if err != nil || l.info == nil || l.info.size < 0 || l.duration > 0 || l.hasSeekHead {
+		return data, false
+	}
+
+	seconds := l.durationSeconds()
+	if seconds <= 0 {
+		seconds = fallbackSeconds
+	}
+	if seconds <= 0 {
+		return data, false
+	}
+	ticks := seconds * 1e9 / float64(l.timecodeScale)
+
+	durElem := make([]byte, 11)
+	durElem[0], durElem[1], durElem[2] = 0x44, 0x89, 0x88 // ID, size 8
+	binary.BigEndian.PutUint64(durElem[3:], math.Float64bits(ticks))
+
+	info := *l.info
+	infoEnd := info.dataOffset + info.size
+	if infoEnd > len(data) {
+		return data, false
+	}
+	newInfo := make([]byte, 0, info.idLen+8+info.size+len(durElem))
+	newInfo = append(newInfo, data[info.offset:info.offset+info.idLen]...)
+	newInfo = append(newInfo, encodeEBMLSize8(info.size+len(durElem))...)
+	newInfo = append(newInfo, data[info.dataOffset:infoEnd]...)
+	newInfo = append(newInfo, durElem...)
+	delta := len(newInfo) - (infoEnd - info.offset)
+
+	seg := l.segment
+	segHeader := append([]byte{}, data[seg.offset:seg.offset+seg.idLen]...)
+	if seg.size < 0 {
+		segHeader = append(segHeader, data[seg.offset+seg.idLen:seg.dataOffset]...)
+	} else {
+		segHeader = append(segHeader, encodeEBMLSize8(seg.size+delta)...)
+	}
+
+	out := make([]byte, 0, len(data)+delta+8)
+	out = append(out, data[:seg.offset]...)
+	out = append(out, segHeader...)
+	out = append(out, data[seg.dataOffset:info.offset]...)
+	out = append(out, newInfo...)
+	out = append(out, data[infoEnd:]...)
+	return out, true
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// truncatedClusterWebM has a one-byte Cluster whose Timecode header runs past
+// the cluster's end.
+const truncatedClusterWebM = "1A45DFA380" + "1853806701FFFFFFFFFFFFFF" + "1F43B67581" + "E7810000"
+
+func TestParseWebMElementPastParent(t *testing.T) {
+	data, err := hex.DecodeString(truncatedClusterWebM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseWebM(data); err != nil {
+		t.Fatalf("parseWebM: %v", err)
+	}
+	probeAudioDuration(data)
+	fixupWebMDuration(data, 1)
+	audioLooksEmpty(data)
+}
+
+func FuzzParseWebM(f *testing.F) {
+	seed, _ := hex.DecodeString(truncatedClusterWebM)
+	f.Add(seed)
+	f.Add([]byte{0x1A, 0x45, 0xDF, 0xA3, 0x80, 0x18, 0x53, 0x80, 0x67, 0x80})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		probeAudioDuration(data)
+		fixupWebMDuration(data, 1)
+		audioLooksEmpty(data)
+	})
+}
@@ -1,5 +1,5 @@
 import React, {useEffect, useState, useCallback} from 'react';
-import {useRecorder} from './useRecorder';
+import {useRecorder, QUIET_LEVEL} from './useRecorder';
 import {uploadVoice, fetchConfig} from './api';
 
 interface Props {
@@ -60,7 +60,7 @@ const RecorderPanel: React.FC<Props> = ({channelId, rootId, onClose, onSent}) =>
         if (!rec.blob) return;
         setSending(true);
         try {
-            await uploadVoice(rec.blob, channelId, rec.duration, rootId);
+            await uploadVoice(rec.blob, channelId, rec.duration, rootId, rec.quality);
             rec.discard();
             onSent();
         } catch (e: any) {
@@ -68,7 +68,7 @@ const RecorderPanel: React.FC<Props> = ({channelId, rootId, onClose, onSent}) =>
         } finally {
             setSending(false);
         }
-    }, [rec.blob, rec.duration, channelId, rootId]);
+    }, [rec.blob, rec.duration, rec.quality, channelId, rootId]);
 
     const onOverlay = (e: React.MouseEvent) => {
         if (e.target === e.currentTarget && rec.state === 'idle') { rec.discard(); onClose(); }
@@ -91,6 +91,9 @@ const RecorderPanel: React.FC<Props> = ({channelId, rootId, onClose, onSent}) =>
 
                 {/* Error banner */}
                 {rec.error && <div className="vm-error">{rec.error}</div>}
+                {rec.state === 'recorded' && rec.quality && rec.quality.avgLevel < QUIET_LEVEL && (
+                    <div className="vm-warning">Your microphone seems quiet. Check the input device or move closer, then record again.</div>
+                )}
 
                 {/* Device selector */}
                 {rec.devices.length > 1 && rec.state === 'idle' && (
@@ -1,8 +1,11 @@
 import React, {useState, useRef, useEffect, useCallback, useMemo} from 'react';
-import {transcribeVoice, fetchConfig, VoiceConfig} from './api';
+import {transcribeVoice, fetchConfig, fetchFeatures, fetchWaveform, VoiceConfig} from './api';
 
 const SPEEDS = [1, 1.25, 1.5, 2];
 const BAR_COUNT = 40;
+// A post younger than this may still get its peaks from the decode the server
+// starts at upload, so the player doesn't ask for them.
+const UPLOAD_WAVEFORM_GRACE_MS = 3 * 60 * 1000;
 const fmt = (s: number) => {
     const m = Math.floor(s / 60);
     const sec = Math.floor(s % 60);
@@ -50,12 +53,28 @@ const ChevronUp = () => (
     </svg>
 );
 
+// PostMessage shows the post text through Mattermost's Markdown formatter, since
+// the server escapes captions for it. Without the formatter it shows the plain
+// caption, or the raw message when there is none.
+const PostMessage: React.FC<{post: any}> = ({post}) => {
+    const content = useMemo(() => {
+        const postUtils = (window as any).PostUtils;
+        if (postUtils?.formatText && postUtils?.messageHtmlToComponent) {
+            const html = postUtils.formatText(post.message, {atMentions: true});
+            return postUtils.messageHtmlToComponent(html, false, {mentionHighlight: true});
+        }
+        return post.props?.voice_caption || post.message;
+    }, [post.message, post.props?.voice_caption]);
+    return <div className="vp-message">{content}</div>;
+};
+
 const VoicePost: React.FC<{post: any; theme?: any}> = ({post}) => {
     const [playing, setPlaying] = useState(false);
     const [curTime, setCurTime] = useState(0);
     const [totalDur, setTotalDur] = useState(0);
     const [spdIdx, setSpdIdx] = useState(0);
     const [transcript, setTranscript] = useState<string | null>(null);
+    const [englishTranscript, setEnglishTranscript] = useState<string | null>(null);
     const [transcribing, setTranscribing] = useState(false);
     const [transcriptError, setTranscriptError] = useState<string | null>(null);
     const [showTranscript, setShowTranscript] = useState(false);
@@ -68,15 +87,42 @@ const VoicePost: React.FC<{post: any; theme?: any}> = ({post}) => {
     const fileIds: string[] = post.file_ids || [];
     const base = (window as any).basename || '';
     const fileURL = fileIds.length > 0 ? `${base}/api/v4/files/${fileIds[0]}` : '';
-    const bars = useMemo(() => genBars(post.id || ''), [post.id]);
+    const [fetchedPeaks, setFetchedPeaks] = useState<number[] | null>(null);
+    const storedWaveform: unknown = post.props?.voice_waveform;
+    const waveform = storedWaveform ?? fetchedPeaks;
+    const bars = useMemo(() => {
+        // Real peaks when the server has computed them; a stable placeholder otherwise.
+        if (Array.isArray(waveform) && waveform.length === BAR_COUNT) {
+            return waveform.map((v) => Math.min(1, Math.max(0.1, Number(v) || 0)));
+        }
+        return genBars(post.id || '');
+    }, [post.id, waveform]);
+
+    // Older posts, and those whose upload decode failed, have no stored peaks;
+    // ask for them when the server can decode the recording.
+    useEffect(() => {
+        if (!fileURL || storedWaveform || Date.now() - (post.create_at || 0) < UPLOAD_WAVEFORM_GRACE_MS) return;
+        let cancelled = false;
+        fetchFeatures()
+            .then((f) => (f.waveform ? fetchWaveform(post.id) : null))
+            .then((w) => { if (!cancelled && w) setFetchedPeaks(w.peaks); })
+            .catch(() => {});
+        return () => { cancelled = true; };
+    }, [post.id, fileURL, storedWaveform, post.create_at]);
 
     // Read existing transcript from post props
-    const existingTranscript = post.props?.voice_transcript || null;
+    const existingTranscript = post.props?.voice_transcript_formatted || post.props?.voice_transcript || null;
 
     useEffect(() => {
         if (existingTranscript) setTranscript(existingTranscript);
     }, [existingTranscript]);
 
+    const existingEnglish: string | null = post.props?.voice_transcript_en || null;
+    const transcriptLang: string | null = post.props?.voice_transcript_lang || null;
+    useEffect(() => {
+        if (existingEnglish) setEnglishTranscript(existingEnglish);
+    }, [existingEnglish]);
+
     useEffect(() => {
         fetchConfig().then(c => setConfig(c)).catch(() => {});
     }, []);
@@ -148,7 +194,8 @@ const VoicePost: React.FC<{post: any; theme?: any}> = ({post}) => {
         setTranscriptError(null);
         try {
             const result = await transcribeVoice(post.id);
-            setTranscript(result.transcript);
+            setTranscript(result.formatted || result.transcript);
+            setEnglishTranscript(result.transcript_en || null);
             setShowTranscript(true);
         } catch (e: any) {
             setTranscriptError(e.message || 'Unknown error');
@@ -157,6 +204,25 @@ const VoicePost: React.FC<{post: any; theme?: any}> = ({post}) => {
         }
     }, [post.id, transcribing]);
 
+    if (!fileURL && post.props?.voice_audio_deleted && transcript) {
+        return (
+            <div className="vp-container">
+                {post.message && <PostMessage post={post}/>}
+                <div className="vp-transcript">
+                    {transcriptLang && <span className="vp-transcript-label">{transcriptLang}</span>}
+                    <div className="vp-transcript-text">{transcript}</div>
+                    {englishTranscript && (
+                        <div className="vp-transcript-text vp-transcript-en">
+                            <span className="vp-transcript-label">English</span>
+                            {englishTranscript}
+                        </div>
+                    )}
+                </div>
+                <div className="vp-unavailable">🎤 Audio removed after transcription</div>
+            </div>
+        );
+    }
+
     if (!fileURL) {
         return <div className="vp-unavailable">🎤 Voice message (file unavailable)</div>;
     }
@@ -164,10 +230,13 @@ const VoicePost: React.FC<{post: any; theme?: any}> = ({post}) => {
     const dur = totalDur || fileDur;
     const progress = dur > 0 ? curTime / dur : 0;
     const playedBars = Math.floor(progress * BAR_COUNT);
-    const canTranscribe = config?.enableTranscription && !transcript;
+    const canTranscribe = config?.transcriptionReady && !transcript;
+    const languageWarning: string | null = post.props?.voice_transcript_language_warning || null;
+    const transcriptPending = canTranscribe && Boolean(post.props?.voice_transcript_deferred) && !transcribing && !transcriptError;
 
     return (
         <div className="vp-container">
+            {post.message && <PostMessage post={post}/>}
             <div className="vp-player">
                 <button className={`vp-play ${playing ? 'vp-play--active' : ''}`} onClick={togglePlay} aria-label={playing ? 'Pause' : 'Play'}>
                     {playing ? <PauseIcon/> : <PlayIcon/>}
@@ -204,14 +273,27 @@ const VoicePost: React.FC<{post: any; theme?: any}> = ({post}) => {
                     </button>
                 )}
             </div>
+            {transcriptPending && (
+                <div className="vp-pending">Transcription pending. Use the transcribe button to retry now.</div>
+            )}
             {transcriptError && !transcript && (
                 <div className="vp-error">{transcriptError}</div>
             )}
             {transcript && showTranscript && (
                 <div className="vp-transcript">
+                    {transcriptLang && <span className="vp-transcript-label">{transcriptLang}</span>}
                     <div className="vp-transcript-text">{transcript}</div>
+                    {englishTranscript && (
+                        <div className="vp-transcript-text vp-transcript-en">
+                            <span className="vp-transcript-label">English</span>
+                            {englishTranscript}
+                        </div>
+                    )}
                 </div>
             )}
+            {languageWarning && !transcriptError && (
+                <div className="vp-error">⚠️ {languageWarning}</div>
+            )}
         </div>
     );
 };
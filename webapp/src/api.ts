@@ -4,10 +4,38 @@ const PLUGIN_ID = 'com.scientia.voice-message';
 export type VoiceConfig = {
     maxDurationSeconds: number;
     enableTranscription: boolean;
+    // False when transcription is enabled but has no endpoint or API key.
+    transcriptionReady: boolean;
     autoTranscribe: boolean;
     transcriptionMaxDuration: number;
 };
 
+export type VoiceFeatures = {
+    transcription: boolean;
+    autoTranscribe: boolean;
+    attachmentTranscription: boolean;
+    chunkedTranscription: boolean;
+    channelTranscriptionOverrides: boolean;
+    mobileRecording: boolean;
+    loudnessNormalization: boolean;
+    silenceAutoStop: boolean;
+    startCountdown: boolean;
+    playbackGate: boolean;
+    playlists: boolean;
+    dictation: boolean;
+    transcriptEdit: boolean;
+    translation: boolean;
+    directMessageRecording: boolean;
+    pauseResume: boolean;
+    silent: boolean;
+    archive: boolean;
+    archiveMP3: boolean;
+    // True when the server can compute peaks for any recording, not just WAV.
+    waveform: boolean;
+    caption: boolean;
+    tags: boolean;
+};
+
 type ReduxStoreLike = { getState: () => any };
 
 function getStore(): ReduxStoreLike | null {
@@ -97,13 +125,42 @@ export async function fetchConfig(): Promise<VoiceConfig> {
     });
 }
 
+let featuresRequest: Promise<VoiceFeatures> | null = null;
+
+/** Fetches the server's feature flags once per page load; every player shares the answer. */
+export function fetchFeatures(): Promise<VoiceFeatures> {
+    if (!featuresRequest) {
+        featuresRequest = fetchJSON<VoiceFeatures>(`${pluginBaseURL()}/api/v1/features`, {
+            method: 'GET',
+            headers: getAuthHeaders(),
+        });
+        featuresRequest.catch(() => { featuresRequest = null; });
+    }
+    return featuresRequest;
+}
+
+export async function fetchWaveform(postId: string): Promise<{peaks: number[]; cached: boolean}> {
+    return fetchJSON<{peaks: number[]; cached: boolean}>(
+        `${pluginBaseURL()}/api/v1/waveform?post_id=${encodeURIComponent(postId)}`,
+        { method: 'GET', headers: getAuthHeaders() },
+    );
+}
+
+/** Recording quality metrics gathered from the analyser loop and sent with the upload. */
+export type RecordingQuality = {avgLevel: number; clipping: number; silenceRatio: number};
+
 export async function uploadVoice(
-    blob: Blob, channelId: string, durationSeconds: number, rootId?: string,
+    blob: Blob, channelId: string, durationSeconds: number, rootId?: string, quality?: RecordingQuality | null,
 ): Promise<{post_id: string; file_id: string}> {
     const params = new URLSearchParams();
     params.set('channel_id', channelId);
     if (rootId) params.set('root_id', rootId);
     params.set('duration', String(Math.max(0, Math.floor(durationSeconds))));
+    if (quality) {
+        params.set('avg_level', quality.avgLevel.toFixed(3));
+        params.set('clipping', String(quality.clipping));
+        params.set('silence_ratio', quality.silenceRatio.toFixed(2));
+    }
 
     return fetchJSON<{post_id: string; file_id: string}>(
         `${pluginBaseURL()}/api/v1/upload?${params.toString()}`,
@@ -111,8 +168,8 @@ export async function uploadVoice(
     );
 }
 
-export async function transcribeVoice(postId: string): Promise<{transcript: string; cached: boolean}> {
-    return fetchJSON<{transcript: string; cached: boolean}>(
+export async function transcribeVoice(postId: string): Promise<{transcript: string; formatted?: string | null; transcript_en?: string | null; cached: boolean}> {
+    return fetchJSON<{transcript: string; formatted?: string | null; transcript_en?: string | null; cached: boolean}>(
         `${pluginBaseURL()}/api/v1/transcribe?post_id=${encodeURIComponent(postId)}`,
         { method: 'POST', headers: getAuthHeaders() },
     );
@@ -84,10 +84,10 @@ class VoiceMessagePlugin {
         // Custom post type renderer
         registry.registerPostTypeComponent('custom_voice_message', VoicePost);
 
-        // Intercept /voice and /vm on web/desktop
+        // Intercept bare /voice and /audiomsg on web/desktop; subcommands go to the server.
         registry.registerSlashCommandWillBePostedHook((message: string, args: any) => {
             const cmd = message.trim();
-            if (cmd === '/voice' || cmd.startsWith('/voice ') || cmd === '/audiomsg' || cmd.startsWith('/audiomsg ')) {
+            if (cmd === '/voice' || cmd === '/audiomsg') {
                 const chId = args?.channel_id || getCurrentChannelId(store);
                 if (chId) {
                     setTimeout(() => (window as any).__vmOpen?.(chId, args?.root_id), 0);
@@ -1,9 +1,14 @@
 import {useState, useRef, useCallback, useEffect} from 'react';
-import {bestMimeType} from './api';
+import {bestMimeType, RecordingQuality} from './api';
 
 export type RecState = 'idle' | 'recording' | 'recorded' | 'error';
 export interface AudioDevice { deviceId: string; label: string }
 
+// Frame levels below SILENCE_LEVEL count as silence; an average below QUIET_LEVEL
+// means the microphone was probably too quiet.
+const SILENCE_LEVEL = 0.05;
+export const QUIET_LEVEL = 0.02;
+
 export function useRecorder(maxSeconds: number) {
     const [state, setState] = useState<RecState>('idle');
     const [duration, setDuration] = useState(0);
@@ -13,6 +18,7 @@ export function useRecorder(maxSeconds: number) {
     const [deviceId, setDeviceId] = useState('');
     const [error, setError] = useState('');
     const [levels, setLevels] = useState<number[]>([]);
+    const [quality, setQuality] = useState<RecordingQuality | null>(null);
 
     const recRef = useRef<MediaRecorder | null>(null);
     const streamRef = useRef<MediaStream | null>(null);
@@ -22,6 +28,7 @@ export function useRecorder(maxSeconds: number) {
     const analyserRef = useRef<AnalyserNode | null>(null);
     const rafRef = useRef(0);
     const actxRef = useRef<AudioContext | null>(null);
+    const qRef = useRef({frames: 0, levelSum: 0, clipped: 0, silent: 0});
 
     const cleanup = useCallback(() => {
         streamRef.current?.getTracks().forEach(t => t.stop());
@@ -60,12 +67,23 @@ export function useRecorder(maxSeconds: number) {
         const bars = 32;
         const step = Math.floor(data.length / bars);
         const result: number[] = [];
+        let level = 0;
         for (let i = 0; i < bars; i++) {
             let sum = 0;
             for (let j = 0; j < step; j++) sum += data[i * step + j];
             result.push(sum / step / 255);
+            level += sum / step / 255 / bars;
         }
         setLevels(result);
+
+        const q = qRef.current;
+        q.frames++;
+        q.levelSum += level;
+        if (level < SILENCE_LEVEL) q.silent++;
+        const wave = new Uint8Array(a.fftSize);
+        a.getByteTimeDomainData(wave);
+        if (wave.some(v => v === 0 || v === 255)) q.clipped++;
+
         rafRef.current = requestAnimationFrame(updateLevels);
     }, []);
 
@@ -73,6 +91,8 @@ export function useRecorder(maxSeconds: number) {
         setError('');
         chunks.current = [];
         setLevels([]);
+        setQuality(null);
+        qRef.current = {frames: 0, levelSum: 0, clipped: 0, silent: 0};
 
         const mime = bestMimeType();
         if (!mime) { setError('Browser does not support audio recording.'); setState('error'); return; }
@@ -103,6 +123,10 @@ export function useRecorder(maxSeconds: number) {
                 if (url) URL.revokeObjectURL(url);
                 const u = URL.createObjectURL(b);
                 setBlob(b); setUrl(u); setState('recorded'); setLevels([]);
+                const q = qRef.current;
+                if (q.frames > 0) {
+                    setQuality({avgLevel: q.levelSum / q.frames, clipping: q.clipped, silenceRatio: q.silent / q.frames});
+                }
                 cleanup();
             };
             rec.onerror = () => { setError('Recording error.'); setState('error'); cleanup(); };
@@ -134,10 +158,10 @@ export function useRecorder(maxSeconds: number) {
     const discard = useCallback(() => {
         cleanup();
         if (url) URL.revokeObjectURL(url);
-        setBlob(null); setUrl(''); setDuration(0); setLevels([]); setState('idle'); chunks.current = [];
+        setBlob(null); setUrl(''); setDuration(0); setLevels([]); setQuality(null); setState('idle'); chunks.current = [];
     }, [url, cleanup]);
 
-    return {state, duration, blob, url, devices, deviceId, error, levels, start, stop, discard, setDeviceId, loadDevices};
+    return {state, duration, blob, url, devices, deviceId, error, levels, quality, start, stop, discard, setDeviceId, loadDevices};
 }
 
 function micError(e: any): string {